@@ -0,0 +1,89 @@
+package zeken
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/igolaizola/zeken/pkg/trade"
+)
+
+// parseAction parses a message as a follow-up if the parser supports them or
+// as a new signal
+func parseAction(parser signal.Parser, text string) (*signal.Action, error) {
+	if ap, ok := parser.(signal.ActionParser); ok {
+		action, err := ap.ParseAction(text)
+		if err == nil {
+			return action, nil
+		}
+		if !errors.Is(err, signal.ErrNoAction) {
+			return nil, err
+		}
+	}
+	sig, err := parser.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &signal.Action{Type: signal.NewSignal, Signal: sig}, nil
+}
+
+// apply starts a trade for new signals or applies the follow-up to the trade
+// of the message
+func (b *Bot) apply(src *source, msg *signal.Message, action *signal.Action) error {
+	if action.Type == signal.NewSignal {
+		if action.Signal == nil {
+			return fmt.Errorf("zeken: %w: missing signal", errInvalidSignal)
+		}
+		// Replies can only be follow-ups of a previous signal
+		if msg.ReplyTo != "" {
+			return fmt.Errorf("zeken: %w: reply to message %s isn't a follow-up", errInvalidSignal, msg.ReplyTo)
+		}
+		return b.signal(action.Signal, src, msg.ID)
+	}
+	t, err := b.follow(src, msg, action)
+	if err != nil {
+		return err
+	}
+	switch action.Type {
+	case signal.CloseTrade:
+		t.Sell()
+	case signal.CancelPending:
+		if !t.Snapshot().Pending() {
+			return fmt.Errorf("zeken: %w: trade %s has already been bought", errInvalidSignal, t.ID)
+		}
+		t.Sell()
+	case signal.MoveStop:
+		err = t.MoveStop(action.StopPrice)
+	case signal.UpdateTargets:
+		err = t.UpdateTargets(action.Targets)
+	default:
+		return fmt.Errorf("zeken: action %s %w", action.Type, errUnsupported)
+	}
+	if err != nil {
+		return fmt.Errorf("zeken: %w: %v", errInvalidSignal, err)
+	}
+	return nil
+}
+
+// follow returns the trade a follow-up refers to, the trade started by the
+// message it replies to or the oldest trade of the source with the pair
+func (b *Bot) follow(src *source, msg *signal.Message, action *signal.Action) (*trade.Trader, error) {
+	for _, t := range b.openTrades() {
+		if t.Source != src.Name {
+			continue
+		}
+		if msg.ReplyTo != "" {
+			if t.SignalID == msg.ReplyTo {
+				return t, nil
+			}
+			continue
+		}
+		if action.Base != "" && t.Base == action.Base && (action.Quote == "" || t.Quote == action.Quote) {
+			return t, nil
+		}
+	}
+	if msg.ReplyTo != "" {
+		return nil, fmt.Errorf("zeken: %w for message %s", errNoTrade, msg.ReplyTo)
+	}
+	return nil, fmt.Errorf("zeken: %w for %s", errNoTrade, action.Base)
+}
@@ -0,0 +1,440 @@
+package zeken
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/igolaizola/zeken/pkg/signal/parser"
+	"github.com/igolaizola/zeken/pkg/sizing"
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/igolaizola/zeken/pkg/trade/inmem"
+	"github.com/shopspring/decimal"
+)
+
+// Message is a signal chat message
+type Message struct {
+	Time time.Time
+	Text string
+}
+
+// BacktestTrade is a trade simulated against historical prices
+type BacktestTrade struct {
+	*trade.Trade
+	Profit decimal.Decimal
+	// Open is true if the trade was still running when klines ended
+	Open bool
+}
+
+type BacktestReport struct {
+	Trades      []*BacktestTrade
+	Rejected    []error
+	Wins        int
+	Losses      int
+	Profit      decimal.Decimal
+	MaxDrawdown decimal.Decimal
+}
+
+// WinRate returns the ratio of trades with profit
+func (r *BacktestReport) WinRate() decimal.Decimal {
+	if len(r.Trades) == 0 {
+		return decimal.Zero
+	}
+	return decimal.NewFromInt(int64(r.Wins)).Div(decimal.NewFromInt(int64(len(r.Trades))))
+}
+
+// BacktestConfig is the configuration of a backtest, it uses the same
+// settings as the bot
+type BacktestConfig struct {
+	Parser string
+	// Source is parsed with ParseSourceConfig, its settings are applied to
+	// all the messages and its chat is ignored
+	Source       string
+	MaxTrades    int
+	MaxTarget    int
+	BalanceRatio float64
+	Sizing       string
+	MinNotional  float64
+	Currency     string
+	TrailingStop string
+	// TrailingStep is a percentage
+	TrailingStep float64
+	TakeProfit   string
+	SamePair     bool
+	// Balance is the initial balance in quote currency
+	Balance decimal.Decimal
+}
+
+// Backtest runs signal messages against historical klines through a bot, so
+// the same validation, sizing and trading rules are applied.
+// Trades are simulated in chronological order and the balance is updated
+// when each trade finishes.
+func Backtest(ctx context.Context, log func(v ...interface{}), klines backtest.Klines, messages []Message, cfg BacktestConfig) (*BacktestReport, error) {
+	signalParser, err := parser.NewParser(cfg.Parser)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't create parser %s: %w", cfg.Parser, err)
+	}
+	trailing, err := trade.ParseTrailingStop(cfg.TrailingStop, cfg.TrailingStep)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't parse trailing stop: %w", err)
+	}
+	allocation, err := trade.ParseAllocation(cfg.TakeProfit)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't parse take profit: %w", err)
+	}
+	src := &source{}
+	if cfg.Source != "" {
+		c, err := ParseSourceConfig(cfg.Source)
+		if err != nil {
+			return nil, err
+		}
+		if c.ParserName != "" {
+			c.Parser, err = parser.NewParser(c.ParserName)
+			if err != nil {
+				return nil, fmt.Errorf("zeken: couldn't create parser %s for source %s: %w", c.ParserName, c.Name, err)
+			}
+		}
+		src.SourceSettings = c.SourceSettings
+	}
+	opts := []Option{
+		WithMaxTrades(cfg.MaxTrades),
+		WithMaxTarget(cfg.MaxTarget),
+		WithBalanceRatio(cfg.BalanceRatio),
+		WithMinNotional(decimal.NewFromFloat(cfg.MinNotional)),
+		WithCurrency(cfg.Currency),
+		WithTrailingStop(trailing),
+		WithAllocation(allocation),
+		WithSamePair(cfg.SamePair),
+		WithLog(log),
+	}
+	if cfg.Sizing != "" {
+		strategy, err := sizing.Parse(cfg.Sizing)
+		if err != nil {
+			return nil, fmt.Errorf("zeken: couldn't parse sizing: %w", err)
+		}
+		opts = append(opts, WithSizing(strategy))
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Time.Before(messages[j].Time)
+	})
+
+	// The bot decides which signals are traded and their size, the trades
+	// are then simulated on their own
+	clk := clock.NewVirtual(time.Time{})
+	account := &backtestAccount{
+		Exchange: backtest.New(clk, klines, nil),
+		currency: cfg.Currency,
+		balance:  cfg.Balance,
+	}
+	store := inmem.New()
+	b := New(backtestMessenger{log: log}, account, store, signalParser, append(opts, WithClock(clk))...)
+	msgParser := src.Parser
+	if msgParser == nil {
+		msgParser = signalParser
+	}
+
+	report := &BacktestReport{}
+	var open []*BacktestTrade
+
+	// release removes finished trades from the bot and returns their balance
+	release := func(until time.Time) error {
+		var running []*BacktestTrade
+		for _, t := range open {
+			if t.EndTime.After(until) {
+				running = append(running, t)
+				continue
+			}
+			b.lock.Lock()
+			delete(b.trades, t.ID)
+			b.lock.Unlock()
+			account.balance = account.balance.Add(t.EndQuoteQuantity)
+			// Stored trades are the source history used by sizing
+			if t.Open {
+				continue
+			}
+			if err := store.Update(t.Trade); err != nil {
+				return fmt.Errorf("zeken: couldn't store trade: %w", err)
+			}
+		}
+		open = running
+		return nil
+	}
+
+	for _, msg := range messages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Follow-ups can't be applied because trades are simulated on arrival
+		action, err := parseAction(msgParser, msg.Text)
+		if err != nil || action.Type != signal.NewSignal || action.Signal == nil {
+			continue
+		}
+		sig := action.Signal
+		clk.Set(msg.Time.UTC())
+		if err := release(msg.Time); err != nil {
+			return nil, err
+		}
+		reject := func(err error) {
+			report.Rejected = append(report.Rejected, fmt.Errorf("%s %s: %w", msg.Time.Format(time.RFC3339), sig.Base, err))
+		}
+		trader, err := b.open(sig, src, "")
+		if err != nil {
+			reject(err)
+			continue
+		}
+		bt, err := backtestTrade(ctx, log, klines, trader.Trade, src.maxTarget(b.maxTarget), account.balance)
+		if err != nil {
+			b.lock.Lock()
+			delete(b.trades, trader.ID)
+			b.lock.Unlock()
+			reject(err)
+			continue
+		}
+		// The trade was simulated by another trader, the bot sees its state
+		// through a new one until it is released
+		b.lock.Lock()
+		b.trades[bt.ID] = trade.NewTrader(b.bus.Publish, account, clk, bt.Trade, src.maxTarget(b.maxTarget), b.wait, store.Update, nil)
+		b.lock.Unlock()
+		account.balance = account.balance.Sub(bt.QuoteQuantity)
+		open = append(open, bt)
+		report.Trades = append(report.Trades, bt)
+	}
+
+	// Calculate totals using trade end times
+	finished := make([]*BacktestTrade, len(report.Trades))
+	copy(finished, report.Trades)
+	sort.SliceStable(finished, func(i, j int) bool {
+		return finished[i].EndTime.Before(finished[j].EndTime)
+	})
+	peak := decimal.Zero
+	report.Profit = decimal.Zero
+	report.MaxDrawdown = decimal.Zero
+	for _, t := range finished {
+		report.Profit = report.Profit.Add(t.Profit)
+		if t.Profit.GreaterThan(decimal.Zero) {
+			report.Wins++
+		} else {
+			report.Losses++
+		}
+		peak = decimal.Max(peak, report.Profit)
+		report.MaxDrawdown = decimal.Max(report.MaxDrawdown, peak.Sub(report.Profit))
+	}
+	return report, nil
+}
+
+// backtestAccount is the exchange of the backtest bot, its balance is
+// updated when simulated trades start and finish
+type backtestAccount struct {
+	exchange.Exchange
+	currency string
+	balance  decimal.Decimal
+}
+
+func (a *backtestAccount) Balance(ctx context.Context, currency string) (decimal.Decimal, error) {
+	if currency != a.currency {
+		return decimal.Zero, nil
+	}
+	return a.balance, nil
+}
+
+// backtestMessenger prints the logs of the backtest bot, it has no commands
+type backtestMessenger struct {
+	log func(v ...interface{})
+}
+
+func (m backtestMessenger) Print(v ...interface{}) {
+	m.log(v...)
+}
+
+func (backtestMessenger) HandleCommand(string, func(string)) {}
+
+func (backtestMessenger) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// backtestTrade runs a single trade using a simulated clock that starts at
+// the trade start time and stops when klines end
+func backtestTrade(ctx context.Context, log func(v ...interface{}), klines backtest.Klines, tr *trade.Trade, maxTarget int, available decimal.Decimal) (*BacktestTrade, error) {
+	clk := clock.NewVirtual(tr.StartTime)
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{tr.Quote: available})
+	symbol := ex.Symbol(tr.Base, tr.Quote)
+	candles, err := klines.Candles(symbol)
+	if err != nil {
+		return nil, err
+	}
+	if tr.StartTime.Before(candles[0].OpenTime) || tr.StartTime.After(candles[len(candles)-1].CloseTime) {
+		return nil, fmt.Errorf("zeken: no klines for %s at %s", symbol, tr.StartTime.Format(time.RFC3339))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dc := &deadlineClock{
+		Virtual: clk,
+		end:     candles[len(candles)-1].CloseTime,
+		cancel:  cancel,
+	}
+	trader := trade.NewTrader(event.Printer(log), ex, dc, tr, maxTarget, 5*time.Second, func(*trade.Trade) error { return nil }, nil)
+	if err := trader.Create(ctx); err != nil {
+		if errors.Is(err, context.Canceled) && !dc.Now().Before(dc.end) {
+			return nil, errors.New("zeken: price didn't reach the entry zone before klines ended")
+		}
+		return nil, err
+	}
+	bt := &BacktestTrade{Trade: tr}
+	err = trader.Run(ctx)
+	switch {
+	case errors.Is(err, context.Canceled) && !dc.Now().Before(dc.end):
+		// Klines ended, use last price to calculate profit
+		price, err := ex.Price(context.Background(), symbol)
+		if err != nil {
+			return nil, err
+		}
+		tr.EndQuoteQuantity = tr.Value(price)
+		tr.EndTime = dc.end
+		bt.Open = true
+	case err != nil:
+		return nil, err
+	}
+	bt.Profit = tr.EndQuoteQuantity.Sub(tr.QuoteQuantity)
+	return bt, nil
+}
+
+// deadlineClock cancels the trade when the deadline is reached
+type deadlineClock struct {
+	*clock.Virtual
+	end    time.Time
+	cancel context.CancelFunc
+}
+
+func (c *deadlineClock) After(d time.Duration) <-chan time.Time {
+	ch := c.Virtual.After(d)
+	if c.Now().After(c.end) {
+		c.Set(c.end)
+		c.cancel()
+	}
+	return ch
+}
+
+// ReadMessages reads signal messages from a telegram chat export in JSON
+// format or from a text file with a message per line.
+// Each line must start with a RFC3339 time followed by the message text,
+// new lines inside the text must be escaped as `\n`.
+func ReadMessages(r io.Reader) ([]Message, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't read messages: %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return readTelegramExport(data)
+	}
+	var msgs []Message
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		split := strings.SplitN(line, " ", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("zeken: invalid message at line %d", n)
+		}
+		t, err := time.Parse(time.RFC3339, split[0])
+		if err != nil {
+			return nil, fmt.Errorf("zeken: couldn't parse time at line %d: %w", n, err)
+		}
+		msgs = append(msgs, Message{
+			Time: t.UTC(),
+			Text: strings.ReplaceAll(strings.TrimSpace(split[1]), `\n`, "\n"),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("zeken: couldn't read messages: %w", err)
+	}
+	return msgs, nil
+}
+
+type telegramExport struct {
+	Messages []struct {
+		Type         string          `json:"type"`
+		Date         string          `json:"date"`
+		DateUnixtime string          `json:"date_unixtime"`
+		ReplyTo      int             `json:"reply_to_message_id"`
+		Text         json.RawMessage `json:"text"`
+	} `json:"messages"`
+}
+
+func readTelegramExport(data []byte) ([]Message, error) {
+	var export telegramExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("zeken: couldn't decode telegram export: %w", err)
+	}
+	var msgs []Message
+	for _, m := range export.Messages {
+		// Replies are skipped as done with the signal chat
+		if m.Type != "message" || m.ReplyTo != 0 {
+			continue
+		}
+		var t time.Time
+		if unix, err := strconv.ParseInt(m.DateUnixtime, 10, 64); err == nil {
+			t = time.Unix(unix, 0).UTC()
+		} else {
+			t, err = time.Parse("2006-01-02T15:04:05", m.Date)
+			if err != nil {
+				return nil, fmt.Errorf("zeken: couldn't parse date %s: %w", m.Date, err)
+			}
+		}
+		text, err := exportText(m.Text)
+		if err != nil {
+			return nil, err
+		}
+		if text == "" {
+			continue
+		}
+		msgs = append(msgs, Message{Time: t, Text: text})
+	}
+	return msgs, nil
+}
+
+// exportText joins the text of a telegram export message, which can be a
+// plain string or a list of strings and formatted entities
+func exportText(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("zeken: couldn't decode message text: %w", err)
+	}
+	sb := &strings.Builder{}
+	for _, p := range parts {
+		var s string
+		if err := json.Unmarshal(p, &s); err == nil {
+			sb.WriteString(s)
+			continue
+		}
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(p, &entity); err != nil {
+			return "", fmt.Errorf("zeken: couldn't decode message text: %w", err)
+		}
+		sb.WriteString(entity.Text)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,175 @@
+package zeken
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/shopspring/decimal"
+)
+
+func TestReadMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "lines",
+			data: `2021-09-01T00:00:00Z {"base": "IGO"}
+2021-09-01T01:00:00Z 🔥BINANCE\nIGO-USDT
+`,
+		},
+		{
+			name: "telegram export",
+			data: `{
+	"name": "signals",
+	"messages": [
+		{"id": 1, "type": "message", "date": "2021-09-01T02:00:00", "date_unixtime": "1630454400", "text": "{\"base\": \"IGO\"}"},
+		{"id": 2, "type": "message", "date": "2021-09-01T02:30:00", "date_unixtime": "1630456200", "reply_to_message_id": 1, "text": "reply"},
+		{"id": 3, "type": "service", "date": "2021-09-01T02:40:00", "date_unixtime": "1630456800", "text": ""},
+		{"id": 4, "type": "message", "date": "2021-09-01T03:00:00", "date_unixtime": "1630458000", "text": [{"type": "bold", "text": "🔥BINANCE"}, "\nIGO-USDT"]}
+	]
+}`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := ReadMessages(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != 2 {
+				t.Fatalf("wrong number of messages: want 2, got %d", len(msgs))
+			}
+			if want := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC); !msgs[0].Time.Equal(want) {
+				t.Errorf("wrong time: want %s, got %s", want, msgs[0].Time)
+			}
+			if want := "🔥BINANCE\nIGO-USDT"; msgs[1].Text != want {
+				t.Errorf("wrong text: want %q, got %q", want, msgs[1].Text)
+			}
+		})
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	signal := `{"exchanges": ["BINANCE"], "base": "%s", "quote": "USDT", "start": "10", "targets": ["11", "12"], "stop": "9"}`
+	msgs := []Message{
+		{Time: start, Text: strings.ReplaceAll(signal, "%s", "UP")},
+		{Time: start.Add(time.Minute), Text: strings.ReplaceAll(signal, "%s", "DOWN")},
+		{Time: start.Add(2 * time.Minute), Text: strings.ReplaceAll(signal, "%s", "UP")},
+		{Time: start.Add(3 * time.Minute), Text: "not a signal"},
+	}
+	klines := backtest.Series{
+		"UPUSDT":   newCandles(start, 10.0, 0.1, 100),
+		"DOWNUSDT": newCandles(start, 10.0, -0.1, 100),
+	}
+	report, err := Backtest(context.Background(), func(...interface{}) {}, klines, msgs, BacktestConfig{
+		Parser:       "json",
+		MaxTrades:    2,
+		MaxTarget:    5,
+		BalanceRatio: 1.0,
+		Currency:     "USDT",
+		Balance:      decimal.NewFromInt(100),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Trades) != 2 {
+		t.Fatalf("wrong number of trades: want 2, got %d", len(report.Trades))
+	}
+	if len(report.Rejected) != 1 {
+		t.Errorf("wrong number of rejected signals: want 1, got %d", len(report.Rejected))
+	}
+	if report.Wins != 1 || report.Losses != 1 {
+		t.Errorf("wrong wins and losses: want 1/1, got %d/%d", report.Wins, report.Losses)
+	}
+	// UP: 50 USDT bought at 10 and sold at 12
+	// DOWN: 49.5 USDT (99% of available) bought at 9.9 and sold at 9
+	if want := decimal.NewFromFloat(5.5); !report.Profit.Equal(want) {
+		t.Errorf("wrong profit: want %s, got %s", want, report.Profit)
+	}
+	// DOWN finishes first
+	if want := decimal.NewFromFloat(4.5); !report.MaxDrawdown.Equal(want) {
+		t.Errorf("wrong max drawdown: want %s, got %s", want, report.MaxDrawdown)
+	}
+}
+
+func TestBacktestSettings(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	signal := `{"exchanges": ["BINANCE"], "base": "%s", "quote": "USDT", "start": "10", "targets": ["11", "12"], "stop": "9"}`
+	msgs := []Message{
+		{Time: start, Text: strings.ReplaceAll(signal, "%s", "UP")},
+		{Time: start.Add(time.Minute), Text: strings.ReplaceAll(signal, "%s", "DOWN")},
+	}
+	klines := backtest.Series{
+		"UPUSDT":   newCandles(start, 10.0, 0.1, 100),
+		"DOWNUSDT": newCandles(start, 10.0, -0.1, 100),
+	}
+	tests := []struct {
+		name     string
+		cfg      BacktestConfig
+		trades   int
+		rejected string
+	}{
+		{
+			name:     "source max trades",
+			cfg:      BacktestConfig{Source: "name=vip,chat=-100123,max-trades=1"},
+			trades:   1,
+			rejected: "max_trades",
+		},
+		{
+			name:     "min notional",
+			cfg:      BacktestConfig{MinNotional: 50},
+			trades:   1,
+			rejected: "size",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Parser = "json"
+			cfg.MaxTrades = 2
+			cfg.MaxTarget = 5
+			cfg.BalanceRatio = 1.0
+			cfg.Currency = "USDT"
+			cfg.Balance = decimal.NewFromInt(100)
+			report, err := Backtest(context.Background(), func(...interface{}) {}, klines, msgs, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Trades) != tt.trades {
+				t.Errorf("wrong number of trades: want %d, got %d", tt.trades, len(report.Trades))
+			}
+			if len(report.Rejected) != 1 {
+				t.Fatalf("wrong number of rejected signals: want 1, got %d", len(report.Rejected))
+			}
+			if reason := rejectReason(report.Rejected[0]); reason != tt.rejected {
+				t.Errorf("wrong rejection: want %s, got %s (%v)", tt.rejected, reason, report.Rejected[0])
+			}
+		})
+	}
+}
+
+func newCandles(start time.Time, price, inc float64, n int) []exchange.Candle {
+	var candles []exchange.Candle
+	open := decimal.NewFromFloat(price)
+	for i := 0; i < n; i++ {
+		close := open.Add(decimal.NewFromFloat(inc))
+		candles = append(candles, exchange.Candle{
+			OpenTime:  start.Add(time.Duration(i) * time.Minute),
+			CloseTime: start.Add(time.Duration(i+1)*time.Minute - time.Millisecond),
+			Open:      open,
+			High:      decimal.Max(open, close),
+			Low:       decimal.Min(open, close),
+			Close:     close,
+		})
+		open = close
+	}
+	return candles
+}
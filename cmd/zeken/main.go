@@ -9,10 +9,15 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/igolaizola/zeken"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/igolaizola/zeken/pkg/signal/parser"
+	"github.com/igolaizola/zeken/pkg/trade/bolt"
 	"github.com/peterbourgon/ff/v3"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/shopspring/decimal"
 )
 
 func main() {
@@ -48,6 +53,9 @@ func newCommand() *ffcli.Command {
 		},
 		Subcommands: []*ffcli.Command{
 			newServeCommand(),
+			newBacktestCommand(),
+			newParseCommand(),
+			newDBCommand(),
 		},
 	}
 }
@@ -57,18 +65,43 @@ func newServeCommand() *ffcli.Command {
 	_ = fs.String("config", "", "config file (optional)")
 
 	db := fs.String("db", "zeken.db", "database path")
+	store := fs.String("store", "", "trade store uri, bolt://path or sqlite://path (optional, defaults to bolt using db path)")
 	parser := fs.String("parser", "json", "signal parser name")
 	key := fs.String("exchange-key", "", "binance api key")
 	secret := fs.String("exchange-secret", "", "binance api secret")
 	proxy := fs.String("proxy", "", "proxy to be used on requests to binance")
 	token := fs.String("telegram-token", "", "telegram token")
 	controlChat := fs.Int("telegram-control-chat", 0, "telegram chat id for logs and commands")
-	signalChat := fs.Int("telegram-signal-chat", 0, "telegram chat id to read signals")
+	signalChat := fs.Int("telegram-signal-chat", 0, "telegram chat id to read signals, ignored if signal sources are provided")
+	var signalSources stringSlice
+	fs.Var(&signalSources, "signal-source", "telegram signal chat with its own settings, can be repeated, e.g. name=vip,chat=-100123,parser=json,max-trades=2,balance-ratio=0.5,max-target=3,sizing=risk:1 (optional)")
 	maxTrades := fs.Int("max-trades", 5, "max simultaneus trades")
 	maxTarget := fs.Int("max-target", 5, "max target to sell")
 	balance := fs.Float64("balance-ratio", 0.99, "balance ratio to be used")
+	sizing := fs.String("sizing", "", "position sizing strategy: balance:99, fixed:100, percent:10, risk:1 or kelly:50, balance ratio is used by default (optional)")
+	minNotional := fs.Float64("min-notional", 10, "minimum quote quantity of a trade")
 	currency := fs.String("currency", "USDT", "quote currency")
+	trailingStop := fs.String("trailing-stop", "", "trailing stop distance as percentage (5%) or hourly atr multiple (2atr) (optional)")
+	trailingStep := fs.Float64("trailing-step", 0.5, "minimum percentage the trailing stop must increase to move the order")
+	takeProfit := fs.String("take-profit", "", "comma separated percentages of the quantity to sell at each target, e.g. 25,25,25,25 (optional)")
+	samePair := fs.Bool("same-pair", false, "allow concurrent trades on the same pair")
+	entryExpiry := fs.Duration("entry-expiry", 24*time.Hour, "time signals with an entry zone wait for the price to be in the zone, 0 to wait forever")
+	maxDailyLoss := fs.Float64("max-daily-loss", 0, "loss in quote currency during the day that pauses new signals until the next day or /resume, 0 to disable")
+	maxWeeklyLoss := fs.Float64("max-weekly-loss", 0, "loss in quote currency during the week that pauses new signals until the next week or /resume, 0 to disable")
+	maxDrawdown := fs.Float64("max-drawdown", 0, "percentage the equity can drop from its peak before new signals are paused until /resume, 0 to disable")
+	lossSell := fs.Bool("loss-sell", false, "sell running trades when a loss limit is reached")
 	dry := fs.Bool("dry", false, "enable dry mode")
+	dryPrices := fs.String("dry-prices", "", "json file with prices to be used in dry mode instead of binance prices (optional)")
+	dryBalance := fs.Float64("dry-balance", 1000, "initial balance in quote currency for dry mode")
+	dryMakerFee := fs.Float64("dry-maker-fee", 0.1, "maker fee percentage for dry mode")
+	dryTakerFee := fs.Float64("dry-taker-fee", 0.1, "taker fee percentage for dry mode")
+	var notifiers stringSlice
+	fs.Var(&notifiers, "notify", "notifier uri, can be repeated, e.g. slack+https://hooks.slack.com/services/...?level=info (optional)")
+	httpAddr := fs.String("http-addr", "", "address of the http control api, e.g. localhost:8080 (optional)")
+	httpToken := fs.String("http-token", "", "bearer token required by the http control api")
+	webhookAddr := fs.String("webhook-addr", "", "address of the signal webhook that accepts json signals and tradingview alerts, e.g. :8081 (optional)")
+	webhookSecret := fs.String("webhook-secret", "", "shared secret of the signal webhook, used as hmac key, secret query parameter or secret json field")
+	metricsAddr := fs.String("metrics-addr", "", "address to expose prometheus metrics at /metrics, e.g. localhost:9090 (optional)")
 	debug := fs.Bool("debug", false, "enable debug mode")
 
 	return &ffcli.Command{
@@ -102,13 +135,51 @@ func newServeCommand() *ffcli.Command {
 			if *controlChat == 0 {
 				return errors.New("missing telegram control chat")
 			}
-			if *signalChat == 0 {
-				return errors.New("missing telegram signal chat")
+			if *signalChat == 0 && len(signalSources) == 0 {
+				return errors.New("missing telegram signal chat or signal sources")
 			}
 			if *currency == "" {
 				return errors.New("missing currency")
 			}
-			bot, err := zeken.NewBot(*db, *key, *secret, *proxy, *token, *parser, *controlChat, *signalChat, *maxTrades, *maxTarget, *balance, *currency, *dry, *debug)
+			bot, err := zeken.NewBot(zeken.Config{
+				DBPath:        *db,
+				StoreURI:      *store,
+				APIKey:        *key,
+				APISecret:     *secret,
+				Proxy:         *proxy,
+				Token:         *token,
+				ControlChatID: *controlChat,
+				SignalChatID:  *signalChat,
+				SignalSources: signalSources,
+				Parser:        *parser,
+				WebhookAddr:   *webhookAddr,
+				WebhookSecret: *webhookSecret,
+				MaxTrades:     *maxTrades,
+				MaxTarget:     *maxTarget,
+				BalanceRatio:  *balance,
+				Sizing:        *sizing,
+				MinNotional:   *minNotional,
+				Currency:      *currency,
+				TrailingStop:  *trailingStop,
+				TrailingStep:  *trailingStep,
+				TakeProfit:    *takeProfit,
+				EntryExpiry:   *entryExpiry,
+				SamePair:      *samePair,
+				MaxDailyLoss:  *maxDailyLoss,
+				MaxWeeklyLoss: *maxWeeklyLoss,
+				MaxDrawdown:   *maxDrawdown,
+				LossSell:      *lossSell,
+				Dry:           *dry,
+				DryPrices:     *dryPrices,
+				DryBalance:    *dryBalance,
+				DryMakerFee:   *dryMakerFee,
+				DryTakerFee:   *dryTakerFee,
+				Notifiers:     notifiers,
+				HTTPAddr:      *httpAddr,
+				HTTPToken:     *httpToken,
+				MetricsAddr:   *metricsAddr,
+				Debug:         *debug,
+			})
 			if err != nil {
 				return err
 			}
@@ -117,3 +188,232 @@ func newServeCommand() *ffcli.Command {
 		},
 	}
 }
+
+func newBacktestCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	_ = fs.String("config", "", "config file (optional)")
+
+	signals := fs.String("signals", "", "signal messages file (telegram chat export json or one message per line)")
+	klines := fs.String("klines", "", "directory with kline files named by symbol (csv or json)")
+	parser := fs.String("parser", "json", "signal parser name")
+	source := fs.String("signal-source", "", "settings of the signal chat of the messages, same format as in zeken run, e.g. name=vip,chat=-100123,max-trades=2 (optional)")
+	maxTrades := fs.Int("max-trades", 5, "max simultaneus trades")
+	maxTarget := fs.Int("max-target", 5, "max target to sell")
+	balanceRatio := fs.Float64("balance-ratio", 0.99, "balance ratio to be used")
+	sizing := fs.String("sizing", "", "position sizing strategy: balance:99, fixed:100, percent:10, risk:1 or kelly:50, balance ratio is used by default (optional)")
+	minNotional := fs.Float64("min-notional", 10, "minimum quote quantity of a trade")
+	currency := fs.String("currency", "USDT", "quote currency")
+	trailingStop := fs.String("trailing-stop", "", "trailing stop distance as percentage (5%) or hourly atr multiple (2atr) (optional)")
+	trailingStep := fs.Float64("trailing-step", 0.5, "minimum percentage the trailing stop must increase to move the order")
+	takeProfit := fs.String("take-profit", "", "comma separated percentages of the quantity to sell at each target, e.g. 25,25,25,25 (optional)")
+	samePair := fs.Bool("same-pair", false, "allow concurrent trades on the same pair")
+	balance := fs.Float64("balance", 1000, "initial balance in quote currency")
+	debug := fs.Bool("debug", false, "enable debug mode")
+
+	return &ffcli.Command{
+		Name:       "backtest",
+		ShortUsage: "zeken backtest [flags]",
+		Options: []ff.Option{
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ff.PlainParser),
+			ff.WithEnvVarPrefix("ZEKEN"),
+		},
+		ShortHelp: "run signals against historical prices",
+		FlagSet:   fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *signals == "" {
+				return errors.New("missing signals file")
+			}
+			if *klines == "" {
+				return errors.New("missing klines directory")
+			}
+			if *currency == "" {
+				return errors.New("missing currency")
+			}
+			f, err := os.Open(*signals)
+			if err != nil {
+				return fmt.Errorf("couldn't open signals file: %w", err)
+			}
+			defer f.Close()
+			msgs, err := zeken.ReadMessages(f)
+			if err != nil {
+				return err
+			}
+
+			logger := func(v ...interface{}) {}
+			if *debug {
+				logger = log.Println
+			}
+			report, err := zeken.Backtest(ctx, logger, backtest.NewDir(*klines), msgs, zeken.BacktestConfig{
+				Parser:       *parser,
+				Source:       *source,
+				MaxTrades:    *maxTrades,
+				MaxTarget:    *maxTarget,
+				BalanceRatio: *balanceRatio,
+				Sizing:       *sizing,
+				MinNotional:  *minNotional,
+				Currency:     *currency,
+				TrailingStop: *trailingStop,
+				TrailingStep: *trailingStep,
+				TakeProfit:   *takeProfit,
+				SamePair:     *samePair,
+				Balance:      decimal.NewFromFloat(*balance),
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, err := range report.Rejected {
+				fmt.Printf("⚠️ rejected %v\n", err)
+			}
+			for _, t := range report.Trades {
+				emoji := "💰"
+				if t.Profit.LessThan(decimal.Zero) {
+					emoji = "❌"
+				}
+				if t.Open {
+					emoji = "⏳"
+				}
+				perc := t.Profit.Div(t.QuoteQuantity).Mul(decimal.NewFromInt(100))
+				fmt.Printf("%s %s %s %s%% %s %s %s\n", emoji, t.StartTime.Format(time.RFC3339), t.Base, perc.StringFixed(2), t.Profit.StringFixed(2), *currency, t.EndTime.Sub(t.StartTime).Round(time.Second))
+			}
+			fmt.Printf("Trades: %d (%d rejected)\n", len(report.Trades), len(report.Rejected))
+			fmt.Printf("Win rate: %s%% (%d/%d)\n", report.WinRate().Mul(decimal.NewFromInt(100)).StringFixed(2), report.Wins, len(report.Trades))
+			fmt.Printf("Total: %s %s\n", report.Profit.StringFixed(2), *currency)
+			fmt.Printf("Max drawdown: %s %s\n", report.MaxDrawdown.StringFixed(2), *currency)
+			return nil
+		},
+	}
+}
+
+func newParseCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("parse", flag.ExitOnError)
+	parserName := fs.String("parser", "json", "signal parser name, e.g. template:rules.json")
+	messages := fs.String("messages", "", "file with sample messages, a telegram chat export or a message per line with time (optional)")
+
+	return &ffcli.Command{
+		Name:       "parse",
+		ShortUsage: "zeken parse [flags] [message...]",
+		ShortHelp:  "test a signal parser against sample messages",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			p, err := parser.NewParser(*parserName)
+			if err != nil {
+				return fmt.Errorf("couldn't create parser %s: %w", *parserName, err)
+			}
+			var msgs []zeken.Message
+			if *messages != "" {
+				f, err := os.Open(*messages)
+				if err != nil {
+					return fmt.Errorf("couldn't open messages file: %w", err)
+				}
+				defer f.Close()
+				msgs, err = zeken.ReadMessages(f)
+				if err != nil {
+					return err
+				}
+			}
+			for _, arg := range args {
+				msgs = append(msgs, zeken.Message{Text: arg})
+			}
+			if len(msgs) == 0 {
+				return errors.New("missing messages")
+			}
+
+			var parsed int
+			for _, m := range msgs {
+				prefix := ""
+				if !m.Time.IsZero() {
+					prefix = m.Time.Format(time.RFC3339) + " "
+				}
+				sig, err := p.Parse(m.Text)
+				if err != nil {
+					fmt.Printf("❌ %s%q: %v\n", prefix, m.Text, err)
+					continue
+				}
+				parsed++
+				fmt.Printf("✅ %s%s %s/%s start %s targets %s stop %s\n", prefix, strings.Join(sig.Exchanges, ","), sig.Base, sig.Quote, sig.Start, sig.Targets, sig.Stop)
+			}
+			fmt.Printf("Parsed: %d/%d\n", parsed, len(msgs))
+			return nil
+		},
+	}
+}
+
+func newDBCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("db", flag.ExitOnError)
+
+	return &ffcli.Command{
+		Name:       "db",
+		ShortUsage: "zeken db <subcommand>",
+		ShortHelp:  "manage the trade database",
+		FlagSet:    fs,
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			newDBMigrateCommand(),
+			newDBInfoCommand(),
+		},
+	}
+}
+
+func newDBMigrateCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	db := fs.String("db", "zeken.db", "database path")
+
+	return &ffcli.Command{
+		Name:       "migrate",
+		ShortUsage: "zeken db migrate [flags]",
+		ShortHelp:  "upgrade the database schema to the current version",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			from, to, err := bolt.Migrate(*db)
+			if err != nil {
+				return err
+			}
+			if from == to {
+				fmt.Printf("Database is up to date (version %d)\n", to)
+				return nil
+			}
+			fmt.Printf("Database migrated from version %d to %d\n", from, to)
+			return nil
+		},
+	}
+}
+
+func newDBInfoCommand() *ffcli.Command {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	db := fs.String("db", "zeken.db", "database path")
+
+	return &ffcli.Command{
+		Name:       "info",
+		ShortUsage: "zeken db info [flags]",
+		ShortHelp:  "show database schema version and trades",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			info, err := bolt.ReadInfo(*db)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Version: %d (current %d)\n", info.Version, bolt.SchemaVersion)
+			fmt.Printf("Trades: %d (%d finished, %d open)\n", info.Trades, info.Finished, len(info.Open))
+			for _, t := range info.Open {
+				fmt.Printf("⏳ %s %s %s/%s %s\n", t.StartTime.Format(time.RFC3339), t.ID, t.Base, t.Quote, t.QuoteQuantity.StringFixed(2))
+			}
+			return nil
+		},
+	}
+}
+
+// stringSlice is a flag that can be set multiple times
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
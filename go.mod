@@ -1,17 +1,31 @@
 module github.com/igolaizola/zeken
 
-go 1.16
+go 1.18
 
 require (
 	github.com/adshao/go-binance/v2 v2.3.1
 	github.com/boltdb/bolt v1.3.1
-	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/kr/text v0.2.0 // indirect
+	github.com/glebarez/go-sqlite v1.21.2
+	github.com/gorilla/websocket v1.4.2
 	github.com/peterbourgon/ff/v3 v3.1.0
 	github.com/shopspring/decimal v1.2.0
+	gopkg.in/tucnak/telebot.v2 v2.4.0
+)
+
+require (
+	github.com/bitly/go-simplejson v0.5.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/stretchr/testify v1.7.0 // indirect
-	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf // indirect
-	gopkg.in/tucnak/telebot.v2 v2.4.0
+	golang.org/x/sys v0.7.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	modernc.org/libc v1.22.5 // indirect
+	modernc.org/mathutil v1.5.0 // indirect
+	modernc.org/memory v1.5.0 // indirect
+	modernc.org/sqlite v1.23.1 // indirect
 )
@@ -0,0 +1,131 @@
+package zeken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/risk"
+	"github.com/shopspring/decimal"
+)
+
+// guardWait is the time between loss limit checks of running trades
+const guardWait = time.Minute
+
+// checkGuard checks the loss limits, new signals are paused and running trades
+// are sold if configured when a limit is reached
+func (b *Bot) checkGuard() {
+	if b.guard == nil {
+		return
+	}
+	pnl, err := b.pnl()
+	if err != nil {
+		b.error(err)
+		return
+	}
+	trip, expired, err := b.guard.Check(b.clock.Now(), pnl)
+	if err != nil {
+		b.error(err)
+	}
+	if expired {
+		b.bus.Publish(&event.GuardResumed{Time: b.clock.Now().UTC(), Expired: true})
+	}
+	if trip == nil {
+		return
+	}
+	b.bus.Publish(&event.GuardTripped{
+		Time:      trip.Time,
+		Reason:    string(trip.Reason),
+		Loss:      trip.Loss,
+		Limit:     trip.Limit,
+		Until:     trip.Until,
+		ForceSell: b.guardSell,
+	})
+	if b.guardSell {
+		for _, t := range b.openTrades() {
+			t.Sell()
+		}
+	}
+}
+
+// tripped returns the current trip of the guard or nil if signals are
+// accepted
+func (b *Bot) tripped() *risk.Trip {
+	if b.guard == nil {
+		return nil
+	}
+	return b.guard.Tripped()
+}
+
+// resumeGuard accepts signals again after a loss limit has been reached
+func (b *Bot) resumeGuard() error {
+	if b.guard == nil {
+		return errors.New("zeken: loss limits aren't enabled")
+	}
+	pnl, err := b.pnl()
+	if err != nil {
+		return err
+	}
+	if err := b.guard.Resume(b.clock.Now(), pnl); err != nil {
+		return err
+	}
+	b.bus.Publish(&event.GuardResumed{Time: b.clock.Now().UTC()})
+	return nil
+}
+
+// runGuard checks the loss limits periodically so losses of running trades
+// are also detected without new signals
+func (b *Bot) runGuard(ctx context.Context) {
+	for {
+		b.checkGuard()
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.clock.After(guardWait):
+		}
+	}
+}
+
+// pnl returns the profits of trades finished during the day and the week plus
+// the profits of running trades
+func (b *Bot) pnl() (risk.PnL, error) {
+	now := b.clock.Now().UTC()
+	day, week := risk.Periods(now)
+	trades, err := b.store.Ended(week, now)
+	if err != nil {
+		return risk.PnL{}, fmt.Errorf("zeken: couldn't list trades: %w", err)
+	}
+	pnl := risk.PnL{Daily: decimal.Zero, Weekly: decimal.Zero, Equity: decimal.Zero}
+	for _, t := range trades {
+		profit := t.EndQuoteQuantity.Sub(t.QuoteQuantity)
+		if !t.EndTime.Before(week) {
+			pnl.Weekly = pnl.Weekly.Add(profit)
+		}
+		if !t.EndTime.Before(day) {
+			pnl.Daily = pnl.Daily.Add(profit)
+		}
+	}
+	value := decimal.Zero
+	for _, tr := range b.openTrades() {
+		t := tr.Snapshot()
+		if t.Pending() {
+			continue
+		}
+		profit, _, _ := t.Status(now)
+		pnl.Daily = pnl.Daily.Add(profit)
+		pnl.Weekly = pnl.Weekly.Add(profit)
+		// Partial sells are already part of the balance
+		value = value.Add(t.QuoteQuantity).Add(profit).Sub(t.Filled())
+	}
+	if !b.guard.Limits().Drawdown.IsPositive() {
+		return pnl, nil
+	}
+	balance, err := b.exchange.Balance(b.ctx, b.currency)
+	if err != nil {
+		return risk.PnL{}, fmt.Errorf("zeken: couldn't get balance: %w", err)
+	}
+	pnl.Equity = balance.Add(value)
+	return pnl, nil
+}
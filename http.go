@@ -0,0 +1,277 @@
+package zeken
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/shopspring/decimal"
+)
+
+type tradeResponse struct {
+	ID               string            `json:"id"`
+	Base             string            `json:"base"`
+	Quote            string            `json:"quote"`
+	StartTime        time.Time         `json:"start_time"`
+	EndTime          *time.Time        `json:"end_time,omitempty"`
+	StartPrice       decimal.Decimal   `json:"start_price"`
+	StopPrice        decimal.Decimal   `json:"stop_price"`
+	Targets          []decimal.Decimal `json:"targets"`
+	CurrentTarget    int               `json:"current_target"`
+	Quantity         decimal.Decimal   `json:"quantity"`
+	QuoteQuantity    decimal.Decimal   `json:"quote_quantity"`
+	EndQuoteQuantity *decimal.Decimal  `json:"end_quote_quantity,omitempty"`
+	Profit           decimal.Decimal   `json:"profit"`
+	Percentage       decimal.Decimal   `json:"percentage"`
+	Elapsed          string            `json:"elapsed,omitempty"`
+	Source           string            `json:"source,omitempty"`
+	Pending          bool              `json:"pending,omitempty"`
+}
+
+func newTradeResponse(t *trade.Trade) *tradeResponse {
+	return &tradeResponse{
+		ID:            t.ID,
+		Base:          t.Base,
+		Quote:         t.Quote,
+		StartTime:     t.StartTime,
+		StartPrice:    t.StartPrice,
+		StopPrice:     t.StopPrice,
+		Targets:       t.Targets,
+		CurrentTarget: t.CurrentTarget,
+		Quantity:      t.Quantity,
+		QuoteQuantity: t.QuoteQuantity,
+		Source:        t.Source,
+		Pending:       t.Pending(),
+	}
+}
+
+type historyResponse struct {
+	From    time.Time               `json:"from"`
+	To      time.Time               `json:"to"`
+	Trades  []*tradeResponse        `json:"trades"`
+	Profit  decimal.Decimal         `json:"profit"`
+	Sources []*sourceProfitResponse `json:"sources"`
+}
+
+type sourceProfitResponse struct {
+	Source string          `json:"source"`
+	Trades int             `json:"trades"`
+	Profit decimal.Decimal `json:"profit"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the http control api handler, requests must be
+// authenticated with the token as bearer token.
+//
+//	GET  /trades              open trades with live profit
+//	GET  /history?from=&to=   finished trades, dates as RFC3339 or 2006-01-02,
+//	                          a date as to includes the whole day
+//	POST /trades/{id}/sell    force the sale of an open trade
+//	POST /signals             submit a signal message in the body
+//	POST /resume              accept signals again after a loss limit
+//	POST /shutdown            stop the bot
+func (b *Bot) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/trades", b.handleTrades)
+	mux.HandleFunc("/trades/", b.handleSell)
+	mux.HandleFunc("/history", b.handleHistory)
+	mux.HandleFunc("/signals", b.handleSignal)
+	mux.HandleFunc("/resume", b.handleResume)
+	mux.HandleFunc("/shutdown", b.handleShutdown)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serve runs an http server until the context is canceled
+func (b *Bot) serve(ctx context.Context, addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("zeken: couldn't listen on %s: %w", addr, err)
+	}
+	srv := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.error(fmt.Errorf("zeken: http server failed: %w", err))
+		}
+	}()
+	return nil
+}
+
+func (b *Bot) handleTrades(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	resp := []*tradeResponse{}
+	now := b.clock.Now()
+	for _, t := range b.openTrades() {
+		s := t.Snapshot()
+		tr := newTradeResponse(s.Trade)
+		var elapsed time.Duration
+		tr.Profit, tr.Percentage, elapsed = s.Status(now)
+		tr.Elapsed = elapsed.Round(time.Second).String()
+		resp = append(resp, tr)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (b *Bot) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	to := b.clock.Now().UTC()
+	from := to.Add(-365 * 24 * time.Hour)
+	for _, p := range []struct {
+		name  string
+		value *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, day, err := parseDate(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", p.name, err))
+			return
+		}
+		// Trades started during the day are included
+		if day && p.value == &to {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		*p.value = t
+	}
+	trades, err := b.history(from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := &historyResponse{From: from, To: to, Trades: []*tradeResponse{}, Profit: decimal.Zero}
+	for _, t := range trades {
+		tr := newTradeResponse(t)
+		end, endQuoteQty := t.EndTime, t.EndQuoteQuantity
+		tr.EndTime = &end
+		tr.EndQuoteQuantity = &endQuoteQty
+		tr.Profit = t.EndQuoteQuantity.Sub(t.QuoteQuantity)
+		if t.QuoteQuantity.IsPositive() {
+			tr.Percentage = tr.Profit.Div(t.QuoteQuantity)
+		}
+		tr.Elapsed = t.EndTime.Sub(t.StartTime).Round(time.Second).String()
+		resp.Trades = append(resp.Trades, tr)
+		resp.Profit = resp.Profit.Add(tr.Profit)
+	}
+	resp.Sources = []*sourceProfitResponse{}
+	for _, p := range profitBySource(trades) {
+		resp.Sources = append(resp.Sources, &sourceProfitResponse{Source: p.Source, Trades: p.Trades, Profit: p.Profit})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (b *Bot) handleSell(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/trades/")
+	if !strings.HasSuffix(id, "/sell") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	t, err := b.sell(strings.TrimSuffix(id, "/sell"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newTradeResponse(t.Snapshot().Trade))
+}
+
+func (b *Bot) handleSignal(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	byt, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := b.handle(b.source("api"), &signal.Message{Text: string(byt)}); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (b *Bot) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if err := b.resumeGuard(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (b *Bot) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	// Shutdown after the response is sent
+	go b.shutdown()
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
+
+// parseDate parses RFC3339 times or dates like 2006-01-02, day is set if
+// the value is a date
+func parseDate(v string) (t time.Time, day bool, err error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("2006-01-02", v)
+	return t, err == nil, err
+}
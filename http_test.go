@@ -0,0 +1,131 @@
+package zeken
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/igolaizola/zeken/pkg/metrics"
+	"github.com/igolaizola/zeken/pkg/signal/parser/json"
+	"github.com/igolaizola/zeken/pkg/trade/inmem"
+	"github.com/shopspring/decimal"
+)
+
+func TestHandler(t *testing.T) {
+	start := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	// Prices don't move, so trades keep running until they are sold
+	klines := backtest.Series{"IGOUSDT": newCandles(start, 10.0, 0, 10)}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	m := newMockMessenger()
+	mt := metrics.New()
+	b := New(m, ex, inmem.New(), json.Parser{}, WithClock(clk), WithMetrics(mt, ""))
+
+	done := make(chan error)
+	go func() {
+		done <- b.Run(context.Background())
+	}()
+	m.wait(t, "zeken bot running")
+
+	h := b.Handler("secret")
+	do := func(method, path, body string, v interface{}) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if v != nil {
+			if err := stdjson.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatalf("%s %s: %v: %s", method, path, err, rec.Body.String())
+			}
+		}
+		return rec.Code
+	}
+
+	// Token is required
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trades", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status without token: %d", rec.Code)
+	}
+	if code := do(http.MethodGet, "/signals", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("wrong status of invalid method: %d", code)
+	}
+
+	// Submit signals
+	sig := `{"exchanges": ["BINANCE"], "base": "IGO", "quote": "USDT", "start": "10", "targets": ["11", "12"], "stop": "9"}`
+	var errResp errorResponse
+	if code := do(http.MethodPost, "/signals", strings.ReplaceAll(sig, "USDT", "BTC"), &errResp); code != http.StatusUnprocessableEntity || errResp.Error == "" {
+		t.Errorf("wrong invalid signal response: %d %v", code, errResp)
+	}
+	if code := do(http.MethodPost, "/signals", sig, nil); code != http.StatusAccepted {
+		t.Fatalf("wrong signal status: %d", code)
+	}
+
+	var trades []tradeResponse
+	if code := do(http.MethodGet, "/trades", "", &trades); code != http.StatusOK {
+		t.Fatalf("wrong trades status: %d", code)
+	}
+	if len(trades) != 1 || trades[0].Base != "IGO" {
+		t.Fatalf("wrong open trades: %+v", trades)
+	}
+	id := trades[0].ID
+
+	// Sell the trade
+	if code := do(http.MethodPost, "/trades/unknown/sell", "", nil); code != http.StatusNotFound {
+		t.Errorf("wrong status selling unknown trade: %d", code)
+	}
+	m.wait(t, "running trade")
+	if code := do(http.MethodPost, "/trades/"+id+"/sell", "", nil); code != http.StatusAccepted {
+		t.Fatalf("wrong sell status: %d", code)
+	}
+	m.wait(t, "finished")
+
+	var history historyResponse
+	if code := do(http.MethodGet, "/history?from=2021-08-31&to=2021-09-02T00:00:00Z", "", &history); code != http.StatusOK {
+		t.Fatalf("wrong history status: %d", code)
+	}
+	if len(history.Trades) != 1 || history.Trades[0].ID != id || history.Trades[0].EndTime == nil {
+		t.Errorf("wrong history: %+v", history)
+	}
+	// A date includes trades of the whole day
+	history = historyResponse{}
+	if code := do(http.MethodGet, "/history?from=2021-09-01&to=2021-09-01", "", &history); code != http.StatusOK {
+		t.Fatalf("wrong history status: %d", code)
+	}
+	if len(history.Trades) != 1 || history.Trades[0].ID != id {
+		t.Errorf("trades of the to date not included: %+v", history)
+	}
+	if code := do(http.MethodGet, "/history?from=yesterday", "", nil); code != http.StatusBadRequest {
+		t.Errorf("wrong status of invalid date: %d", code)
+	}
+
+	// Signals are counted in metrics
+	rec = httptest.NewRecorder()
+	mt.Handler(b.updateMetrics).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		"zeken_signals_received_total 2\n",
+		"zeken_signals_accepted_total 1\n",
+		`zeken_signals_rejected_total{reason="unsupported"} 1`,
+		"zeken_open_trades 0\n",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%q not found in metrics:\n%s", want, rec.Body.String())
+		}
+	}
+
+	if code := do(http.MethodPost, "/shutdown", "", nil); code != http.StatusAccepted {
+		t.Fatalf("wrong shutdown status: %d", code)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot didn't shutdown")
+	}
+}
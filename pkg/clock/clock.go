@@ -0,0 +1,61 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and waits.
+// It allows running trades against a simulated time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// New returns a clock based on system time.
+func New() Clock {
+	return systemClock{}
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Virtual is a simulated clock.
+// Waits don't block, they move the clock forward instead.
+type Virtual struct {
+	now  time.Time
+	lock sync.Mutex
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (c *Virtual) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *Virtual) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// Set moves the clock to the given time.
+func (c *Virtual) Set(t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = t
+}
@@ -0,0 +1,50 @@
+package event
+
+import "sync"
+
+type subscription struct {
+	id int
+	fn func(Event)
+}
+
+// Bus delivers published events to its subscribers.
+// Events are delivered synchronously in the publisher goroutine, so
+// subscribers must not block.
+type Bus struct {
+	lock sync.RWMutex
+	subs []subscription
+	next int
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds a subscriber and returns a function to remove it
+func (b *Bus) Subscribe(fn func(Event)) func() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	id := b.next
+	b.next++
+	b.subs = append(b.subs, subscription{id: id, fn: fn})
+	return func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for i, s := range b.subs {
+			if s.id == id {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish sends the event to all subscribers in subscription order
+func (b *Bus) Publish(e Event) {
+	b.lock.RLock()
+	subs := b.subs
+	b.lock.RUnlock()
+	for _, s := range subs {
+		s.fn(e)
+	}
+}
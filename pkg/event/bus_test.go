@@ -0,0 +1,25 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	var got []string
+	unsubscribe := bus.Subscribe(func(e Event) {
+		got = append(got, "first "+e.Name())
+	})
+	bus.Subscribe(func(e Event) {
+		got = append(got, "second "+e.Name())
+	})
+	bus.Publish(&BotStarted{})
+	unsubscribe()
+	bus.Publish(&BotStopped{})
+
+	want := []string{"first bot_started", "second bot_started", "second bot_stopped"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong events:\nwant %v\ngot  %v", want, got)
+	}
+}
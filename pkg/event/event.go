@@ -0,0 +1,225 @@
+package event
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Event is a bot or trade lifecycle event
+type Event interface {
+	// Name returns the event name in snake case
+	Name() string
+}
+
+// BotStarted is published when the bot starts running
+type BotStarted struct {
+	Time    time.Time
+	Version string
+	Dry     bool
+}
+
+// BotStopped is published when the bot stops running
+type BotStopped struct {
+	Time time.Time
+}
+
+// SignalReceived is published when a signal message is received
+type SignalReceived struct {
+	Time   time.Time
+	Source string
+	Text   string
+}
+
+// SignalRejected is published when a signal message can't be parsed or
+// traded, Reason is a short identifier of the cause
+type SignalRejected struct {
+	Time   time.Time
+	Source string
+	Text   string
+	Reason string
+	Err    error
+}
+
+// TradeStarted is published when a new or resumed trade starts running
+type TradeStarted struct {
+	Time    time.Time
+	Trade   string
+	Base    string
+	Quote   string
+	Source  string
+	Resumed bool
+}
+
+// EntryPending is published when a trade waits for the price to be in its
+// entry zone, Expiry is zero if the entry doesn't expire
+type EntryPending struct {
+	Time   time.Time
+	Trade  string
+	Base   string
+	Quote  string
+	Low    decimal.Decimal
+	High   decimal.Decimal
+	Expiry time.Time
+}
+
+// EntryCanceled is published when a pending entry is canceled before buying,
+// Expired is set if it was canceled because of its expiry
+type EntryCanceled struct {
+	Time    time.Time
+	Trade   string
+	Base    string
+	Quote   string
+	Expired bool
+}
+
+// BuyFilled is published when the trade quantity has been bought
+type BuyFilled struct {
+	Time          time.Time
+	Trade         string
+	Base          string
+	Quote         string
+	Quantity      decimal.Decimal
+	QuoteQuantity decimal.Decimal
+}
+
+// OCOPlaced is published when the target and stop orders are placed
+type OCOPlaced struct {
+	Time        time.Time
+	Trade       string
+	Base        string
+	Quote       string
+	OrderListID string
+	Quantity    decimal.Decimal
+	Price       decimal.Decimal
+	StopPrice   decimal.Decimal
+}
+
+// OCOCanceled is published when the target and stop orders are canceled
+type OCOCanceled struct {
+	Time        time.Time
+	Trade       string
+	Base        string
+	Quote       string
+	OrderListID string
+}
+
+// TargetReached is published when the price reaches a target, Target starts
+// at 1
+type TargetReached struct {
+	Time   time.Time
+	Trade  string
+	Base   string
+	Target int
+}
+
+// TakeProfitFilled is published when part of the quantity is sold at a target
+type TakeProfitFilled struct {
+	Time          time.Time
+	Trade         string
+	Base          string
+	Quote         string
+	Target        int
+	Quantity      decimal.Decimal
+	QuoteQuantity decimal.Decimal
+}
+
+// StopMoved is published when the trailing stop is moved up
+type StopMoved struct {
+	Time      time.Time
+	Trade     string
+	Base      string
+	StopPrice decimal.Decimal
+}
+
+// TradeUpdated is published when the stop or the targets of a trade are
+// changed by a follow-up message
+type TradeUpdated struct {
+	Time      time.Time
+	Trade     string
+	Base      string
+	StopPrice decimal.Decimal
+	Targets   []decimal.Decimal
+}
+
+// TradeClosed is published when a trade finishes.
+// Canceled is set if the orders were canceled outside the bot and the trade
+// result is unknown.
+type TradeClosed struct {
+	Time             time.Time
+	Trade            string
+	Base             string
+	Quote            string
+	Source           string
+	QuoteQuantity    decimal.Decimal
+	EndQuoteQuantity decimal.Decimal
+	Profit           decimal.Decimal
+	Percentage       decimal.Decimal
+	Elapsed          time.Duration
+	Canceled         bool
+}
+
+// GuardTripped is published when a loss limit is reached and new signals are
+// rejected until Until, or until trading is resumed if it is zero.
+// Loss and Limit are ratios for the drawdown limit.
+type GuardTripped struct {
+	Time      time.Time
+	Reason    string
+	Loss      decimal.Decimal
+	Limit     decimal.Decimal
+	Until     time.Time
+	ForceSell bool
+}
+
+// GuardResumed is published when signals are accepted again, Expired is set
+// if the period of the limit ended
+type GuardResumed struct {
+	Time    time.Time
+	Expired bool
+}
+
+// ErrorRetry is published when an operation failed and it will be retried
+type ErrorRetry struct {
+	Time      time.Time
+	Trade     string
+	Base      string
+	Operation string
+	Err       error
+}
+
+// Warning is published when something requires attention
+type Warning struct {
+	Time  time.Time
+	Trade string
+	Base  string
+	Text  string
+}
+
+// Error is published when an operation failed
+type Error struct {
+	Time  time.Time
+	Trade string
+	Base  string
+	Err   error
+}
+
+func (*BotStarted) Name() string       { return "bot_started" }
+func (*BotStopped) Name() string       { return "bot_stopped" }
+func (*SignalReceived) Name() string   { return "signal_received" }
+func (*SignalRejected) Name() string   { return "signal_rejected" }
+func (*TradeStarted) Name() string     { return "trade_started" }
+func (*EntryPending) Name() string     { return "entry_pending" }
+func (*EntryCanceled) Name() string    { return "entry_canceled" }
+func (*BuyFilled) Name() string        { return "buy_filled" }
+func (*OCOPlaced) Name() string        { return "oco_placed" }
+func (*OCOCanceled) Name() string      { return "oco_canceled" }
+func (*TargetReached) Name() string    { return "target_reached" }
+func (*TakeProfitFilled) Name() string { return "take_profit_filled" }
+func (*StopMoved) Name() string        { return "stop_moved" }
+func (*TradeUpdated) Name() string     { return "trade_updated" }
+func (*TradeClosed) Name() string      { return "trade_closed" }
+func (*GuardTripped) Name() string     { return "guard_tripped" }
+func (*GuardResumed) Name() string     { return "guard_resumed" }
+func (*ErrorRetry) Name() string       { return "error_retry" }
+func (*Warning) Name() string          { return "warning" }
+func (*Error) Name() string            { return "error" }
@@ -0,0 +1,78 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Printer returns a subscriber that prints events as human readable messages.
+// Events that are only useful for integrations aren't printed.
+func Printer(print func(v ...interface{})) func(Event) {
+	return func(e Event) {
+		switch e := e.(type) {
+		case *BotStarted:
+			print(fmt.Sprintf("🤖 zeken bot running\n- version: %s\n- dry mode: %t", e.Version, e.Dry))
+		case *BotStopped:
+			print("🛑 zeken bot stopped")
+		case *SignalRejected:
+			print(e.Err)
+		case *TradeStarted:
+			print(fmt.Sprintf("⚙️ running trade %s %s", e.Trade, e.Base))
+		case *EntryPending:
+			msg := fmt.Sprintf("⏳ %s waiting to buy between %s and %s", e.Base, e.Low, e.High)
+			if !e.Expiry.IsZero() {
+				msg = fmt.Sprintf("%s until %s", msg, e.Expiry.Format(time.RFC3339))
+			}
+			print(msg)
+		case *EntryCanceled:
+			if e.Expired {
+				print(fmt.Sprintf("⌛ %s %s entry expired without buying", e.Trade, e.Base))
+				return
+			}
+			print(fmt.Sprintf("🚫 %s %s pending entry canceled", e.Trade, e.Base))
+		case *TargetReached:
+			print(fmt.Sprintf("✔️ %s reached target %d", e.Base, e.Target))
+		case *TakeProfitFilled:
+			print(fmt.Sprintf("💵 %s sold %s for %s %s", e.Base, e.Quantity, e.QuoteQuantity.StringFixed(2), e.Quote))
+		case *StopMoved:
+			print(fmt.Sprintf("⬆️ %s trailing stop moved to %s", e.Base, e.StopPrice))
+		case *TradeUpdated:
+			print(fmt.Sprintf("✏️ %s updated, stop %s and targets %s", e.Base, e.StopPrice, e.Targets))
+		case *TradeClosed:
+			if e.Canceled {
+				print(fmt.Sprintf("⚠️ %s %s finished with error because order was canceled, it will be removed from database", e.Trade, e.Base))
+				return
+			}
+			emoji := "💰"
+			if e.Profit.LessThan(decimal.Zero) {
+				emoji = "❌"
+			}
+			print(emoji, fmt.Sprintf("finished %s %s %s%% %s %s %s", e.Trade, e.Base, e.Percentage.Mul(decimal.NewFromInt(100)).StringFixed(2), e.Profit.StringFixed(2), e.Quote, e.Elapsed.Round(time.Second)))
+		case *GuardTripped:
+			msg := fmt.Sprintf("🚨 %s limit reached (%s of %s), new signals are paused", strings.ReplaceAll(e.Reason, "_", " "), e.Loss.StringFixed(2), e.Limit.StringFixed(2))
+			if !e.Until.IsZero() {
+				msg = fmt.Sprintf("%s until %s", msg, e.Until.Format(time.RFC3339))
+			}
+			msg += ", use /resume to trade again"
+			if e.ForceSell {
+				msg += "\nselling all trades"
+			}
+			print(msg)
+		case *GuardResumed:
+			if e.Expired {
+				print("▶️ loss limit period ended, signals resumed")
+				return
+			}
+			print("▶️ signals resumed")
+		case *ErrorRetry:
+			print(e.Err, "retrying...")
+		case *Warning:
+			print(fmt.Sprintf("⚠️ %s", e.Text))
+		case *Error:
+			print(e.Err)
+		}
+	}
+}
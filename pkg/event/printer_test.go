@@ -0,0 +1,54 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestPrinter(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{event: &TargetReached{Base: "IGO", Target: 1}, want: "✔️ IGO reached target 1"},
+		{event: &StopMoved{Base: "IGO", StopPrice: decimal.NewFromFloat(12.6)}, want: "⬆️ IGO trailing stop moved to 12.6"},
+		{event: &ErrorRetry{Err: errors.New("timeout")}, want: "timeout retrying..."},
+		{event: &Warning{Text: "IGOUSDT streams dropped"}, want: "⚠️ IGOUSDT streams dropped"},
+		{
+			event: &TradeClosed{
+				Trade:      "a1b2c3d4",
+				Base:       "IGO",
+				Quote:      "USDT",
+				Profit:     decimal.NewFromInt(-5),
+				Percentage: decimal.NewFromFloat(-0.05),
+				Elapsed:    90 * time.Minute,
+			},
+			want: "❌ finished a1b2c3d4 IGO -5.00% -5.00 USDT 1h30m0s",
+		},
+		{
+			event: &GuardTripped{
+				Reason: "daily_loss",
+				Loss:   decimal.NewFromInt(60),
+				Limit:  decimal.NewFromInt(50),
+				Until:  time.Date(2021, 9, 2, 0, 0, 0, 0, time.UTC),
+			},
+			want: "🚨 daily loss limit reached (60.00 of 50.00), new signals are paused until 2021-09-02T00:00:00Z, use /resume to trade again",
+		},
+		{event: &BuyFilled{Base: "IGO"}},
+		{event: &OCOPlaced{Base: "IGO"}},
+	}
+	for _, tt := range tests {
+		var got string
+		Printer(func(v ...interface{}) {
+			got = strings.TrimSpace(fmt.Sprintln(v...))
+		})(tt.event)
+		if got != tt.want {
+			t.Errorf("%s: want %q, got %q", tt.event.Name(), tt.want, got)
+		}
+	}
+}
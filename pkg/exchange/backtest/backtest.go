@@ -0,0 +1,321 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+var zero = decimal.Decimal{}
+
+type orderStatus int
+
+const (
+	orderNew orderStatus = iota
+	orderFilled
+	orderCanceled
+)
+
+type order struct {
+	status   orderStatus
+	quoteQty decimal.Decimal
+	list     string
+}
+
+type orderList struct {
+	symbol   string
+	quantity decimal.Decimal
+	target   decimal.Decimal
+	stop     decimal.Decimal
+	// checked is the close time of the last candle evaluated
+	checked time.Time
+	// orders contains stop loss and limit maker order ids
+	orders [2]string
+}
+
+type backtestExchange struct {
+	klines   Klines
+	clock    clock.Clock
+	balances map[string]decimal.Decimal
+	symbols  map[string][2]string
+	orders   map[string]*order
+	lists    map[string]*orderList
+	lastID   int
+	lock     sync.Mutex
+}
+
+// New returns an exchange that replays historical klines using the given
+// clock as current time.
+// Prices are the close price of the last closed candle and OCO orders are
+// filled using candle high and low values.
+func New(clk clock.Clock, klines Klines, balances map[string]decimal.Decimal) exchange.Exchange {
+	b := make(map[string]decimal.Decimal)
+	for k, v := range balances {
+		b[k] = v
+	}
+	return &backtestExchange{
+		klines:   klines,
+		clock:    clk,
+		balances: b,
+		symbols:  make(map[string][2]string),
+		orders:   make(map[string]*order),
+		lists:    make(map[string]*orderList),
+	}
+}
+
+func (e *backtestExchange) Symbol(base, quote string) string {
+	symbol := fmt.Sprintf("%s%s", base, quote)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.symbols[symbol] = [2]string{base, quote}
+	return symbol
+}
+
+func (e *backtestExchange) Buy(ctx context.Context, symbol string, quoteQuantity, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	currentPrice, err := e.Price(ctx, symbol)
+	if err != nil {
+		return zero, zero, err
+	}
+	if currentPrice.LessThan(price.Mul(decimal.NewFromFloat(0.95))) {
+		return zero, zero, fmt.Errorf("backtest: current price is lower than minimum price: %s %s", currentPrice, price)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	base, quote, err := e.assets(symbol)
+	if err != nil {
+		return zero, zero, err
+	}
+	if e.balances[quote].LessThan(quoteQuantity) {
+		return zero, zero, fmt.Errorf("backtest: insufficient %s balance: %s < %s", quote, e.balances[quote], quoteQuantity)
+	}
+	qty := quoteQuantity.Div(currentPrice)
+	e.balances[quote] = e.balances[quote].Sub(quoteQuantity)
+	e.balances[base] = e.balances[base].Add(qty)
+	return quoteQuantity, qty, nil
+}
+
+func (e *backtestExchange) Sell(ctx context.Context, symbol string, quantity decimal.Decimal) (decimal.Decimal, error) {
+	price, err := e.Price(ctx, symbol)
+	if err != nil {
+		return zero, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	base, quote, err := e.assets(symbol)
+	if err != nil {
+		return zero, err
+	}
+	quoteQty := quantity.Mul(price)
+	e.balances[base] = e.balances[base].Sub(quantity)
+	e.balances[quote] = e.balances[quote].Add(quoteQty)
+	return quoteQty, nil
+}
+
+func (e *backtestExchange) CreateStopLimit(ctx context.Context, symbol string, quantity, target, stop decimal.Decimal) (string, []string, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	l := &orderList{
+		symbol:   symbol,
+		quantity: quantity,
+		target:   target,
+		stop:     stop,
+		checked:  e.clock.Now(),
+	}
+	listID := e.nextID()
+	for i := range l.orders {
+		id := e.nextID()
+		e.orders[id] = &order{list: listID}
+		l.orders[i] = id
+	}
+	e.lists[listID] = l
+	return listID, l.orders[:], nil
+}
+
+func (e *backtestExchange) CancelStopLimit(ctx context.Context, symbol string, id string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	l, ok := e.lists[id]
+	if !ok {
+		return fmt.Errorf("backtest: order list %s not found", id)
+	}
+	if err := e.fill(l); err != nil {
+		return err
+	}
+	for _, orderID := range l.orders {
+		if e.orders[orderID].status != orderNew {
+			return fmt.Errorf("backtest: order list %s is not active", id)
+		}
+	}
+	for _, orderID := range l.orders {
+		e.orders[orderID].status = orderCanceled
+	}
+	return nil
+}
+
+func (e *backtestExchange) Status(ctx context.Context, symbol string, id string) (bool, decimal.Decimal, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	o, ok := e.orders[id]
+	if !ok {
+		return false, zero, fmt.Errorf("backtest: order %s not found", id)
+	}
+	if err := e.fill(e.lists[o.list]); err != nil {
+		return false, zero, err
+	}
+	switch o.status {
+	case orderFilled:
+		return true, o.quoteQty, nil
+	case orderCanceled:
+		return false, zero, fmt.Errorf("backtest: %w", exchange.ErrOrderCanceled)
+	default:
+		return false, zero, nil
+	}
+}
+
+func (e *backtestExchange) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	candles, err := e.klines.Candles(symbol)
+	if err != nil {
+		return zero, err
+	}
+	now := e.clock.Now()
+	if now.Before(candles[0].OpenTime) {
+		return zero, fmt.Errorf("backtest: no klines for %s at %s", symbol, now)
+	}
+	// Find the first candle that isn't closed yet
+	i := sort.Search(len(candles), func(i int) bool {
+		return candles[i].CloseTime.After(now)
+	})
+	if i == 0 {
+		return candles[0].Open, nil
+	}
+	return candles[i-1].Close, nil
+}
+
+func (e *backtestExchange) Balance(ctx context.Context, currency string) (decimal.Decimal, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.balances[currency], nil
+}
+
+// Candles returns candles closed before current time aggregated by interval
+func (e *backtestExchange) Candles(ctx context.Context, symbol string, interval string, limit int) ([]exchange.Candle, error) {
+	d, err := parseInterval(interval)
+	if err != nil {
+		return nil, err
+	}
+	candles, err := e.klines.Candles(symbol)
+	if err != nil {
+		return nil, err
+	}
+	now := e.clock.Now()
+	var aggregated []exchange.Candle
+	for _, c := range candles {
+		if c.CloseTime.After(now) {
+			break
+		}
+		openTime := c.OpenTime.Truncate(d)
+		n := len(aggregated)
+		if n == 0 || !aggregated[n-1].OpenTime.Equal(openTime) {
+			c.OpenTime = openTime
+			aggregated = append(aggregated, c)
+			continue
+		}
+		last := &aggregated[n-1]
+		last.CloseTime = c.CloseTime
+		last.High = decimal.Max(last.High, c.High)
+		last.Low = decimal.Min(last.Low, c.Low)
+		last.Close = c.Close
+	}
+	if len(aggregated) > limit {
+		aggregated = aggregated[len(aggregated)-limit:]
+	}
+	return aggregated, nil
+}
+
+// fill checks candles closed since the last check and fills the order list
+// if the stop or the target has been reached.
+// If both are reached in the same candle, the stop is assumed to be reached
+// first.
+func (e *backtestExchange) fill(l *orderList) error {
+	stopOrder, limitOrder := e.orders[l.orders[0]], e.orders[l.orders[1]]
+	if stopOrder.status != orderNew || limitOrder.status != orderNew {
+		return nil
+	}
+	candles, err := e.klines.Candles(l.symbol)
+	if err != nil {
+		return err
+	}
+	now := e.clock.Now()
+	i := sort.Search(len(candles), func(i int) bool {
+		return candles[i].CloseTime.After(l.checked)
+	})
+	for ; i < len(candles) && !candles[i].CloseTime.After(now); i++ {
+		c := candles[i]
+		l.checked = c.CloseTime
+		var filled, canceled *order
+		var price decimal.Decimal
+		switch {
+		case c.Low.LessThanOrEqual(l.stop):
+			filled, canceled = stopOrder, limitOrder
+			price = decimal.Min(l.stop, c.Open)
+		case c.High.GreaterThanOrEqual(l.target):
+			filled, canceled = limitOrder, stopOrder
+			price = decimal.Max(l.target, c.Open)
+		default:
+			continue
+		}
+		base, quote, err := e.assets(l.symbol)
+		if err != nil {
+			return err
+		}
+		filled.status = orderFilled
+		filled.quoteQty = l.quantity.Mul(price)
+		canceled.status = orderCanceled
+		e.balances[base] = e.balances[base].Sub(l.quantity)
+		e.balances[quote] = e.balances[quote].Add(filled.quoteQty)
+		return nil
+	}
+	return nil
+}
+
+func (e *backtestExchange) assets(symbol string) (string, string, error) {
+	assets, ok := e.symbols[symbol]
+	if !ok {
+		return "", "", fmt.Errorf("backtest: unknown symbol %s", symbol)
+	}
+	return assets[0], assets[1], nil
+}
+
+// parseInterval parses binance intervals like `15m`, `1h`, `1d` or `1w`
+func parseInterval(interval string) (time.Duration, error) {
+	if len(interval) < 2 {
+		return 0, fmt.Errorf("backtest: invalid interval %s", interval)
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil {
+		return 0, fmt.Errorf("backtest: invalid interval %s: %w", interval, err)
+	}
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	unit, ok := units[interval[len(interval)-1]]
+	if !ok {
+		return 0, fmt.Errorf("backtest: invalid interval %s", interval)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func (e *backtestExchange) nextID() string {
+	e.lastID++
+	return strconv.Itoa(e.lastID)
+}
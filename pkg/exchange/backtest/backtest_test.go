@@ -0,0 +1,144 @@
+package backtest
+
+import (
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/shopspring/decimal"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "binance csv dump",
+			data: `1630454400000,10.0,10.5,9.5,10.2,100.0,1630454459999,1000.0,10,50.0,500.0,0
+1630454460000,10.2,10.8,10.1,10.7,100.0,1630454519999,1000.0,10,50.0,500.0,0`,
+		},
+		{
+			name: "csv with header",
+			data: `open_time,open,high,low,close
+2021-09-01T00:00:00Z,10.0,10.5,9.5,10.2
+2021-09-01T00:01:00Z,10.2,10.8,10.1,10.7`,
+		},
+		{
+			name: "binance json",
+			data: `[
+	[1630454400000,"10.0","10.5","9.5","10.2","100.0",1630454459999,"1000.0",10,"50.0","500.0","0"],
+	[1630454460000,"10.2","10.8","10.1","10.7","100.0",1630454519999,"1000.0",10,"50.0","500.0","0"]
+]`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			candles, err := Read(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(candles) != 2 {
+				t.Fatalf("wrong number of candles: want 2, got %d", len(candles))
+			}
+			c := candles[1]
+			if want := time.Date(2021, 9, 1, 0, 1, 0, 0, time.UTC); !c.OpenTime.Equal(want) {
+				t.Errorf("wrong open time: want %s, got %s", want, c.OpenTime)
+			}
+			if want := time.Date(2021, 9, 1, 0, 1, 59, 999000000, time.UTC); !c.CloseTime.Equal(want) {
+				t.Errorf("wrong close time: want %s, got %s", want, c.CloseTime)
+			}
+			if want := decimal.NewFromFloat(10.8); !c.High.Equal(want) {
+				t.Errorf("wrong high: want %s, got %s", want, c.High)
+			}
+			if want := decimal.NewFromFloat(10.7); !c.Close.Equal(want) {
+				t.Errorf("wrong close: want %s, got %s", want, c.Close)
+			}
+		})
+	}
+}
+
+func TestTargetReached(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	// Price increases 0.1 every minute
+	klines := newSeries("IGOUSDT", start, 10.0, 0.1, 100)
+	got := runTrade(t, start, klines)
+
+	// OCO limit leg filled at target 5
+	want := decimal.NewFromFloat(150.0)
+	if !got.EndQuoteQuantity.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, got.EndQuoteQuantity)
+	}
+	if got.CurrentTarget != 4 {
+		t.Errorf("wrong current target: want 4, got %d", got.CurrentTarget)
+	}
+}
+
+func TestStopReached(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	// Price decreases 0.1 every minute
+	klines := newSeries("IGOUSDT", start, 10.0, -0.1, 100)
+	got := runTrade(t, start, klines)
+
+	// OCO stop leg filled at stop price
+	want := decimal.NewFromFloat(90.0)
+	if !got.EndQuoteQuantity.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, got.EndQuoteQuantity)
+	}
+	if want := start.Add(10 * time.Minute); got.EndTime.Before(want) {
+		t.Errorf("wrong end time: want after %s, got %s", want, got.EndTime)
+	}
+}
+
+func runTrade(t *testing.T, start time.Time, klines Klines) *trade.Trade {
+	t.Helper()
+	targets := []decimal.Decimal{
+		decimal.NewFromFloat(11.0),
+		decimal.NewFromFloat(12.0),
+		decimal.NewFromFloat(13.0),
+		decimal.NewFromFloat(14.0),
+		decimal.NewFromFloat(15.0),
+		decimal.NewFromFloat(16.0),
+	}
+	tr := trade.New("IGO", "USDT", decimal.NewFromFloat(10.0), targets, decimal.NewFromFloat(9.0), decimal.NewFromFloat(100.0))
+	tr.StartTime = start
+
+	clk := clock.NewVirtual(start)
+	ex := New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromFloat(100.0)})
+	trader := trade.NewTrader(event.Printer(log.Println), ex, clk, tr, 5, 5*time.Second, func(t *trade.Trade) error { return nil }, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := trader.Create(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := trader.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func newSeries(symbol string, start time.Time, price, inc float64, n int) Series {
+	var candles []exchange.Candle
+	open := decimal.NewFromFloat(price)
+	for i := 0; i < n; i++ {
+		close := open.Add(decimal.NewFromFloat(inc))
+		candles = append(candles, exchange.Candle{
+			OpenTime:  start.Add(time.Duration(i) * time.Minute),
+			CloseTime: start.Add(time.Duration(i+1)*time.Minute - time.Millisecond),
+			Open:      open,
+			High:      decimal.Max(open, close),
+			Low:       decimal.Min(open, close),
+			Close:     close,
+		})
+		open = close
+	}
+	return Series{symbol: candles}
+}
@@ -0,0 +1,231 @@
+package backtest
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+// Klines provides historical candles sorted by open time
+type Klines interface {
+	Candles(symbol string) ([]exchange.Candle, error)
+}
+
+// Series is an in-memory set of candles indexed by symbol
+type Series map[string][]exchange.Candle
+
+func (s Series) Candles(symbol string) ([]exchange.Candle, error) {
+	candles, ok := s[symbol]
+	if !ok || len(candles) == 0 {
+		return nil, fmt.Errorf("backtest: no klines for %s", symbol)
+	}
+	return candles, nil
+}
+
+type dir struct {
+	path    string
+	candles map[string][]exchange.Candle
+	lock    sync.Mutex
+}
+
+// NewDir returns klines loaded from files in a directory.
+// Files must be named after the symbol, e.g. `BTCUSDT.csv` or
+// `BTCUSDT-1m-2021-09.csv` as in binance data dumps, and can be in CSV or
+// binance kline JSON format.
+func NewDir(path string) Klines {
+	return &dir{
+		path:    path,
+		candles: make(map[string][]exchange.Candle),
+	}
+}
+
+func (d *dir) Candles(symbol string) ([]exchange.Candle, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if candles, ok := d.candles[symbol]; ok {
+		return candles, nil
+	}
+
+	var files []string
+	for _, pattern := range []string{"%s.*", "%s-*"} {
+		matches, err := filepath.Glob(filepath.Join(d.path, fmt.Sprintf(pattern, symbol)))
+		if err != nil {
+			return nil, fmt.Errorf("backtest: couldn't list files for %s: %w", symbol, err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("backtest: no kline files for %s in %s", symbol, d.path)
+	}
+
+	var candles []exchange.Candle
+	for _, file := range files {
+		c, err := ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, c...)
+	}
+	candles = sortCandles(candles)
+	d.candles[symbol] = candles
+	return candles, nil
+}
+
+// ReadFile reads candles from a CSV or JSON file
+func ReadFile(path string) ([]exchange.Candle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("backtest: couldn't open %s: %w", path, err)
+	}
+	defer f.Close()
+	candles, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("backtest: couldn't read %s: %w", path, err)
+	}
+	return candles, nil
+}
+
+// Read reads candles in CSV or binance kline JSON format.
+// CSV rows must start with open time, open, high, low and close columns,
+// optionally followed by volume and close time as in binance data dumps.
+func Read(r io.Reader) ([]exchange.Candle, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("backtest: empty klines: %w", err)
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+			continue
+		case '[':
+			return readJSON(br)
+		default:
+			return readCSV(br)
+		}
+	}
+}
+
+func readJSON(r io.Reader) ([]exchange.Candle, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var rows [][]interface{}
+	if err := dec.Decode(&rows); err != nil {
+		return nil, fmt.Errorf("backtest: couldn't decode json klines: %w", err)
+	}
+	var records [][]string
+	for _, row := range rows {
+		var record []string
+		for _, v := range row {
+			record = append(record, fmt.Sprint(v))
+		}
+		records = append(records, record)
+	}
+	return parseRecords(records)
+}
+
+func readCSV(r io.Reader) ([]exchange.Candle, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("backtest: couldn't decode csv klines: %w", err)
+	}
+	// Skip header
+	if len(records) > 0 && len(records[0]) > 1 {
+		if _, err := decimal.NewFromString(records[0][1]); err != nil {
+			records = records[1:]
+		}
+	}
+	return parseRecords(records)
+}
+
+func parseRecords(records [][]string) ([]exchange.Candle, error) {
+	var candles []exchange.Candle
+	for i, record := range records {
+		if len(record) < 5 {
+			return nil, fmt.Errorf("backtest: kline %d has %d fields", i, len(record))
+		}
+		var c exchange.Candle
+		var err error
+		if c.OpenTime, err = parseTime(record[0]); err != nil {
+			return nil, fmt.Errorf("backtest: kline %d: %w", i, err)
+		}
+		values := []*decimal.Decimal{&c.Open, &c.High, &c.Low, &c.Close}
+		for j, v := range values {
+			if *v, err = decimal.NewFromString(record[j+1]); err != nil {
+				return nil, fmt.Errorf("backtest: kline %d: couldn't parse %s: %w", i, record[j+1], err)
+			}
+		}
+		if len(record) >= 7 {
+			if c.CloseTime, err = parseTime(record[6]); err != nil {
+				return nil, fmt.Errorf("backtest: kline %d: %w", i, err)
+			}
+		}
+		candles = append(candles, c)
+	}
+	return sortCandles(candles), nil
+}
+
+// sortCandles sorts candles, removes duplicates and fills missing close times
+func sortCandles(candles []exchange.Candle) []exchange.Candle {
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].OpenTime.Before(candles[j].OpenTime)
+	})
+	var sorted []exchange.Candle
+	for i, c := range candles {
+		if i > 0 && c.OpenTime.Equal(candles[i-1].OpenTime) {
+			continue
+		}
+		sorted = append(sorted, c)
+	}
+	for i := range sorted {
+		if !sorted[i].CloseTime.IsZero() {
+			continue
+		}
+		var interval time.Duration
+		switch {
+		case i+1 < len(sorted):
+			interval = sorted[i+1].OpenTime.Sub(sorted[i].OpenTime)
+		case i > 0:
+			interval = sorted[i].OpenTime.Sub(sorted[i-1].OpenTime)
+		default:
+			interval = time.Minute
+		}
+		sorted[i].CloseTime = sorted[i].OpenTime.Add(interval - time.Millisecond)
+	}
+	return sorted
+}
+
+// parseTime parses unix timestamps in milliseconds or microseconds and
+// RFC3339 dates
+func parseTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("couldn't parse time %s: %w", v, err)
+		}
+		return t.UTC(), nil
+	}
+	// Newer binance dumps use microseconds
+	if n > 1e14 {
+		return time.Unix(0, n*int64(time.Microsecond)).UTC(), nil
+	}
+	return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+}
@@ -9,22 +9,30 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
+	"github.com/gorilla/websocket"
 	"github.com/igolaizola/zeken/pkg/exchange"
 	"github.com/shopspring/decimal"
 )
 
 type binanceExchange struct {
-	client *binance.Client
-	log    func(v ...interface{})
-	debug  bool
+	client  *binance.Client
+	filters *filterCache
+	limiter *limiter
+	orders  orderStream
+	wsURL   string
+	dialer  *websocket.Dialer
+	log     func(v ...interface{})
+	debug   bool
+	// sleep waits while order status polls are rate limited
+	sleep func(ctx context.Context, d time.Duration) error
 }
 
 const (
 	decimalPrecision = 8
+	wsURL            = "wss://stream.binance.com:9443/ws"
 )
 
 var zero = decimal.Decimal{}
@@ -36,6 +44,10 @@ func New(log func(v ...interface{}), apiKey, apiSecret, proxy string, debug bool
 	httpClient := &http.Client{
 		Timeout: 10 * time.Second,
 	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
 	if proxy != "" {
 		proxyURL, err := url.Parse(proxy)
 		if err != nil {
@@ -44,15 +56,23 @@ func New(log func(v ...interface{}), apiKey, apiSecret, proxy string, debug bool
 			httpClient.Transport = &http.Transport{
 				Proxy: http.ProxyURL(proxyURL),
 			}
+			dialer.Proxy = http.ProxyURL(proxyURL)
 		}
 	}
+	limits := newLimiter(time.Now)
+	httpClient.Transport = newLimitTransport(httpClient.Transport, limits)
 	cli.HTTPClient = httpClient
 
 	cli.NewSetServerTimeService().Do(context.Background())
 	return &binanceExchange{
-		client: cli,
-		log:    log,
-		debug:  debug,
+		client:  cli,
+		filters: newFilterCache(cli, limits.setLimits, log, filterRefresh),
+		limiter: limits,
+		wsURL:   wsURL,
+		dialer:  dialer,
+		log:     log,
+		debug:   debug,
+		sleep:   sleep,
 	}
 }
 
@@ -70,6 +90,9 @@ func (c *binanceExchange) Buy(ctx context.Context, symbol string, quoteQuantity,
 		return zero, zero, fmt.Errorf("binance: current price is lower than minimum price: %s %s", currentPrice, price)
 	}
 	quoteQty, qty, err := c.buyLimit(ctx, symbol, quoteQuantity, currentPrice)
+	if errors.Is(err, exchange.ErrDust) {
+		return zero, zero, err
+	}
 	if err != nil {
 		c.log(fmt.Errorf("binance: buy limit failed, falling back to buy market: %w", err))
 		return c.buyMarket(ctx, symbol, quoteQuantity)
@@ -78,7 +101,21 @@ func (c *binanceExchange) Buy(ctx context.Context, symbol string, quoteQuantity,
 }
 
 func (e *binanceExchange) Sell(ctx context.Context, symbol string, quantity decimal.Decimal) (decimal.Decimal, error) {
-	quantity = quantity.Round(decimalPrecision)
+	f, err := e.filters.get(ctx, symbol)
+	if err != nil {
+		return zero, err
+	}
+	// The price is only needed if the minimum notional applies to market orders
+	var price decimal.Decimal
+	if f.notionalMarket && f.minNotional.IsPositive() {
+		if price, err = e.Price(ctx, symbol); err != nil {
+			return zero, err
+		}
+	}
+	quantity, err = f.quantity(quantity, price, true)
+	if err != nil {
+		return zero, fmt.Errorf("binance: couldn't sell: %w", err)
+	}
 	order, err := e.client.NewCreateOrderService().Symbol(symbol).
 		Side(binance.SideTypeSell).
 		Type(binance.OrderTypeMarket).
@@ -92,29 +129,22 @@ func (e *binanceExchange) Sell(ctx context.Context, symbol string, quantity deci
 		js, _ := json.Marshal(order)
 		e.log("sell_order", string(js))
 	}
-	id := order.OrderID
-	for {
-		select {
-		case <-ctx.Done():
-			return zero, ctx.Err()
-		default:
-		}
-		ok, quoteQty, _, err := e.getOrder(ctx, symbol, strconv.Itoa(int(id)))
-		var netErr net.Error
-		if errors.As(err, &netErr) && netErr.Timeout() {
-			continue
-		}
-		if err != nil {
-			return zero, fmt.Errorf("binance: couldn't get sell order: %w", err)
-		}
-		if ok {
-			return quoteQty, nil
-		}
+	quoteQty, _, err := e.awaitOrder(ctx, symbol, order.OrderID)
+	if err != nil {
+		return zero, fmt.Errorf("binance: couldn't get sell order: %w", err)
 	}
+	return quoteQty, nil
 }
 
 func (e *binanceExchange) buyMarket(ctx context.Context, symbol string, quoteQuantity decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
-	quoteQty := quoteQuantity.Round(decimalPrecision)
+	f, err := e.filters.get(ctx, symbol)
+	if err != nil {
+		return zero, zero, err
+	}
+	quoteQty, err := f.quoteQuantity(quoteQuantity)
+	if err != nil {
+		return zero, zero, fmt.Errorf("binance: couldn't buy market: %w", err)
+	}
 	order, err := e.client.NewCreateOrderService().Symbol(symbol).
 		Side(binance.SideTypeBuy).
 		Type(binance.OrderTypeMarket).
@@ -128,46 +158,26 @@ func (e *binanceExchange) buyMarket(ctx context.Context, symbol string, quoteQua
 		js, _ := json.Marshal(order)
 		e.log("buy_market_order:", string(js))
 	}
-	id := order.OrderID
-	for {
-		select {
-		case <-ctx.Done():
-			return zero, zero, ctx.Err()
-		default:
-		}
-		ok, quoteQty, qty, err := e.getOrder(ctx, symbol, strconv.Itoa(int(id)))
-		var netErr net.Error
-		if errors.As(err, &netErr) && netErr.Timeout() {
-			continue
-		}
-		if err != nil {
-			return zero, zero, fmt.Errorf("binance: couldn't get buy market order: %w", err)
-		}
-		if ok {
-			return quoteQty, qty, nil
-		}
+	quoteQty, qty, err := e.awaitOrder(ctx, symbol, order.OrderID)
+	if err != nil {
+		return zero, zero, fmt.Errorf("binance: couldn't get buy market order: %w", err)
 	}
+	return quoteQty, qty, nil
 }
 
 func (e *binanceExchange) buyLimit(ctx context.Context, symbol string, quoteQuantity, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
-	precision := decimalPrecision
-	info, err := e.client.NewExchangeInfoService().Symbol(symbol).Do(ctx)
+	f, err := e.filters.get(ctx, symbol)
 	if err != nil {
-		return zero, zero, fmt.Errorf("binance: couldn't get exchange info for %s: %w", symbol, err)
+		return zero, zero, err
 	}
-	for _, s := range info.Symbols {
-		if s.Symbol != symbol {
-			continue
-		}
-		lotSize := s.LotSizeFilter()
-		split := strings.Split(lotSize.StepSize, ".")
-		if len(split) != 2 {
-			return zero, zero, errors.New("binance: couldn't parse step size %s")
-		}
-		precision = len(strings.TrimRight(split[1], "0"))
+	price, err = f.price(price, price, binance.SideTypeBuy)
+	if err != nil {
+		return zero, zero, fmt.Errorf("binance: couldn't buy limit: %w", err)
+	}
+	qty, err := f.quantity(quoteQuantity.Div(price), price, false)
+	if err != nil {
+		return zero, zero, fmt.Errorf("binance: couldn't buy limit: %w", err)
 	}
-
-	qty := quoteQuantity.Div(price).Round(int32(precision))
 	order, err := e.client.NewCreateOrderService().Symbol(symbol).
 		Side(binance.SideTypeBuy).
 		Type(binance.OrderTypeLimit).
@@ -183,7 +193,17 @@ func (e *binanceExchange) buyLimit(ctx context.Context, symbol string, quoteQuan
 		js, _ := json.Marshal(order)
 		e.log("buy_limit_order:", string(js))
 	}
-	id := order.OrderID
+	quoteQty, qty, err := e.awaitOrder(ctx, symbol, order.OrderID)
+	if err != nil {
+		return zero, zero, fmt.Errorf("binance: couldn't get buy limit order: %w", err)
+	}
+	return quoteQty, qty, nil
+}
+
+// awaitOrder polls an order until it is filled. The order has already been
+// sent, so rate limits are waited instead of failing the call, otherwise the
+// caller would send the order again.
+func (e *binanceExchange) awaitOrder(ctx context.Context, symbol string, id int64) (decimal.Decimal, decimal.Decimal, error) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -195,8 +215,15 @@ func (e *binanceExchange) buyLimit(ctx context.Context, symbol string, quoteQuan
 		if errors.As(err, &netErr) && netErr.Timeout() {
 			continue
 		}
+		var rateErr *exchange.RateLimitError
+		if errors.As(err, &rateErr) {
+			if err := e.sleep(ctx, rateErr.RetryAfter); err != nil {
+				return zero, zero, err
+			}
+			continue
+		}
 		if err != nil {
-			return zero, zero, fmt.Errorf("binance: couldn't get buy limit order: %w", err)
+			return zero, zero, err
 		}
 		if ok {
 			return quoteQty, qty, nil
@@ -205,25 +232,32 @@ func (e *binanceExchange) buyLimit(ctx context.Context, symbol string, quoteQuan
 }
 
 func (e *binanceExchange) CreateStopLimit(ctx context.Context, symbol string, quantity, target, stop decimal.Decimal) (string, []string, error) {
-	precision := decimalPrecision
-	info, err := e.client.NewExchangeInfoService().Symbol(symbol).Do(ctx)
+	f, err := e.filters.get(ctx, symbol)
 	if err != nil {
-		return "", nil, fmt.Errorf("binance: couldn't get exchange info for %s: %w", symbol, err)
+		return "", nil, err
 	}
-	for _, s := range info.Symbols {
-		if s.Symbol != symbol {
-			continue
+	// The current price is used as reference of the percent price filter
+	var current decimal.Decimal
+	if f.multiplierUp.IsPositive() || f.multiplierDown.IsPositive() {
+		if current, err = e.Price(ctx, symbol); err != nil {
+			return "", nil, err
 		}
-		priceFilter := s.PriceFilter()
-		split := strings.Split(priceFilter.TickSize, ".")
-		if len(split) != 2 {
-			return "", nil, errors.New("binance: couldn't parse step size %s")
-		}
-		precision = len(strings.TrimRight(split[1], "0"))
 	}
-	target = target.Round(int32(precision))
-	stop = stop.Round(int32(precision))
-	limit := stop.Mul(decimal.NewFromFloat(0.99)).Round(int32(precision))
+	limit := stop.Mul(decimal.NewFromFloat(0.99))
+	if target, err = f.price(target, current, binance.SideTypeSell); err != nil {
+		return "", nil, fmt.Errorf("binance: couldn't create stop limit: %w", err)
+	}
+	if stop, err = f.price(stop, current, binance.SideTypeSell); err != nil {
+		return "", nil, fmt.Errorf("binance: couldn't create stop limit: %w", err)
+	}
+	if limit, err = f.price(limit, current, binance.SideTypeSell); err != nil {
+		return "", nil, fmt.Errorf("binance: couldn't create stop limit: %w", err)
+	}
+	// The stop limit price is the lowest one of the order
+	quantity, err = f.quantity(quantity, limit, false)
+	if err != nil {
+		return "", nil, fmt.Errorf("binance: couldn't create stop limit: %w", err)
+	}
 
 	order, err := e.client.NewCreateOCOService().Symbol(symbol).
 		Side(binance.SideTypeSell).
@@ -340,3 +374,25 @@ func (e *binanceExchange) Balance(ctx context.Context, currency string) (decimal
 	}
 	return zero, fmt.Errorf("binance: balance for %s not found", currency)
 }
+
+func (e *binanceExchange) Candles(ctx context.Context, symbol string, interval string, limit int) ([]exchange.Candle, error) {
+	klines, err := e.client.NewKlinesService().Symbol(symbol).Interval(interval).Limit(limit).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("binance: couldn't get klines for %s: %w", symbol, err)
+	}
+	var candles []exchange.Candle
+	for _, k := range klines {
+		c := exchange.Candle{
+			OpenTime:  time.Unix(0, k.OpenTime*int64(time.Millisecond)).UTC(),
+			CloseTime: time.Unix(0, k.CloseTime*int64(time.Millisecond)).UTC(),
+		}
+		values := []string{k.Open, k.High, k.Low, k.Close}
+		for i, d := range []*decimal.Decimal{&c.Open, &c.High, &c.Low, &c.Close} {
+			if *d, err = decimal.NewFromString(values[i]); err != nil {
+				return nil, fmt.Errorf("binance: couldn't parse kline value %s: %w", values[i], err)
+			}
+		}
+		candles = append(candles, c)
+	}
+	return candles, nil
+}
@@ -0,0 +1,270 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// filterRefresh is how often the exchange info is downloaded again
+	filterRefresh = time.Hour
+	// filterRetry is the wait before retrying a failed refresh
+	filterRetry = time.Minute
+)
+
+// filters are the trading rules of a symbol, zero values disable a rule
+type filters struct {
+	symbol         string
+	quotePrecision int32
+	// LOT_SIZE and MARKET_LOT_SIZE
+	minQty         decimal.Decimal
+	maxQty         decimal.Decimal
+	stepSize       decimal.Decimal
+	marketMinQty   decimal.Decimal
+	marketMaxQty   decimal.Decimal
+	marketStepSize decimal.Decimal
+	// PRICE_FILTER
+	minPrice decimal.Decimal
+	maxPrice decimal.Decimal
+	tickSize decimal.Decimal
+	// MIN_NOTIONAL or NOTIONAL
+	minNotional    decimal.Decimal
+	notionalMarket bool
+	// PERCENT_PRICE
+	multiplierUp   decimal.Decimal
+	multiplierDown decimal.Decimal
+	// PERCENT_PRICE_BY_SIDE, bid multipliers apply to buy orders and ask
+	// multipliers to sell orders
+	bidMultiplierUp   decimal.Decimal
+	bidMultiplierDown decimal.Decimal
+	askMultiplierUp   decimal.Decimal
+	askMultiplierDown decimal.Decimal
+}
+
+// filterCache keeps the filters of all symbols and refreshes them when they
+// are older than the refresh interval
+type filterCache struct {
+	fetch   func(ctx context.Context) ([]binance.Symbol, error)
+	refresh time.Duration
+	log     func(v ...interface{})
+	now     func() time.Time
+	lock    sync.Mutex
+	symbols map[string]*filters
+	updated time.Time
+	failed  time.Time
+}
+
+// newFilterCache creates a filter cache, rateLimits is called with the rate
+// limits of each exchange info and may be nil
+func newFilterCache(client *binance.Client, rateLimits func([]binance.RateLimit), log func(v ...interface{}), refresh time.Duration) *filterCache {
+	return &filterCache{
+		fetch: func(ctx context.Context) ([]binance.Symbol, error) {
+			info, err := client.NewExchangeInfoService().Do(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if rateLimits != nil {
+				rateLimits(info.RateLimits)
+			}
+			return info.Symbols, nil
+		},
+		refresh: refresh,
+		log:     log,
+		now:     time.Now,
+	}
+}
+
+// get returns the filters of the symbol, cached filters are used if the
+// exchange info can't be refreshed and the refresh is retried later
+func (c *filterCache) get(ctx context.Context, symbol string) (*filters, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	stale := now.Sub(c.updated) >= c.refresh && now.Sub(c.failed) >= filterRetry
+	if c.symbols == nil || stale {
+		symbols, err := c.load(ctx)
+		if err != nil {
+			c.failed = now
+		}
+		switch {
+		case err == nil:
+			c.symbols = symbols
+			c.updated = now
+		case c.symbols[symbol] != nil:
+			c.log(fmt.Errorf("binance: couldn't refresh exchange info, using cached filters: %w", err))
+		default:
+			return nil, err
+		}
+	}
+	f, ok := c.symbols[symbol]
+	if !ok {
+		return nil, fmt.Errorf("binance: symbol %s not found in exchange info", symbol)
+	}
+	return f, nil
+}
+
+func (c *filterCache) load(ctx context.Context) (map[string]*filters, error) {
+	symbols, err := c.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("binance: couldn't get exchange info: %w", err)
+	}
+	m := make(map[string]*filters)
+	for _, s := range symbols {
+		f, err := parseFilters(s)
+		if err != nil {
+			return nil, err
+		}
+		m[s.Symbol] = f
+	}
+	return m, nil
+}
+
+// parseFilters parses the filters of the exchange info symbol
+func parseFilters(s binance.Symbol) (*filters, error) {
+	f := &filters{symbol: s.Symbol, quotePrecision: int32(s.QuotePrecision)}
+	for _, raw := range s.Filters {
+		typ, _ := raw["filterType"].(string)
+		var values map[string]*decimal.Decimal
+		switch typ {
+		case "LOT_SIZE":
+			values = map[string]*decimal.Decimal{"minQty": &f.minQty, "maxQty": &f.maxQty, "stepSize": &f.stepSize}
+		case "MARKET_LOT_SIZE":
+			values = map[string]*decimal.Decimal{"minQty": &f.marketMinQty, "maxQty": &f.marketMaxQty, "stepSize": &f.marketStepSize}
+		case "PRICE_FILTER":
+			values = map[string]*decimal.Decimal{"minPrice": &f.minPrice, "maxPrice": &f.maxPrice, "tickSize": &f.tickSize}
+		case "PERCENT_PRICE":
+			values = map[string]*decimal.Decimal{"multiplierUp": &f.multiplierUp, "multiplierDown": &f.multiplierDown}
+		case "PERCENT_PRICE_BY_SIDE":
+			values = map[string]*decimal.Decimal{
+				"bidMultiplierUp": &f.bidMultiplierUp, "bidMultiplierDown": &f.bidMultiplierDown,
+				"askMultiplierUp": &f.askMultiplierUp, "askMultiplierDown": &f.askMultiplierDown,
+			}
+		case "MIN_NOTIONAL":
+			values = map[string]*decimal.Decimal{"minNotional": &f.minNotional}
+			f.notionalMarket, _ = raw["applyToMarket"].(bool)
+		case "NOTIONAL":
+			values = map[string]*decimal.Decimal{"minNotional": &f.minNotional}
+			f.notionalMarket, _ = raw["applyMinToMarket"].(bool)
+		}
+		for k, d := range values {
+			v, ok := raw[k].(string)
+			if !ok {
+				continue
+			}
+			var err error
+			if *d, err = decimal.NewFromString(v); err != nil {
+				return nil, fmt.Errorf("binance: couldn't parse %s %s %s: %w", s.Symbol, typ, k, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+// quantity rounds the quantity down to the lot step and validates it, the
+// notional value is only checked if price is positive
+func (f *filters) quantity(qty, price decimal.Decimal, market bool) (decimal.Decimal, error) {
+	qty = floor(qty, f.stepSize)
+	if market {
+		qty = floor(qty, f.marketStepSize)
+	}
+	if err := f.lotSize("LOT_SIZE", qty, f.minQty, f.maxQty); err != nil {
+		return zero, err
+	}
+	if market {
+		if err := f.lotSize("MARKET_LOT_SIZE", qty, f.marketMinQty, f.marketMaxQty); err != nil {
+			return zero, err
+		}
+	}
+	if price.IsPositive() {
+		if err := f.notional(qty.Mul(price), market); err != nil {
+			return zero, err
+		}
+	}
+	return qty, nil
+}
+
+func (f *filters) lotSize(name string, qty, min, max decimal.Decimal) error {
+	if !qty.IsPositive() || qty.LessThan(min) {
+		return &exchange.FilterError{Symbol: f.symbol, Filter: name, Value: qty, Limit: min, Dust: true}
+	}
+	if max.IsPositive() && qty.GreaterThan(max) {
+		return &exchange.FilterError{Symbol: f.symbol, Filter: name, Value: qty, Limit: max}
+	}
+	return nil
+}
+
+// quoteQuantity rounds down and validates the quote quantity of a market
+// order
+func (f *filters) quoteQuantity(quoteQty decimal.Decimal) (decimal.Decimal, error) {
+	precision := f.quotePrecision
+	if precision <= 0 {
+		precision = decimalPrecision
+	}
+	quoteQty = quoteQty.Truncate(precision)
+	if err := f.notional(quoteQty, true); err != nil {
+		return zero, err
+	}
+	return quoteQty, nil
+}
+
+func (f *filters) notional(value decimal.Decimal, market bool) error {
+	if market && !f.notionalMarket {
+		return nil
+	}
+	if value.LessThan(f.minNotional) {
+		return &exchange.FilterError{Symbol: f.symbol, Filter: "MIN_NOTIONAL", Value: value, Limit: f.minNotional, Dust: true}
+	}
+	return nil
+}
+
+// price rounds the price to the tick size and validates it, the percent
+// price filters are only checked if the reference price is positive
+func (f *filters) price(price, reference decimal.Decimal, side binance.SideType) (decimal.Decimal, error) {
+	if f.tickSize.IsPositive() {
+		price = price.Div(f.tickSize).Round(0).Mul(f.tickSize)
+	}
+	if !price.IsPositive() || price.LessThan(f.minPrice) {
+		return zero, &exchange.FilterError{Symbol: f.symbol, Filter: "PRICE_FILTER", Value: price, Limit: f.minPrice}
+	}
+	if f.maxPrice.IsPositive() && price.GreaterThan(f.maxPrice) {
+		return zero, &exchange.FilterError{Symbol: f.symbol, Filter: "PRICE_FILTER", Value: price, Limit: f.maxPrice}
+	}
+	if !reference.IsPositive() {
+		return price, nil
+	}
+	if err := f.percentPrice("PERCENT_PRICE", price, reference, f.multiplierUp, f.multiplierDown); err != nil {
+		return zero, err
+	}
+	up, down := f.askMultiplierUp, f.askMultiplierDown
+	if side == binance.SideTypeBuy {
+		up, down = f.bidMultiplierUp, f.bidMultiplierDown
+	}
+	if err := f.percentPrice("PERCENT_PRICE_BY_SIDE", price, reference, up, down); err != nil {
+		return zero, err
+	}
+	return price, nil
+}
+
+func (f *filters) percentPrice(name string, price, reference, multiplierUp, multiplierDown decimal.Decimal) error {
+	if up := reference.Mul(multiplierUp); up.IsPositive() && price.GreaterThan(up) {
+		return &exchange.FilterError{Symbol: f.symbol, Filter: name, Value: price, Limit: up}
+	}
+	if down := reference.Mul(multiplierDown); down.IsPositive() && price.LessThan(down) {
+		return &exchange.FilterError{Symbol: f.symbol, Filter: name, Value: price, Limit: down}
+	}
+	return nil
+}
+
+// floor rounds the value down to a multiple of step
+func floor(value, step decimal.Decimal) decimal.Decimal {
+	if !step.IsPositive() {
+		return value
+	}
+	return value.Div(step).Floor().Mul(step)
+}
@@ -0,0 +1,317 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+const exchangeInfo = `{
+  "timezone": "UTC",
+  "serverTime": 1620000000000,
+  "symbols": [
+    {
+      "symbol": "IGOUSDT",
+      "status": "TRADING",
+      "baseAsset": "IGO",
+      "baseAssetPrecision": 8,
+      "quoteAsset": "USDT",
+      "quotePrecision": 8,
+      "filters": [
+        {"filterType": "PRICE_FILTER", "minPrice": "0.01000000", "maxPrice": "1000.00000000", "tickSize": "0.01000000"},
+        {"filterType": "PERCENT_PRICE", "multiplierUp": "5", "multiplierDown": "0.2", "avgPriceMins": 5},
+        {"filterType": "LOT_SIZE", "minQty": "0.10000000", "maxQty": "9000.00000000", "stepSize": "0.10000000"},
+        {"filterType": "MIN_NOTIONAL", "minNotional": "10.00000000", "applyToMarket": true, "avgPriceMins": 5},
+        {"filterType": "MARKET_LOT_SIZE", "minQty": "0.00000000", "maxQty": "500.00000000", "stepSize": "0.00000000"}
+      ]
+    },
+    {
+      "symbol": "ZEKUSDT",
+      "status": "TRADING",
+      "baseAsset": "ZEK",
+      "baseAssetPrecision": 8,
+      "quoteAsset": "USDT",
+      "quotePrecision": 2,
+      "filters": [
+        {"filterType": "PRICE_FILTER", "minPrice": "0.00010000", "maxPrice": "0.00000000", "tickSize": "0.00010000"},
+        {"filterType": "LOT_SIZE", "minQty": "1.00000000", "maxQty": "0.00000000", "stepSize": "1.00000000"},
+        {"filterType": "NOTIONAL", "minNotional": "5.00000000", "applyMinToMarket": false, "maxNotional": "9000000.00000000"}
+      ]
+    },
+    {
+      "symbol": "KENUSDT",
+      "status": "TRADING",
+      "baseAsset": "KEN",
+      "baseAssetPrecision": 8,
+      "quoteAsset": "USDT",
+      "quotePrecision": 8,
+      "filters": [
+        {"filterType": "PRICE_FILTER", "minPrice": "0.01000000", "maxPrice": "10000.00000000", "tickSize": "0.01000000"},
+        {"filterType": "PERCENT_PRICE_BY_SIDE", "bidMultiplierUp": "1.2", "bidMultiplierDown": "0.2", "askMultiplierUp": "5", "askMultiplierDown": "0.8", "avgPriceMins": 5},
+        {"filterType": "LOT_SIZE", "minQty": "0.01000000", "maxQty": "9000.00000000", "stepSize": "0.01000000"}
+      ]
+    }
+  ]
+}`
+
+func testFilters(t *testing.T) map[string]*filters {
+	t.Helper()
+	var info binance.ExchangeInfo
+	if err := json.Unmarshal([]byte(exchangeInfo), &info); err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]*filters)
+	for _, s := range info.Symbols {
+		f, err := parseFilters(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[s.Symbol] = f
+	}
+	return m
+}
+
+func TestFiltersQuantity(t *testing.T) {
+	symbols := testFilters(t)
+	tests := []struct {
+		name   string
+		symbol string
+		qty    string
+		price  string
+		market bool
+		want   string
+		filter string
+		dust   bool
+	}{
+		{"round down", "IGOUSDT", "12.3456", "10", false, "12.3", "", false},
+		{"below min qty", "IGOUSDT", "0.09", "1000", false, "", "LOT_SIZE", true},
+		{"below min notional", "IGOUSDT", "0.9", "10", false, "", "MIN_NOTIONAL", true},
+		{"above max qty", "IGOUSDT", "9500", "10", false, "", "LOT_SIZE", false},
+		{"above market max qty", "IGOUSDT", "600", "10", true, "", "MARKET_LOT_SIZE", false},
+		{"limit above market max qty", "IGOUSDT", "600", "10", false, "600", "", false},
+		{"notional without price", "IGOUSDT", "0.5", "0", true, "0.5", "", false},
+		{"integer step", "ZEKUSDT", "1234.56", "0.5", false, "1234", "", false},
+		{"notional not applied to market", "ZEKUSDT", "2", "0.5", true, "2", "", false},
+		{"notional applied to limit", "ZEKUSDT", "2", "0.5", false, "", "MIN_NOTIONAL", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := symbols[tt.symbol].quantity(decimal.RequireFromString(tt.qty), decimal.RequireFromString(tt.price), tt.market)
+			checkFilterError(t, err, tt.filter, tt.dust)
+			if tt.filter == "" && !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("wrong quantity: want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFiltersPrice(t *testing.T) {
+	symbols := testFilters(t)
+	buy, sell := binance.SideTypeBuy, binance.SideTypeSell
+	tests := []struct {
+		name      string
+		symbol    string
+		price     string
+		reference string
+		side      binance.SideType
+		want      string
+		filter    string
+	}{
+		{"round to tick", "IGOUSDT", "10.126", "10", sell, "10.13", ""},
+		{"below min price", "IGOUSDT", "0.004", "0", sell, "", "PRICE_FILTER"},
+		{"above max price", "IGOUSDT", "1000.5", "0", sell, "", "PRICE_FILTER"},
+		{"above percent price", "IGOUSDT", "51", "10", sell, "", "PERCENT_PRICE"},
+		{"below percent price", "IGOUSDT", "1.99", "10", buy, "", "PERCENT_PRICE"},
+		{"percent price without reference", "IGOUSDT", "51", "0", sell, "51", ""},
+		{"no max price", "ZEKUSDT", "123456.78912", "0", sell, "123456.7891", ""},
+		{"buy above bid multiplier", "KENUSDT", "12.5", "10", buy, "", "PERCENT_PRICE_BY_SIDE"},
+		{"sell above bid multiplier", "KENUSDT", "12.5", "10", sell, "12.5", ""},
+		{"sell below ask multiplier", "KENUSDT", "7.9", "10", sell, "", "PERCENT_PRICE_BY_SIDE"},
+		{"buy below ask multiplier", "KENUSDT", "7.9", "10", buy, "7.9", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := symbols[tt.symbol].price(decimal.RequireFromString(tt.price), decimal.RequireFromString(tt.reference), tt.side)
+			checkFilterError(t, err, tt.filter, false)
+			if tt.filter == "" && !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("wrong price: want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFiltersQuoteQuantity(t *testing.T) {
+	symbols := testFilters(t)
+	got, err := symbols["ZEKUSDT"].quoteQuantity(decimal.RequireFromString("3.14159"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decimal.RequireFromString("3.14"); !got.Equal(want) {
+		t.Errorf("wrong quote quantity: want %s, got %s", want, got)
+	}
+	_, err = symbols["IGOUSDT"].quoteQuantity(decimal.RequireFromString("9.99"))
+	checkFilterError(t, err, "MIN_NOTIONAL", true)
+}
+
+func checkFilterError(t *testing.T, err error, filter string, dust bool) {
+	t.Helper()
+	if filter == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	var ferr *exchange.FilterError
+	if !errors.As(err, &ferr) {
+		t.Fatalf("expected filter error, got %v", err)
+	}
+	if ferr.Filter != filter {
+		t.Errorf("wrong filter: want %s, got %s", filter, ferr.Filter)
+	}
+	if !errors.Is(err, exchange.ErrInvalidOrder) {
+		t.Errorf("error doesn't match ErrInvalidOrder: %v", err)
+	}
+	if errors.Is(err, exchange.ErrDust) != dust {
+		t.Errorf("wrong dust: want %v, got %v", dust, !dust)
+	}
+}
+
+func TestFilterCache(t *testing.T) {
+	var info binance.ExchangeInfo
+	if err := json.Unmarshal([]byte(exchangeInfo), &info); err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	var fail bool
+	now := time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC)
+	c := &filterCache{
+		fetch: func(ctx context.Context) ([]binance.Symbol, error) {
+			calls++
+			if fail {
+				return nil, errors.New("unavailable")
+			}
+			return info.Symbols, nil
+		},
+		refresh: time.Hour,
+		log:     func(v ...interface{}) {},
+		now:     func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	// Filters are cached until the refresh interval
+	for i := 0; i < 3; i++ {
+		if _, err := c.get(ctx, "IGOUSDT"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("wrong number of calls: want 1, got %d", calls)
+	}
+	if _, err := c.get(ctx, "FOOUSDT"); err == nil {
+		t.Error("expected error for unknown symbol")
+	}
+
+	// Cached filters are used if refresh fails
+	now = now.Add(time.Hour)
+	fail = true
+	if _, err := c.get(ctx, "IGOUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("wrong number of calls: want 2, got %d", calls)
+	}
+
+	// Failed refreshes aren't retried on every call
+	fail = false
+	now = now.Add(filterRetry / 2)
+	if _, err := c.get(ctx, "IGOUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("wrong number of calls: want 2, got %d", calls)
+	}
+
+	// Refresh is retried after the retry wait
+	now = now.Add(filterRetry / 2)
+	if _, err := c.get(ctx, "IGOUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.get(ctx, "IGOUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("wrong number of calls: want 3, got %d", calls)
+	}
+}
+
+func TestCreateStopLimitFilters(t *testing.T) {
+	var params map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/exchangeInfo":
+			_, _ = w.Write([]byte(exchangeInfo))
+		case "/api/v3/ticker/price":
+			_, _ = w.Write([]byte(`{"symbol":"IGOUSDT","price":"10.00"}`))
+		case "/api/v3/order/oco":
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			params = make(map[string]string)
+			for k := range r.Form {
+				params[k] = r.Form.Get(k)
+			}
+			_, _ = w.Write([]byte(`{"orderListId":1,"orders":[{"orderId":2},{"orderId":3}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cli := binance.NewClient("key", "secret")
+	cli.BaseURL = srv.URL
+	ex := &binanceExchange{
+		client:  cli,
+		filters: newFilterCache(cli, nil, log.Println, filterRefresh),
+		log:     log.Println,
+	}
+	ctx := context.Background()
+
+	id, ids, err := ex.CreateStopLimit(ctx, "IGOUSDT", decimal.RequireFromString("12.345"),
+		decimal.RequireFromString("12.3456"), decimal.RequireFromString("9.876"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1" || len(ids) != 2 {
+		t.Errorf("wrong ids: %s %v", id, ids)
+	}
+	want := map[string]string{
+		"quantity":       "12.3",
+		"price":          "12.35",
+		"stopPrice":      "9.88",
+		"stopLimitPrice": "9.78",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("wrong %s: want %s, got %s", k, v, params[k])
+		}
+	}
+
+	// Dust is rejected before sending the order
+	params = nil
+	_, _, err = ex.CreateStopLimit(ctx, "IGOUSDT", decimal.RequireFromString("0.5"),
+		decimal.RequireFromString("12"), decimal.RequireFromString("9"))
+	if !errors.Is(err, exchange.ErrDust) {
+		t.Errorf("expected dust error, got %v", err)
+	}
+	if params != nil {
+		t.Error("order sent with dust quantity")
+	}
+}
@@ -0,0 +1,181 @@
+package binance
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/igolaizola/zeken/pkg/exchange"
+)
+
+const (
+	// Default limits, they are updated with the limits of the exchange info
+	defaultWeightLimit     = 1200
+	defaultOrderLimit      = 50
+	defaultDailyOrderLimit = 160000
+	// defaultRetryAfter is used if a rejected response has no Retry-After
+	defaultRetryAfter = time.Minute
+	// maxDelay is the longest time a request is queued, a rate limit error
+	// is returned if it must wait longer
+	maxDelay = 5 * time.Second
+	// orderWindow is the interval of the order limit
+	orderWindow = 10 * time.Second
+	day         = 24 * time.Hour
+)
+
+// limiter tracks the request weight and the order count reported by binance
+// and delays requests before the limits are reached
+type limiter struct {
+	now             func() time.Time
+	lock            sync.Mutex
+	weightLimit     int
+	orderLimit      int
+	dailyOrderLimit int
+	// Used weight and order counts of their current windows
+	weight       int
+	weightWindow time.Time
+	orders       int
+	orderWindow  time.Time
+	dailyOrders  int
+	day          time.Time
+	// until is the end of the ban after a rejected request
+	until time.Time
+}
+
+func newLimiter(now func() time.Time) *limiter {
+	return &limiter{
+		now:             now,
+		weightLimit:     defaultWeightLimit,
+		orderLimit:      defaultOrderLimit,
+		dailyOrderLimit: defaultDailyOrderLimit,
+	}
+}
+
+// setLimits updates the limits with the rate limits of the exchange info
+func (l *limiter) setLimits(rateLimits []binance.RateLimit) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, r := range rateLimits {
+		switch {
+		case r.RateLimitType == "REQUEST_WEIGHT" && r.Interval == "MINUTE" && r.IntervalNum == 1:
+			l.weightLimit = int(r.Limit)
+		case r.RateLimitType == "ORDERS" && r.Interval == "SECOND" && r.IntervalNum == 10:
+			l.orderLimit = int(r.Limit)
+		case r.RateLimitType == "ORDERS" && r.Interval == "DAY" && r.IntervalNum == 1:
+			l.dailyOrderLimit = int(r.Limit)
+		}
+	}
+}
+
+// wait returns how long a request must wait before being sent.
+// A margin of 10% of each limit is kept for requests of other clients.
+func (l *limiter) wait(order bool) (time.Duration, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	if now.Before(l.until) {
+		return 0, &exchange.RateLimitError{RetryAfter: l.until.Sub(now)}
+	}
+	if window := now.Truncate(time.Minute); window.Equal(l.weightWindow) && l.weight >= l.weightLimit*9/10 {
+		return window.Add(time.Minute).Sub(now), nil
+	}
+	if !order {
+		return 0, nil
+	}
+	if d := now.Truncate(day); d.Equal(l.day) && l.dailyOrders >= l.dailyOrderLimit*9/10 {
+		return 0, &exchange.RateLimitError{RetryAfter: d.Add(day).Sub(now)}
+	}
+	if window := now.Truncate(orderWindow); window.Equal(l.orderWindow) && l.orders >= l.orderLimit*9/10 {
+		return window.Add(orderWindow).Sub(now), nil
+	}
+	return 0, nil
+}
+
+// update reads the used weight and order counts from the response headers,
+// a rate limit error is returned if the request was rejected
+func (l *limiter) update(res *http.Response) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	if v, err := strconv.Atoi(res.Header.Get("X-MBX-USED-WEIGHT-1M")); err == nil {
+		l.weight = v
+		l.weightWindow = now.Truncate(time.Minute)
+	}
+	if v, err := strconv.Atoi(res.Header.Get("X-MBX-ORDER-COUNT-10S")); err == nil {
+		l.orders = v
+		l.orderWindow = now.Truncate(orderWindow)
+	}
+	if v, err := strconv.Atoi(res.Header.Get("X-MBX-ORDER-COUNT-1D")); err == nil {
+		l.dailyOrders = v
+		l.day = now.Truncate(day)
+	}
+	// 429 is returned when a limit is broken and 418 when the IP is banned
+	// for breaking limits after 429 responses
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusTeapot {
+		return nil
+	}
+	retry := defaultRetryAfter
+	if v, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && v > 0 {
+		retry = time.Duration(v) * time.Second
+	}
+	l.until = now.Add(retry)
+	return &exchange.RateLimitError{RetryAfter: retry, Status: res.StatusCode}
+}
+
+// limitTransport sends http requests through the limiter
+type limitTransport struct {
+	next    http.RoundTripper
+	limiter *limiter
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func newLimitTransport(next http.RoundTripper, l *limiter) *limitTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &limitTransport{next: next, limiter: l, sleep: sleep}
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	order := req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/api/v3/order")
+	for {
+		d, err := t.limiter.wait(order)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			break
+		}
+		// Requests are queued until the window ends if the wait is short
+		if d > maxDelay {
+			return nil, &exchange.RateLimitError{RetryAfter: d}
+		}
+		if err := t.sleep(req.Context(), d); err != nil {
+			return nil, err
+		}
+	}
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.limiter.update(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
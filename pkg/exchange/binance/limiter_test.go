@@ -0,0 +1,195 @@
+package binance
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+func TestLimiterWait(t *testing.T) {
+	now := time.Date(2021, 5, 3, 10, 0, 30, 0, time.UTC)
+	l := newLimiter(func() time.Time { return now })
+	l.setLimits([]binance.RateLimit{
+		{RateLimitType: "REQUEST_WEIGHT", Interval: "MINUTE", IntervalNum: 1, Limit: 1000},
+		{RateLimitType: "ORDERS", Interval: "SECOND", IntervalNum: 10, Limit: 20},
+		{RateLimitType: "ORDERS", Interval: "DAY", IntervalNum: 1, Limit: 100},
+	})
+	update := func(headers map[string]string) {
+		t.Helper()
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		for k, v := range headers {
+			res.Header.Set(k, v)
+		}
+		if err := l.update(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(order bool, want time.Duration, limited bool) {
+		t.Helper()
+		got, err := l.wait(order)
+		if errors.Is(err, exchange.ErrRateLimited) != limited {
+			t.Fatalf("wrong rate limit error: want %v, got %v", limited, err)
+		}
+		if got != want {
+			t.Errorf("wrong wait: want %s, got %s", want, got)
+		}
+	}
+
+	// Requests are sent until 90% of the weight is used
+	update(map[string]string{"X-MBX-USED-WEIGHT-1M": "899"})
+	check(false, 0, false)
+	update(map[string]string{"X-MBX-USED-WEIGHT-1M": "900"})
+	check(false, 30*time.Second, false)
+
+	// The weight is reset on the next minute
+	now = now.Add(30 * time.Second)
+	check(false, 0, false)
+
+	// Only orders wait for the order limit
+	now = now.Add(2 * time.Second)
+	update(map[string]string{"X-MBX-USED-WEIGHT-1M": "10", "X-MBX-ORDER-COUNT-10S": "18", "X-MBX-ORDER-COUNT-1D": "50"})
+	check(false, 0, false)
+	check(true, 8*time.Second, false)
+	now = now.Add(8 * time.Second)
+	check(true, 0, false)
+
+	// Orders are rejected until the next day after the daily limit
+	update(map[string]string{"X-MBX-ORDER-COUNT-1D": "90"})
+	check(false, 0, false)
+	check(true, 0, true)
+}
+
+func TestLimitTransport(t *testing.T) {
+	var requests int
+	var weight string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if weight != "" {
+			w.Header().Set("X-MBX-USED-WEIGHT-1M", weight)
+			_, _ = w.Write([]byte(`[{"symbol":"IGOUSDT","price":"10.00"}]`))
+			return
+		}
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"code":-1003,"msg":"Too many requests."}`))
+	}))
+	defer srv.Close()
+
+	now := time.Date(2021, 5, 3, 10, 0, 50, 0, time.UTC)
+	l := newLimiter(func() time.Time { return now })
+	var slept time.Duration
+	transport := newLimitTransport(nil, l)
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		slept += d
+		now = now.Add(d)
+		return nil
+	}
+	cli := binance.NewClient("key", "secret")
+	cli.BaseURL = srv.URL
+	cli.HTTPClient = &http.Client{Transport: transport}
+	ex := &binanceExchange{client: cli}
+	ctx := context.Background()
+
+	// Retry-After is honored without sending requests
+	_, err := ex.Price(ctx, "IGOUSDT")
+	var rateErr *exchange.RateLimitError
+	if !errors.As(err, &rateErr) || rateErr.RetryAfter != 30*time.Second || rateErr.Status != http.StatusTooManyRequests {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	now = now.Add(20 * time.Second)
+	if _, err := ex.Price(ctx, "IGOUSDT"); !errors.Is(err, exchange.ErrRateLimited) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("wrong number of requests: want 1, got %d", requests)
+	}
+
+	// Requests are queued if the weight window ends soon
+	now = now.Add(10 * time.Second)
+	weight = "1100"
+	if _, err := ex.Price(ctx, "IGOUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(37 * time.Second)
+	if _, err := ex.Price(ctx, "IGOUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if slept != 3*time.Second {
+		t.Errorf("wrong sleep: want 3s, got %s", slept)
+	}
+
+	// A rate limit error is returned if the wait is too long
+	if _, err := ex.Price(ctx, "IGOUSDT"); !errors.Is(err, exchange.ErrRateLimited) {
+		t.Errorf("expected rate limit error, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("wrong number of requests: want 3, got %d", requests)
+	}
+}
+
+func TestAwaitOrderRateLimited(t *testing.T) {
+	var posts, gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v3/exchangeInfo":
+			_, _ = w.Write([]byte(exchangeInfo))
+		case r.URL.Path == "/api/v3/ticker/price":
+			_, _ = w.Write([]byte(`{"symbol":"IGOUSDT","price":"10.00"}`))
+		case r.URL.Path == "/api/v3/order" && r.Method == http.MethodPost:
+			posts++
+			// The weight limit is reached after the order is sent
+			w.Header().Set("X-MBX-USED-WEIGHT-1M", "1100")
+			_, _ = w.Write([]byte(`{"symbol":"IGOUSDT","orderId":5}`))
+		case r.URL.Path == "/api/v3/order":
+			gets++
+			_, _ = w.Write([]byte(`{"symbol":"IGOUSDT","orderId":5,"status":"FILLED","executedQty":"2","cummulativeQuoteQty":"20.5"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Date(2021, 5, 3, 10, 0, 10, 0, time.UTC)
+	l := newLimiter(func() time.Time { return now })
+	var slept time.Duration
+	wait := func(ctx context.Context, d time.Duration) error {
+		slept += d
+		now = now.Add(d)
+		return nil
+	}
+	transport := newLimitTransport(nil, l)
+	transport.sleep = wait
+	cli := binance.NewClient("key", "secret")
+	cli.BaseURL = srv.URL
+	cli.HTTPClient = &http.Client{Transport: transport}
+	ex := &binanceExchange{
+		client:  cli,
+		filters: newFilterCache(cli, l.setLimits, log.Println, filterRefresh),
+		limiter: l,
+		log:     log.Println,
+		sleep:   wait,
+	}
+
+	// The status poll waits for the next window instead of failing the sell
+	quoteQty, err := ex.Sell(context.Background(), "IGOUSDT", decimal.NewFromInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decimal.NewFromFloat(20.5); !quoteQty.Equal(want) {
+		t.Errorf("wrong quote quantity: want %s, got %s", want, quoteQty)
+	}
+	if posts != 1 || gets != 1 {
+		t.Errorf("wrong requests: want 1 order and 1 poll, got %d and %d", posts, gets)
+	}
+	if slept != 50*time.Second {
+		t.Errorf("wrong sleep: want 50s, got %s", slept)
+	}
+}
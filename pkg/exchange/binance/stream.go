@@ -0,0 +1,321 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+// Listen keys expire after 60 minutes without keepalive
+const keepaliveWait = 30 * time.Minute
+
+// Events contain keys that only differ in case, all of them must be declared
+// because json decoding is case insensitive
+
+type bookTickerEvent struct {
+	Symbol   string `json:"s"`
+	BidPrice string `json:"b"`
+	BidQty   string `json:"B"`
+	AskPrice string `json:"a"`
+	AskQty   string `json:"A"`
+}
+
+type executionReportEvent struct {
+	Event         string `json:"e"`
+	EventTime     int64  `json:"E"`
+	Symbol        string `json:"s"`
+	Side          string `json:"S"`
+	OrderID       int64  `json:"i"`
+	Ignore        int64  `json:"I"`
+	ExecutionType string `json:"x"`
+	Status        string `json:"X"`
+	Quantity      string `json:"z"`
+	QuoteQuantity string `json:"Z"`
+	Fee           string `json:"n"`
+	FeeAsset      string `json:"N"`
+}
+
+// SubscribePrice streams the best bid price of a symbol, which is the price
+// that would be obtained selling.
+// Only the latest price is kept if the receiver is slower than the stream.
+func (e *binanceExchange) SubscribePrice(ctx context.Context, symbol string) (<-chan decimal.Decimal, error) {
+	conn, err := e.dial(ctx, fmt.Sprintf("%s@bookTicker", strings.ToLower(symbol)))
+	if err != nil {
+		return nil, fmt.Errorf("binance: couldn't subscribe to %s price: %w", symbol, err)
+	}
+	prices := make(chan decimal.Decimal, 1)
+	go func() {
+		defer close(prices)
+		e.read(ctx, conn, func(msg []byte) {
+			var ev bookTickerEvent
+			if err := json.Unmarshal(msg, &ev); err != nil {
+				e.log(fmt.Errorf("binance: couldn't decode book ticker: %w", err))
+				return
+			}
+			price, err := decimal.NewFromString(ev.BidPrice)
+			if err != nil {
+				e.log(fmt.Errorf("binance: couldn't parse price: %s: %w", ev.BidPrice, err))
+				return
+			}
+			// Replace the previous price if it hasn't been read
+			select {
+			case <-prices:
+			default:
+			}
+			prices <- price
+		})
+	}()
+	return prices, nil
+}
+
+// orderStream is the user data stream shared by order subscribers, it is
+// started with the first subscriber and closed when the last one leaves
+type orderStream struct {
+	lock   sync.Mutex
+	subs   map[*orderSubscriber]struct{}
+	cancel context.CancelFunc
+	// closing is closed once the listen key of the last stream is closed,
+	// binance returns the same key to a new stream so it must be waited
+	closing chan struct{}
+}
+
+// orderKey identifies an order, order ids are only unique by symbol
+type orderKey struct {
+	symbol  string
+	orderID string
+}
+
+type orderSubscriber struct {
+	symbol  string
+	updates chan exchange.OrderUpdate
+	done    chan struct{}
+}
+
+// SubscribeOrders streams execution reports of the symbol orders using the
+// user data stream
+func (e *binanceExchange) SubscribeOrders(ctx context.Context, symbol string) (<-chan exchange.OrderUpdate, error) {
+	s := &e.orders
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.cancel == nil && s.closing != nil {
+		closing := s.closing
+		s.lock.Unlock()
+		select {
+		case <-closing:
+		case <-ctx.Done():
+			s.lock.Lock()
+			return nil, ctx.Err()
+		}
+		s.lock.Lock()
+		if s.closing == closing {
+			s.closing = nil
+		}
+	}
+	if s.cancel == nil {
+		if err := e.startOrderStream(ctx); err != nil {
+			return nil, err
+		}
+	}
+	sub := &orderSubscriber{
+		symbol:  symbol,
+		updates: make(chan exchange.OrderUpdate, 100),
+		done:    make(chan struct{}),
+	}
+	s.subs[sub] = struct{}{}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+			return
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.remove(sub)
+	}()
+	return sub.updates, nil
+}
+
+// startOrderStream starts the user data stream, the order stream lock must
+// be held
+func (e *binanceExchange) startOrderStream(ctx context.Context) error {
+	s := &e.orders
+	listenKey, err := e.client.NewStartUserStreamService().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("binance: couldn't start user stream: %w", err)
+	}
+	conn, err := e.dial(ctx, listenKey)
+	if err != nil {
+		return fmt.Errorf("binance: couldn't subscribe to user stream: %w", err)
+	}
+	// The stream isn't bound to the context of the first subscriber
+	ctx, cancel := context.WithCancel(context.Background())
+	closing := make(chan struct{})
+	s.cancel = cancel
+	s.closing = closing
+	if s.subs == nil {
+		s.subs = make(map[*orderSubscriber]struct{})
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(keepaliveWait):
+			}
+			if err := e.client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(ctx); err != nil {
+				e.log(fmt.Errorf("binance: couldn't keepalive user stream: %w", err))
+			}
+		}
+	}()
+	go func() {
+		defer close(closing)
+		defer cancel()
+		// Fees are only used by this reader, a new stream starts its own
+		fees := make(map[orderKey]decimal.Decimal)
+		e.read(ctx, conn, func(msg []byte) {
+			u, ok := e.orderUpdate(msg)
+			if !ok {
+				return
+			}
+			accumulate(fees, &u)
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			s.dispatch(u)
+		})
+		// Subscribers of a dropped stream are closed, otherwise all of them
+		// have already left
+		if ctx.Err() == nil {
+			s.lock.Lock()
+			for sub := range s.subs {
+				s.remove(sub)
+			}
+			s.lock.Unlock()
+		}
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer closeCancel()
+		if err := e.client.NewCloseUserStreamService().ListenKey(listenKey).Do(closeCtx); err != nil {
+			e.log(fmt.Errorf("binance: couldn't close user stream: %w", err))
+		}
+	}()
+	return nil
+}
+
+// orderUpdate parses an execution report, other events are ignored
+func (e *binanceExchange) orderUpdate(msg []byte) (exchange.OrderUpdate, bool) {
+	var ev executionReportEvent
+	if err := json.Unmarshal(msg, &ev); err != nil {
+		e.log(fmt.Errorf("binance: couldn't decode user stream event: %w", err))
+		return exchange.OrderUpdate{}, false
+	}
+	if ev.Event != "executionReport" {
+		return exchange.OrderUpdate{}, false
+	}
+	if e.debug {
+		e.log("execution_report", string(msg))
+	}
+	u := exchange.OrderUpdate{
+		Symbol:   ev.Symbol,
+		OrderID:  strconv.Itoa(int(ev.OrderID)),
+		Status:   exchange.OrderStatus(ev.Status),
+		FeeAsset: ev.FeeAsset,
+	}
+	var err error
+	if u.Quantity, err = decimal.NewFromString(ev.Quantity); err != nil {
+		e.log(fmt.Errorf("binance: couldn't parse quantity: %s: %w", ev.Quantity, err))
+		return exchange.OrderUpdate{}, false
+	}
+	if u.QuoteQuantity, err = decimal.NewFromString(ev.QuoteQuantity); err != nil {
+		e.log(fmt.Errorf("binance: couldn't parse quote quantity: %s: %w", ev.QuoteQuantity, err))
+		return exchange.OrderUpdate{}, false
+	}
+	if ev.Fee != "" {
+		if u.Fee, err = decimal.NewFromString(ev.Fee); err != nil {
+			e.log(fmt.Errorf("binance: couldn't parse fee: %s: %w", ev.Fee, err))
+			return exchange.OrderUpdate{}, false
+		}
+	}
+	return u, true
+}
+
+// accumulate replaces the fee of the last execution with the fee of all the
+// executions of the order, fees holds the commissions of open orders
+func accumulate(fees map[orderKey]decimal.Decimal, u *exchange.OrderUpdate) {
+	key := orderKey{symbol: u.Symbol, orderID: u.OrderID}
+	u.Fee = fees[key].Add(u.Fee)
+	switch u.Status {
+	case exchange.OrderNew, exchange.OrderPartiallyFilled:
+		fees[key] = u.Fee
+	default:
+		delete(fees, key)
+	}
+}
+
+// dispatch sends the update to the subscribers of its symbol without
+// blocking, subscribers with a full buffer are removed so they fall back to
+// polling instead of delaying the others
+func (s *orderStream) dispatch(u exchange.OrderUpdate) {
+	for sub := range s.subs {
+		if sub.symbol != u.Symbol {
+			continue
+		}
+		select {
+		case sub.updates <- u:
+		default:
+			s.remove(sub)
+		}
+	}
+}
+
+// remove closes the subscriber and stops the stream if it was the last one
+func (s *orderStream) remove(sub *orderSubscriber) {
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+	delete(s.subs, sub)
+	close(sub.updates)
+	close(sub.done)
+	if len(s.subs) == 0 && s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+func (e *binanceExchange) dial(ctx context.Context, stream string) (*websocket.Conn, error) {
+	conn, _, err := e.dialer.DialContext(ctx, fmt.Sprintf("%s/%s", e.wsURL, stream), nil)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// read calls the handler for each message until the context is canceled or
+// the connection fails
+func (e *binanceExchange) read(ctx context.Context, conn *websocket.Conn, handler func([]byte)) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				e.log(fmt.Errorf("binance: stream closed: %w", err))
+			}
+			return
+		}
+		handler(msg)
+	}
+}
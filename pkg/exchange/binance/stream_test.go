@@ -0,0 +1,266 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gorilla/websocket"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+func TestSubscribePrice(t *testing.T) {
+	ex := newStubExchange(t, map[string][]string{
+		"/ws/igousdt@bookTicker": {
+			`{"u":1,"s":"IGOUSDT","b":"10.1","B":"1","a":"10.2","A":"1"}`,
+			`{"u":2,"s":"IGOUSDT","b":"10.3","B":"1","a":"10.4","A":"1"}`,
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	prices, err := ex.SubscribePrice(ctx, "IGOUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last decimal.Decimal
+	for p := range prices {
+		last = p
+	}
+	if want := decimal.NewFromFloat(10.3); !last.Equal(want) {
+		t.Errorf("wrong price: want %s, got %s", want, last)
+	}
+}
+
+func TestSubscribeOrders(t *testing.T) {
+	ex := newStubExchange(t, map[string][]string{
+		"/ws/listenkey": {
+			`{"e":"outboundAccountPosition","E":1}`,
+			`{"e":"executionReport","E":1,"s":"IGOUSDT","S":"SELL","x":"NEW","X":"NEW","i":123,"z":"0","I":1,"Z":"0"}`,
+			`{"e":"executionReport","E":2,"s":"IGOUSDT","S":"SELL","x":"TRADE","X":"PARTIALLY_FILLED","i":123,"z":"4","I":2,"Z":"48.2","n":"0.0482","N":"USDT"}`,
+			`{"e":"executionReport","E":3,"s":"IGOUSDT","S":"SELL","x":"TRADE","X":"FILLED","i":123,"z":"10","I":3,"Z":"120.5","n":"0.0723","N":"USDT"}`,
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updates, err := ex.SubscribeOrders(ctx, "IGOUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []exchange.OrderUpdate
+	for u := range updates {
+		got = append(got, u)
+	}
+	if len(got) != 3 {
+		t.Fatalf("wrong number of updates: want 3, got %d", len(got))
+	}
+	// Fees of the executions are accumulated like the quantities
+	if want := decimal.NewFromFloat(0.0482); !got[1].Fee.Equal(want) {
+		t.Errorf("wrong partial fee: want %s, got %s", want, got[1].Fee)
+	}
+	u := got[2]
+	if u.OrderID != "123" || u.Status != exchange.OrderFilled {
+		t.Errorf("wrong update: %+v", u)
+	}
+	if want := decimal.NewFromFloat(120.5); !u.QuoteQuantity.Equal(want) {
+		t.Errorf("wrong quote quantity: want %s, got %s", want, u.QuoteQuantity)
+	}
+	if want := decimal.NewFromFloat(0.1205); !u.Fee.Equal(want) || u.FeeAsset != "USDT" {
+		t.Errorf("wrong fee: want %s USDT, got %s %s", want, u.Fee, u.FeeAsset)
+	}
+}
+
+func TestSubscribeOrdersShared(t *testing.T) {
+	var lock sync.Mutex
+	var starts, closes int
+	send := make(chan string)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/userDataStream" {
+			lock.Lock()
+			defer lock.Unlock()
+			if r.Method == http.MethodDelete {
+				closes++
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			starts++
+			_, _ = w.Write([]byte(`{"listenKey":"listenkey"}`))
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			select {
+			case msg := <-send:
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+					t.Error(err)
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+	cli := binance.NewClient("key", "secret")
+	cli.BaseURL = srv.URL
+	ex := &binanceExchange{
+		client: cli,
+		wsURL:  "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws",
+		dialer: websocket.DefaultDialer,
+		log:    log.Println,
+	}
+	count := func(n *int) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return *n
+	}
+	receive := func(updates <-chan exchange.OrderUpdate, symbol string) {
+		t.Helper()
+		select {
+		case u := <-updates:
+			if u.Symbol != symbol {
+				t.Errorf("wrong symbol: want %s, got %s", symbol, u.Symbol)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s update not received", symbol)
+		}
+	}
+	closed := func(updates <-chan exchange.OrderUpdate) {
+		t.Helper()
+		select {
+		case _, ok := <-updates:
+			if ok {
+				t.Error("unexpected update")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("updates not closed")
+		}
+	}
+	report := func(symbol string) string {
+		return fmt.Sprintf(`{"e":"executionReport","E":1,"s":"%s","S":"SELL","x":"NEW","X":"NEW","i":1,"z":"0","I":1,"Z":"0"}`, symbol)
+	}
+
+	// Subscribers share a single user stream
+	var cancels []context.CancelFunc
+	subscribe := func(symbol string) <-chan exchange.OrderUpdate {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+		updates, err := ex.SubscribeOrders(ctx, symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return updates
+	}
+	igo1 := subscribe("IGOUSDT")
+	zek := subscribe("ZEKUSDT")
+	igo2 := subscribe("IGOUSDT")
+	if n := count(&starts); n != 1 {
+		t.Errorf("wrong number of user streams: want 1, got %d", n)
+	}
+
+	// Updates are sent to the subscribers of the symbol
+	send <- report("IGOUSDT")
+	send <- report("ZEKUSDT")
+	receive(igo1, "IGOUSDT")
+	receive(igo2, "IGOUSDT")
+	receive(zek, "ZEKUSDT")
+
+	// The stream is kept while there are subscribers
+	cancels[0]()
+	closed(igo1)
+	send <- report("IGOUSDT")
+	receive(igo2, "IGOUSDT")
+
+	// The stream is closed when the last subscriber leaves
+	cancels[1]()
+	cancels[2]()
+	closed(zek)
+	closed(igo2)
+
+	// The listen key of the old stream is closed before a new one starts
+	igo3 := subscribe("IGOUSDT")
+	if n := count(&closes); n != 1 {
+		t.Errorf("wrong number of closed user streams: want 1, got %d", n)
+	}
+	if n := count(&starts); n != 2 {
+		t.Errorf("wrong number of user streams: want 2, got %d", n)
+	}
+
+	// Slow subscribers are removed instead of blocking the others
+	slow := subscribe("IGOUSDT")
+	for i := 0; i < cap(slow)+1; i++ {
+		send <- report("IGOUSDT")
+		receive(igo3, "IGOUSDT")
+	}
+	for i := 0; i < cap(slow); i++ {
+		receive(slow, "IGOUSDT")
+	}
+	closed(slow)
+	cancels[3]()
+	closed(igo3)
+}
+
+// newStubExchange creates an exchange connected to a local server that
+// sends the given messages on each websocket path and then closes the
+// connection
+func newStubExchange(t *testing.T, streams map[string][]string) *binanceExchange {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/userDataStream" {
+			_, _ = w.Write([]byte(`{"listenKey":"listenkey"}`))
+			return
+		}
+		msgs, ok := streams[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for _, msg := range msgs {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	t.Cleanup(srv.Close)
+
+	cli := binance.NewClient("key", "secret")
+	cli.BaseURL = srv.URL
+	return &binanceExchange{
+		client: cli,
+		wsURL:  "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws",
+		dialer: websocket.DefaultDialer,
+		log:    log.Println,
+	}
+}
@@ -3,6 +3,8 @@ package exchange
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -19,3 +21,99 @@ type Exchange interface {
 }
 
 var ErrOrderCanceled = errors.New("order canceled")
+
+var (
+	// ErrInvalidOrder is returned if an order doesn't pass the trading rules
+	// of the symbol
+	ErrInvalidOrder = errors.New("invalid order")
+	// ErrDust is returned if a quantity is too small to be traded
+	ErrDust = errors.New("quantity too small to be traded")
+)
+
+// FilterError is returned if an order fails a symbol filter, it matches
+// ErrInvalidOrder and ErrDust if the value is below the minimum quantity or
+// notional
+type FilterError struct {
+	Symbol string
+	Filter string
+	Value  decimal.Decimal
+	Limit  decimal.Decimal
+	Dust   bool
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s %s filter failed: %s (limit %s)", e.Symbol, e.Filter, e.Value, e.Limit)
+}
+
+func (e *FilterError) Is(target error) bool {
+	return target == ErrInvalidOrder || (e.Dust && target == ErrDust)
+}
+
+// ErrRateLimited is returned if requests are rejected because of the rate
+// limits of the exchange
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError is returned if requests are rate limited, no requests
+// should be sent until RetryAfter has passed
+type RateLimitError struct {
+	RetryAfter time.Duration
+	// Status is the http status code if the exchange rejected the request
+	Status int
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("rate limited (%d), retry after %s", e.Status, e.RetryAfter)
+	}
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Streamer is implemented by exchanges that can push price and order updates.
+// Channels are closed when the context is canceled or the stream drops.
+// Order updates are only sent for orders of the symbol.
+type Streamer interface {
+	SubscribePrice(ctx context.Context, symbol string) (<-chan decimal.Decimal, error)
+	SubscribeOrders(ctx context.Context, symbol string) (<-chan OrderUpdate, error)
+}
+
+type OrderStatus string
+
+const (
+	OrderNew             OrderStatus = "NEW"
+	OrderPartiallyFilled OrderStatus = "PARTIALLY_FILLED"
+	OrderFilled          OrderStatus = "FILLED"
+	OrderCanceled        OrderStatus = "CANCELED"
+)
+
+// OrderUpdate is an order execution report
+type OrderUpdate struct {
+	Symbol  string
+	OrderID string
+	Status  OrderStatus
+	// Quantity, QuoteQuantity and Fee are the totals of all the executions
+	// of the order
+	Quantity      decimal.Decimal
+	QuoteQuantity decimal.Decimal
+	Fee           decimal.Decimal
+	FeeAsset      string
+}
+
+// Candler is implemented by exchanges that provide historical candles.
+// Interval uses binance format, e.g. `1m`, `1h` or `1d`.
+type Candler interface {
+	Candles(ctx context.Context, symbol string, interval string, limit int) ([]Candle, error)
+}
+
+// Candle is an OHLC kline
+type Candle struct {
+	OpenTime  time.Time
+	CloseTime time.Time
+	Open      decimal.Decimal
+	High      decimal.Decimal
+	Low       decimal.Decimal
+	Close     decimal.Decimal
+}
@@ -0,0 +1,320 @@
+package paper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+var zero = decimal.Decimal{}
+
+type orderStatus string
+
+const (
+	orderNew      orderStatus = "NEW"
+	orderFilled   orderStatus = "FILLED"
+	orderCanceled orderStatus = "CANCELED"
+)
+
+type order struct {
+	Status   orderStatus     `json:"status"`
+	QuoteQty decimal.Decimal `json:"quote_qty"`
+	List     string          `json:"list"`
+}
+
+type orderList struct {
+	Symbol   string          `json:"symbol"`
+	Quantity decimal.Decimal `json:"quantity"`
+	Target   decimal.Decimal `json:"target"`
+	Stop     decimal.Decimal `json:"stop"`
+	// Orders contains stop loss and limit maker order ids
+	Orders [2]string `json:"orders"`
+}
+
+// state contains the ledger and orders of the exchange
+type state struct {
+	Balances map[string]decimal.Decimal `json:"balances"`
+	Locked   map[string]decimal.Decimal `json:"locked"`
+	Symbols  map[string][2]string       `json:"symbols"`
+	Orders   map[string]*order          `json:"orders"`
+	Lists    map[string]*orderList      `json:"lists"`
+	LastID   int                        `json:"last_id"`
+}
+
+type paperExchange struct {
+	state
+	log      func(v ...interface{})
+	prices   PriceSource
+	path     string
+	makerFee decimal.Decimal
+	takerFee decimal.Decimal
+	lock     sync.Mutex
+}
+
+// New returns a simulated exchange that keeps a per asset ledger.
+// Orders are filled using prices from the given source.
+// Maker fee is applied to the limit leg of OCO orders and taker fee to the
+// rest of orders. Fees are ratios, e.g. 0.001 for 0.1%.
+// If path isn't empty, state is persisted to that file and initial balances
+// are only used if the file doesn't exist.
+func New(log func(v ...interface{}), prices PriceSource, path string, balances map[string]decimal.Decimal, makerFee, takerFee decimal.Decimal) (exchange.Exchange, error) {
+	e := &paperExchange{
+		state: state{
+			Balances: make(map[string]decimal.Decimal),
+			Locked:   make(map[string]decimal.Decimal),
+			Symbols:  make(map[string][2]string),
+			Orders:   make(map[string]*order),
+			Lists:    make(map[string]*orderList),
+		},
+		log:      log,
+		prices:   prices,
+		path:     path,
+		makerFee: makerFee,
+		takerFee: takerFee,
+	}
+	for k, v := range balances {
+		e.Balances[k] = v
+	}
+	if path == "" {
+		return e, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return e, e.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("paper: couldn't read state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &e.state); err != nil {
+		return nil, fmt.Errorf("paper: couldn't decode state %s: %w", path, err)
+	}
+	return e, nil
+}
+
+func (e *paperExchange) Symbol(base, quote string) string {
+	symbol := fmt.Sprintf("%s%s", base, quote)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, ok := e.Symbols[symbol]; !ok {
+		e.Symbols[symbol] = [2]string{base, quote}
+	}
+	return symbol
+}
+
+func (e *paperExchange) Buy(ctx context.Context, symbol string, quoteQuantity, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	currentPrice, err := e.prices.Price(ctx, symbol)
+	if err != nil {
+		return zero, zero, err
+	}
+	if currentPrice.LessThan(price.Mul(decimal.NewFromFloat(0.95))) {
+		return zero, zero, fmt.Errorf("paper: current price is lower than minimum price: %s %s", currentPrice, price)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	base, quote, err := e.assets(symbol)
+	if err != nil {
+		return zero, zero, err
+	}
+	if e.Balances[quote].LessThan(quoteQuantity) {
+		return zero, zero, fmt.Errorf("paper: insufficient %s balance: %s < %s", quote, e.Balances[quote], quoteQuantity)
+	}
+	qty := quoteQuantity.Div(currentPrice)
+	qty = qty.Sub(qty.Mul(e.takerFee))
+	e.Balances[quote] = e.Balances[quote].Sub(quoteQuantity)
+	e.Balances[base] = e.Balances[base].Add(qty)
+	e.persist()
+	return quoteQuantity, qty, nil
+}
+
+func (e *paperExchange) Sell(ctx context.Context, symbol string, quantity decimal.Decimal) (decimal.Decimal, error) {
+	price, err := e.prices.Price(ctx, symbol)
+	if err != nil {
+		return zero, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	base, quote, err := e.assets(symbol)
+	if err != nil {
+		return zero, err
+	}
+	if e.Balances[base].LessThan(quantity) {
+		return zero, fmt.Errorf("paper: insufficient %s balance: %s < %s", base, e.Balances[base], quantity)
+	}
+	quoteQty := quantity.Mul(price)
+	quoteQty = quoteQty.Sub(quoteQty.Mul(e.takerFee))
+	e.Balances[base] = e.Balances[base].Sub(quantity)
+	e.Balances[quote] = e.Balances[quote].Add(quoteQty)
+	e.persist()
+	return quoteQty, nil
+}
+
+func (e *paperExchange) CreateStopLimit(ctx context.Context, symbol string, quantity, target, stop decimal.Decimal) (string, []string, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	base, _, err := e.assets(symbol)
+	if err != nil {
+		return "", nil, err
+	}
+	if e.Balances[base].LessThan(quantity) {
+		return "", nil, fmt.Errorf("paper: insufficient %s balance: %s < %s", base, e.Balances[base], quantity)
+	}
+	e.Balances[base] = e.Balances[base].Sub(quantity)
+	e.Locked[base] = e.Locked[base].Add(quantity)
+
+	l := &orderList{
+		Symbol:   symbol,
+		Quantity: quantity,
+		Target:   target,
+		Stop:     stop,
+	}
+	listID := e.nextID()
+	for i := range l.Orders {
+		id := e.nextID()
+		e.Orders[id] = &order{Status: orderNew, List: listID}
+		l.Orders[i] = id
+	}
+	e.Lists[listID] = l
+	e.persist()
+	return listID, l.Orders[:], nil
+}
+
+func (e *paperExchange) CancelStopLimit(ctx context.Context, symbol string, id string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	l, ok := e.Lists[id]
+	if !ok {
+		return fmt.Errorf("paper: order list %s not found", id)
+	}
+	for _, orderID := range l.Orders {
+		if e.Orders[orderID].Status != orderNew {
+			return fmt.Errorf("paper: order list %s is not active", id)
+		}
+	}
+	base, _, err := e.assets(l.Symbol)
+	if err != nil {
+		return err
+	}
+	for _, orderID := range l.Orders {
+		e.Orders[orderID].Status = orderCanceled
+	}
+	e.Locked[base] = e.Locked[base].Sub(l.Quantity)
+	e.Balances[base] = e.Balances[base].Add(l.Quantity)
+	e.persist()
+	return nil
+}
+
+func (e *paperExchange) Status(ctx context.Context, symbol string, id string) (bool, decimal.Decimal, error) {
+	price, err := e.prices.Price(ctx, symbol)
+	if err != nil {
+		return false, zero, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	o, ok := e.Orders[id]
+	if !ok {
+		return false, zero, fmt.Errorf("paper: order %s not found", id)
+	}
+	if err := e.fill(e.Lists[o.List], price); err != nil {
+		return false, zero, err
+	}
+	switch o.Status {
+	case orderFilled:
+		return true, o.QuoteQty, nil
+	case orderCanceled:
+		return false, zero, fmt.Errorf("paper: %w", exchange.ErrOrderCanceled)
+	default:
+		return false, zero, nil
+	}
+}
+
+func (e *paperExchange) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	return e.prices.Price(ctx, symbol)
+}
+
+func (e *paperExchange) Balance(ctx context.Context, currency string) (decimal.Decimal, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.Balances[currency], nil
+}
+
+// fill fills the order list if the price has reached the target or the stop.
+// The limit leg is filled at target price and the stop leg at current price.
+func (e *paperExchange) fill(l *orderList, price decimal.Decimal) error {
+	stopOrder, limitOrder := e.Orders[l.Orders[0]], e.Orders[l.Orders[1]]
+	if stopOrder.Status != orderNew || limitOrder.Status != orderNew {
+		return nil
+	}
+	var filled, canceled *order
+	var fee decimal.Decimal
+	switch {
+	case price.LessThanOrEqual(l.Stop):
+		filled, canceled = stopOrder, limitOrder
+		fee = e.takerFee
+	case price.GreaterThanOrEqual(l.Target):
+		filled, canceled = limitOrder, stopOrder
+		price = l.Target
+		fee = e.makerFee
+	default:
+		return nil
+	}
+	base, quote, err := e.assets(l.Symbol)
+	if err != nil {
+		return err
+	}
+	quoteQty := l.Quantity.Mul(price)
+	quoteQty = quoteQty.Sub(quoteQty.Mul(fee))
+	filled.Status = orderFilled
+	filled.QuoteQty = quoteQty
+	canceled.Status = orderCanceled
+	e.Locked[base] = e.Locked[base].Sub(l.Quantity)
+	e.Balances[quote] = e.Balances[quote].Add(quoteQty)
+	e.persist()
+	return nil
+}
+
+func (e *paperExchange) assets(symbol string) (string, string, error) {
+	assets, ok := e.Symbols[symbol]
+	if !ok {
+		return "", "", fmt.Errorf("paper: unknown symbol %s", symbol)
+	}
+	return assets[0], assets[1], nil
+}
+
+func (e *paperExchange) nextID() string {
+	e.LastID++
+	return strconv.Itoa(e.LastID)
+}
+
+// persist saves the state logging errors, orders are already filled in the
+// ledger so they must not fail
+func (e *paperExchange) persist() {
+	if err := e.save(); err != nil {
+		e.log(err)
+	}
+}
+
+// save persists the state if a path has been provided
+func (e *paperExchange) save() error {
+	if e.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(e.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("paper: couldn't encode state: %w", err)
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("paper: couldn't write state %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		return fmt.Errorf("paper: couldn't write state %s: %w", e.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package paper
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+func TestTargetFilled(t *testing.T) {
+	ctx := context.Background()
+	prices := NewStaticPrices(map[string]decimal.Decimal{"IGOUSDT": decimal.NewFromInt(10)})
+	path := filepath.Join(t.TempDir(), "paper.json")
+	ex, err := New(log.Println, prices, path, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(100)}, decimal.NewFromFloat(0.001), decimal.NewFromFloat(0.002))
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbol := ex.Symbol("IGO", "USDT")
+
+	// Insufficient funds
+	if _, _, err := ex.Buy(ctx, symbol, decimal.NewFromInt(200), decimal.NewFromInt(10)); err == nil {
+		t.Fatal("buy with insufficient funds should fail")
+	}
+
+	quoteQty, qty, err := ex.Buy(ctx, symbol, decimal.NewFromInt(50), decimal.NewFromInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "buy quote quantity", quoteQty, decimal.NewFromInt(50))
+	assertEqual(t, "buy quantity", qty, decimal.NewFromFloat(4.99))
+	assertBalance(t, ex, "USDT", decimal.NewFromInt(50))
+	assertBalance(t, ex, "IGO", decimal.NewFromFloat(4.99))
+
+	listID, ids, err := ex.CreateStopLimit(ctx, symbol, qty, decimal.NewFromInt(12), decimal.NewFromInt(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, ex, "IGO", decimal.Zero)
+	if _, err := ex.Sell(ctx, symbol, qty); err == nil {
+		t.Fatal("sell of locked quantity should fail")
+	}
+
+	// State survives restarts
+	ex, err = New(log.Println, prices, path, nil, decimal.NewFromFloat(0.001), decimal.NewFromFloat(0.002))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, ex, "USDT", decimal.NewFromInt(50))
+
+	prices.Set(symbol, decimal.NewFromInt(13))
+	ok, endQuoteQty, err := ex.Status(ctx, symbol, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("limit order should be filled")
+	}
+	// 4.99 * 12 with 0.1% maker fee
+	assertEqual(t, "end quote quantity", endQuoteQty, decimal.NewFromFloat(59.82012))
+	assertBalance(t, ex, "USDT", decimal.NewFromFloat(109.82012))
+	if _, _, err := ex.Status(ctx, symbol, ids[0]); !errors.Is(err, exchange.ErrOrderCanceled) {
+		t.Errorf("stop order should be canceled: %v", err)
+	}
+	if err := ex.CancelStopLimit(ctx, symbol, listID); err == nil {
+		t.Error("filled order list shouldn't be canceled")
+	}
+}
+
+func TestCancelAndSell(t *testing.T) {
+	ctx := context.Background()
+	prices := NewStaticPrices(map[string]decimal.Decimal{"IGOUSDT": decimal.NewFromInt(10)})
+	ex, err := New(log.Println, prices, "", map[string]decimal.Decimal{"USDT": decimal.NewFromInt(100)}, decimal.Zero, decimal.NewFromFloat(0.01))
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbol := ex.Symbol("IGO", "USDT")
+	_, qty, err := ex.Buy(ctx, symbol, decimal.NewFromInt(100), decimal.NewFromInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listID, _, err := ex.CreateStopLimit(ctx, symbol, qty, decimal.NewFromInt(12), decimal.NewFromInt(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ex.CancelStopLimit(ctx, symbol, listID); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, ex, "IGO", decimal.NewFromFloat(9.9))
+
+	prices.Set(symbol, decimal.NewFromInt(8))
+	quoteQty, err := ex.Sell(ctx, symbol, qty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9.9 * 8 with 1% taker fee
+	assertEqual(t, "sell quote quantity", quoteQty, decimal.NewFromFloat(78.408))
+	assertBalance(t, ex, "IGO", decimal.Zero)
+	assertBalance(t, ex, "USDT", decimal.NewFromFloat(78.408))
+}
+
+func assertBalance(t *testing.T, ex exchange.Exchange, currency string, want decimal.Decimal) {
+	t.Helper()
+	got, err := ex.Balance(context.Background(), currency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, currency+" balance", got, want)
+}
+
+func assertEqual(t *testing.T, name string, got, want decimal.Decimal) {
+	t.Helper()
+	if !got.Equal(want) {
+		t.Errorf("wrong %s: want %s, got %s", name, want, got)
+	}
+}
@@ -0,0 +1,74 @@
+package paper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// PriceSource provides current prices.
+// Any exchange.Exchange can be used as a live price source.
+type PriceSource interface {
+	Price(ctx context.Context, symbol string) (decimal.Decimal, error)
+}
+
+// StaticPrices is a price source with fixed prices that can be changed
+type StaticPrices struct {
+	prices map[string]decimal.Decimal
+	lock   sync.Mutex
+}
+
+func NewStaticPrices(prices map[string]decimal.Decimal) *StaticPrices {
+	p := &StaticPrices{prices: make(map[string]decimal.Decimal)}
+	for k, v := range prices {
+		p.prices[k] = v
+	}
+	return p
+}
+
+func (p *StaticPrices) Set(symbol string, price decimal.Decimal) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.prices[symbol] = price
+}
+
+func (p *StaticPrices) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	price, ok := p.prices[symbol]
+	if !ok {
+		return zero, fmt.Errorf("paper: price for %s not found", symbol)
+	}
+	return price, nil
+}
+
+type filePrices struct {
+	path string
+}
+
+// NewFilePrices returns a price source that reads prices from a JSON file
+// with symbols as keys and prices as values, e.g. `{"BTCUSDT": "45000.5"}`.
+// The file is read on every call, so prices can be edited while running.
+func NewFilePrices(path string) PriceSource {
+	return &filePrices{path: path}
+}
+
+func (p *filePrices) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return zero, fmt.Errorf("paper: couldn't read prices file %s: %w", p.path, err)
+	}
+	var prices map[string]decimal.Decimal
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return zero, fmt.Errorf("paper: couldn't decode prices file %s: %w", p.path, err)
+	}
+	price, ok := prices[symbol]
+	if !ok {
+		return zero, fmt.Errorf("paper: price for %s not found in %s", symbol, p.path)
+	}
+	return price, nil
+}
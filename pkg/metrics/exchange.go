@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+// Exchange returns an exchange that records the latency and errors of the
+// api calls and counts the orders it places.
+// Optional exchange interfaces implemented by the exchange are kept.
+func (m *Metrics) Exchange(ex exchange.Exchange) exchange.Exchange {
+	o := &observedExchange{Exchange: ex, metrics: m}
+	streamer, isStreamer := ex.(exchange.Streamer)
+	candler, isCandler := ex.(exchange.Candler)
+	switch {
+	case isStreamer && isCandler:
+		return &struct {
+			*observedExchange
+			exchange.Streamer
+			*observedCandler
+		}{o, streamer, &observedCandler{candler: candler, metrics: m}}
+	case isStreamer:
+		return &struct {
+			*observedExchange
+			exchange.Streamer
+		}{o, streamer}
+	case isCandler:
+		return &struct {
+			*observedExchange
+			*observedCandler
+		}{o, &observedCandler{candler: candler, metrics: m}}
+	default:
+		return o
+	}
+}
+
+type observedExchange struct {
+	exchange.Exchange
+	metrics *Metrics
+}
+
+func (o *observedExchange) Buy(ctx context.Context, symbol string, quoteQuantity, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	start := time.Now()
+	quoteQty, qty, err := o.Exchange.Buy(ctx, symbol, quoteQuantity, price)
+	o.metrics.observeCall("buy", start, err)
+	o.metrics.observeOrder("buy", err)
+	return quoteQty, qty, err
+}
+
+func (o *observedExchange) Sell(ctx context.Context, symbol string, quantity decimal.Decimal) (decimal.Decimal, error) {
+	start := time.Now()
+	quoteQty, err := o.Exchange.Sell(ctx, symbol, quantity)
+	o.metrics.observeCall("sell", start, err)
+	o.metrics.observeOrder("sell", err)
+	return quoteQty, err
+}
+
+func (o *observedExchange) CreateStopLimit(ctx context.Context, symbol string, quantity, target, stoploss decimal.Decimal) (string, []string, error) {
+	start := time.Now()
+	listID, ids, err := o.Exchange.CreateStopLimit(ctx, symbol, quantity, target, stoploss)
+	o.metrics.observeCall("create_oco", start, err)
+	o.metrics.observeOrder("oco", err)
+	return listID, ids, err
+}
+
+func (o *observedExchange) CancelStopLimit(ctx context.Context, symbol string, id string) error {
+	start := time.Now()
+	err := o.Exchange.CancelStopLimit(ctx, symbol, id)
+	o.metrics.observeCall("cancel_oco", start, err)
+	return err
+}
+
+func (o *observedExchange) Status(ctx context.Context, symbol string, id string) (bool, decimal.Decimal, error) {
+	start := time.Now()
+	ok, quoteQty, err := o.Exchange.Status(ctx, symbol, id)
+	o.metrics.observeCall("status", start, err)
+	return ok, quoteQty, err
+}
+
+func (o *observedExchange) Price(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	start := time.Now()
+	price, err := o.Exchange.Price(ctx, symbol)
+	o.metrics.observeCall("price", start, err)
+	return price, err
+}
+
+func (o *observedExchange) Balance(ctx context.Context, currency string) (decimal.Decimal, error) {
+	start := time.Now()
+	balance, err := o.Exchange.Balance(ctx, currency)
+	o.metrics.observeCall("balance", start, err)
+	return balance, err
+}
+
+type observedCandler struct {
+	candler exchange.Candler
+	metrics *Metrics
+}
+
+func (o *observedCandler) Candles(ctx context.Context, symbol string, interval string, limit int) ([]exchange.Candle, error) {
+	start := time.Now()
+	candles, err := o.candler.Candles(ctx, symbol, interval, limit)
+	o.metrics.observeCall("candles", start, err)
+	return candles, err
+}
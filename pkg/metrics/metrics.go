@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/shopspring/decimal"
+)
+
+// Metrics collects bot metrics and exposes them in prometheus text format
+type Metrics struct {
+	openTrades       *vec
+	unrealizedProfit *vec
+	balance          *vec
+	signalsReceived  *vec
+	signalsAccepted  *vec
+	signalsRejected  *vec
+	ordersPlaced     *vec
+	ordersCanceled   *vec
+	retries          *vec
+	exchangeErrors   *vec
+	exchangeDuration *vec
+	all              []*vec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		openTrades:       newVec("gauge", "zeken_open_trades", "Number of open trades."),
+		unrealizedProfit: newVec("gauge", "zeken_trade_unrealized_profit", "Unrealized profit of open trades in quote currency.", "trade", "base", "quote"),
+		balance:          newVec("gauge", "zeken_balance", "Available balance.", "asset"),
+		signalsReceived:  newVec("counter", "zeken_signals_received_total", "Signals received."),
+		signalsAccepted:  newVec("counter", "zeken_signals_accepted_total", "Signals that started a trade."),
+		signalsRejected:  newVec("counter", "zeken_signals_rejected_total", "Signals rejected by reason.", "reason"),
+		ordersPlaced:     newVec("counter", "zeken_orders_placed_total", "Orders accepted by the exchange by type.", "type"),
+		ordersCanceled:   newVec("counter", "zeken_orders_canceled_total", "Orders canceled."),
+		retries:          newVec("counter", "zeken_trade_retries_total", "Failed trade operations that are retried.", "operation"),
+		exchangeErrors:   newVec("counter", "zeken_exchange_errors_total", "Exchange api errors by endpoint.", "endpoint"),
+		exchangeDuration: newHistogram("zeken_exchange_request_duration_seconds", "Exchange api call latency by endpoint.",
+			[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "endpoint"),
+	}
+	m.all = []*vec{
+		m.openTrades, m.unrealizedProfit, m.balance,
+		m.signalsReceived, m.signalsAccepted, m.signalsRejected,
+		m.ordersPlaced, m.ordersCanceled, m.retries,
+		m.exchangeErrors, m.exchangeDuration,
+	}
+	return m
+}
+
+// Observe updates counters from bot and trade events, it is meant to be
+// subscribed to the event bus
+func (m *Metrics) Observe(e event.Event) {
+	switch e := e.(type) {
+	case *event.SignalReceived:
+		m.signalsReceived.add(1)
+	case *event.SignalRejected:
+		m.signalsRejected.add(1, e.Reason)
+	case *event.TradeStarted:
+		if !e.Resumed {
+			m.signalsAccepted.add(1)
+		}
+	case *event.OCOCanceled:
+		m.ordersCanceled.add(1)
+	case *event.ErrorRetry:
+		m.retries.add(1, e.Operation)
+	}
+}
+
+// TradeProfit is the unrealized profit of an open trade
+type TradeProfit struct {
+	Trade  string
+	Base   string
+	Quote  string
+	Profit decimal.Decimal
+}
+
+// SetTrades sets the open trades gauges
+func (m *Metrics) SetTrades(trades []TradeProfit) {
+	m.openTrades.set(float64(len(trades)))
+	m.unrealizedProfit.reset()
+	for _, t := range trades {
+		profit, _ := t.Profit.Float64()
+		m.unrealizedProfit.set(profit, t.Trade, t.Base, t.Quote)
+	}
+}
+
+// SetBalance sets the available balance of an asset
+func (m *Metrics) SetBalance(asset string, balance decimal.Decimal) {
+	v, _ := balance.Float64()
+	m.balance.set(v, asset)
+}
+
+// observeCall records the latency and result of an exchange call
+func (m *Metrics) observeCall(endpoint string, start time.Time, err error) {
+	m.exchangeDuration.observe(time.Since(start).Seconds(), endpoint)
+	if err != nil {
+		m.exchangeErrors.add(1, endpoint)
+	}
+}
+
+// observeOrder counts an order sent to the exchange if it was accepted
+func (m *Metrics) observeOrder(typ string, err error) {
+	if err == nil {
+		m.ordersPlaced.add(1, typ)
+	}
+}
+
+// Handler returns the metrics http handler, update is called before each
+// scrape to refresh gauges and may be nil
+func (m *Metrics) Handler(update func(ctx context.Context)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if update != nil {
+			update(r.Context())
+		}
+		var buf bytes.Buffer
+		for _, v := range m.all {
+			v.write(&buf)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	})
+}
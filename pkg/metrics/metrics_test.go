@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/shopspring/decimal"
+)
+
+func scrape(t *testing.T, m *Metrics, update func(context.Context)) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler(update).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	byt, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(byt)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	for _, e := range []event.Event{
+		&event.SignalReceived{},
+		&event.SignalReceived{},
+		&event.SignalRejected{Reason: "max_trades"},
+		&event.TradeStarted{},
+		&event.TradeStarted{Resumed: true},
+		&event.BuyFilled{},
+		&event.OCOPlaced{},
+		&event.OCOCanceled{},
+		&event.ErrorRetry{Operation: "buy"},
+		&event.ErrorRetry{Operation: "buy"},
+	} {
+		m.Observe(e)
+	}
+	got := scrape(t, m, func(context.Context) {
+		m.SetTrades([]TradeProfit{{Trade: "a1b2c3d4", Base: "IGO", Quote: "USDT", Profit: decimal.NewFromFloat(-1.5)}})
+		m.SetBalance("USDT", decimal.NewFromInt(100))
+	})
+	for _, want := range []string{
+		"# TYPE zeken_open_trades gauge\nzeken_open_trades 1\n",
+		`zeken_trade_unrealized_profit{trade="a1b2c3d4",base="IGO",quote="USDT"} -1.5`,
+		`zeken_balance{asset="USDT"} 100`,
+		"zeken_signals_received_total 2\n",
+		"zeken_signals_accepted_total 1\n",
+		`zeken_signals_rejected_total{reason="max_trades"} 1`,
+		"zeken_orders_canceled_total 1\n",
+		`zeken_trade_retries_total{operation="buy"} 2`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in:\n%s", want, got)
+		}
+	}
+
+	// Orders are counted by the exchange, not by their events
+	if strings.Contains(got, "zeken_orders_placed_total{") {
+		t.Errorf("orders counted from events:\n%s", got)
+	}
+
+	// Closed trades are removed
+	got = scrape(t, m, func(context.Context) { m.SetTrades(nil) })
+	if strings.Contains(got, "a1b2c3d4") {
+		t.Errorf("closed trade still exposed:\n%s", got)
+	}
+}
+
+type failingExchange struct {
+	exchange.Exchange
+}
+
+func (failingExchange) Price(context.Context, string) (decimal.Decimal, error) {
+	return decimal.Zero, errors.New("timeout")
+}
+
+// orderExchange accepts buy and oco orders and rejects sell orders
+type orderExchange struct {
+	exchange.Exchange
+}
+
+func (orderExchange) Buy(context.Context, string, decimal.Decimal, decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	return decimal.NewFromInt(100), decimal.NewFromInt(10), nil
+}
+
+func (orderExchange) Sell(context.Context, string, decimal.Decimal) (decimal.Decimal, error) {
+	return decimal.Zero, errors.New("insufficient balance")
+}
+
+func (orderExchange) CreateStopLimit(context.Context, string, decimal.Decimal, decimal.Decimal, decimal.Decimal) (string, []string, error) {
+	return "1", []string{"2", "3"}, nil
+}
+
+func TestExchangeOrders(t *testing.T) {
+	m := New()
+	ex := m.Exchange(orderExchange{})
+	ctx := context.Background()
+	if _, _, err := ex.Buy(ctx, "IGOUSDT", decimal.NewFromInt(100), decimal.Zero); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ex.CreateStopLimit(ctx, "IGOUSDT", decimal.NewFromInt(10), decimal.NewFromInt(11), decimal.NewFromInt(9)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ex.Sell(ctx, "IGOUSDT", decimal.NewFromInt(10)); err == nil {
+		t.Fatal("expected error")
+	}
+	got := scrape(t, m, nil)
+	for _, want := range []string{
+		`zeken_orders_placed_total{type="buy"} 1`,
+		`zeken_orders_placed_total{type="oco"} 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in:\n%s", want, got)
+		}
+	}
+	// Rejected orders aren't counted
+	if strings.Contains(got, `zeken_orders_placed_total{type="sell"}`) {
+		t.Errorf("rejected sell counted:\n%s", got)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := New()
+	ex := m.Exchange(failingExchange{})
+	if _, err := ex.Price(context.Background(), "IGOUSDT"); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, ok := ex.(exchange.Candler); ok {
+		t.Error("exchange shouldn't be a candler")
+	}
+	got := scrape(t, m, nil)
+	for _, want := range []string{
+		`zeken_exchange_errors_total{endpoint="price"} 1`,
+		`zeken_exchange_request_duration_seconds_bucket{endpoint="price",le="+Inf"} 1`,
+		`zeken_exchange_request_duration_seconds_count{endpoint="price"} 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in:\n%s", want, got)
+		}
+	}
+
+	// Optional interfaces are kept
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	bt := backtest.New(clock.NewVirtual(start), backtest.Series{}, nil)
+	ex = m.Exchange(bt)
+	_, isCandler := bt.(exchange.Candler)
+	_, isStreamer := bt.(exchange.Streamer)
+	if _, ok := ex.(exchange.Candler); ok != isCandler {
+		t.Errorf("candler interface not kept")
+	}
+	if _, ok := ex.(exchange.Streamer); ok != isStreamer {
+		t.Errorf("streamer interface not kept")
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// vec is a metric family with optional labels exposed in prometheus text
+// format
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	lock    sync.Mutex
+	samples map[string]*sample
+	buckets []float64
+}
+
+type sample struct {
+	labels []string
+	value  float64
+	// Histogram values
+	counts []uint64
+	count  uint64
+}
+
+func newVec(typ, name, help string, labels ...string) *vec {
+	return &vec{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		samples: make(map[string]*sample),
+	}
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *vec {
+	v := newVec("histogram", name, help, labels...)
+	v.buckets = buckets
+	return v
+}
+
+func (v *vec) get(values []string) *sample {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d labels, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.samples[key]
+	if !ok {
+		s = &sample{labels: values, counts: make([]uint64, len(v.buckets))}
+		v.samples[key] = s
+	}
+	return s
+}
+
+func (v *vec) add(n float64, values ...string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.get(values).value += n
+}
+
+func (v *vec) set(n float64, values ...string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.get(values).value = n
+}
+
+// reset removes all samples
+func (v *vec) reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.samples = make(map[string]*sample)
+}
+
+func (v *vec) observe(n float64, values ...string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	s := v.get(values)
+	for i, b := range v.buckets {
+		if n <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += n
+}
+
+func (v *vec) write(w io.Writer) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+	keys := make([]string, 0, len(v.samples))
+	for k := range v.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.samples[k]
+		if v.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labels), formatValue(s.value))
+			continue
+		}
+		names := append(append([]string{}, v.labels...), "le")
+		for i, b := range v.buckets {
+			values := append(append([]string{}, s.labels...), formatValue(b))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(names, values), s.counts[i])
+		}
+		values := append(append([]string{}, s.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labels, s.labels), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labels, s.labels), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var pairs []string
+	for i, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(values[i])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
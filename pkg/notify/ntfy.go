@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Ntfy publishes messages to a ntfy topic
+type Ntfy struct {
+	url string
+}
+
+// NewNtfy creates a ntfy notifier, url is the full topic url
+// (e.g. https://ntfy.sh/mytopic)
+func NewNtfy(url string) *Ntfy {
+	return &Ntfy{url: url}
+}
+
+func (n *Ntfy) Notify(ctx context.Context, level Level, msg string) error {
+	header := http.Header{}
+	header.Set("Title", "zeken")
+	header.Set("Tags", level.String())
+	header.Set("Priority", ntfyPriority(level))
+	return post(ctx, n.url, "text/plain; charset=utf-8", strings.NewReader(msg), header)
+}
+
+func ntfyPriority(level Level) string {
+	switch level {
+	case Debug:
+		return "min"
+	case Warning:
+		return "high"
+	case Error:
+		return "urgent"
+	default:
+		return "default"
+	}
+}
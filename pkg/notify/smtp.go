@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP sends messages by email
+type SMTP struct {
+	addr     string
+	auth     smtp.Auth
+	from     string
+	to       []string
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTP creates an email notifier, authentication is skipped if username
+// is empty
+func NewSMTP(addr, username, password, from string, to []string) *SMTP {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTP{
+		addr:     addr,
+		auth:     auth,
+		from:     from,
+		to:       to,
+		sendMail: smtp.SendMail,
+	}
+}
+
+func (s *SMTP) Notify(ctx context.Context, level Level, msg string) error {
+	subject := msg
+	if i := strings.Index(subject, "\n"); i >= 0 {
+		subject = subject[:i]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&b, "Subject: [zeken %s] %s\r\n", level, subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	// net/smtp doesn't support contexts, so the send is abandoned on cancel
+	errC := make(chan error, 1)
+	go func() {
+		errC <- s.sendMail(s.addr, s.auth, s.from, s.to, []byte(b.String()))
+	}()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("notify: couldn't send email: %w", ctx.Err())
+	case err := <-errC:
+		if err != nil {
+			return fmt.Errorf("notify: couldn't send email: %w", err)
+		}
+		return nil
+	}
+}
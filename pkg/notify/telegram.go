@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Telegram sends messages to a telegram chat using the bot api
+type Telegram struct {
+	baseURL string
+	token   string
+	chatID  string
+}
+
+// NewTelegram creates a telegram notifier, chat id can be a numeric id or a
+// public channel name (e.g. @mychannel)
+func NewTelegram(token, chatID string) *Telegram {
+	return &Telegram{
+		baseURL: "https://api.telegram.org",
+		token:   token,
+		chatID:  chatID,
+	}
+}
+
+func (t *Telegram) Notify(ctx context.Context, _ Level, msg string) error {
+	byt, err := json.Marshal(map[string]string{
+		"chat_id": t.chatID,
+		"text":    msg,
+	})
+	if err != nil {
+		return fmt.Errorf("notify: couldn't encode telegram message: %w", err)
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", t.baseURL, t.token)
+	if err := post(ctx, url, "application/json", bytes.NewReader(byt), nil); err != nil {
+		return fmt.Errorf("notify: couldn't send telegram message to %s: %w", t.chatID, err)
+	}
+	return nil
+}
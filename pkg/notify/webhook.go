@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Webhook posts messages as json to an url
+type Webhook struct {
+	url     string
+	payload func(level Level, msg string) interface{}
+}
+
+// NewWebhook creates a generic webhook notifier that posts
+// {"level": "info", "message": "...", "time": "..."}
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url: url,
+		payload: func(level Level, msg string) interface{} {
+			return struct {
+				Level   string    `json:"level"`
+				Message string    `json:"message"`
+				Time    time.Time `json:"time"`
+			}{
+				Level:   level.String(),
+				Message: msg,
+				Time:    time.Now().UTC(),
+			}
+		},
+	}
+}
+
+// NewSlack creates a notifier for slack compatible incoming webhooks
+func NewSlack(url string) *Webhook {
+	return &Webhook{
+		url: url,
+		payload: func(_ Level, msg string) interface{} {
+			return map[string]string{"text": msg}
+		},
+	}
+}
+
+// NewDiscord creates a notifier for discord webhooks
+func NewDiscord(url string) *Webhook {
+	return &Webhook{
+		url: url,
+		payload: func(_ Level, msg string) interface{} {
+			return map[string]string{"content": msg}
+		},
+	}
+}
+
+func (w *Webhook) Notify(ctx context.Context, level Level, msg string) error {
+	byt, err := json.Marshal(w.payload(level, msg))
+	if err != nil {
+		return fmt.Errorf("notify: couldn't encode webhook payload: %w", err)
+	}
+	return post(ctx, w.url, "application/json", bytes.NewReader(byt), nil)
+}
+
+func post(ctx context.Context, url, contentType string, body io.Reader, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return fmt.Errorf("notify: couldn't create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: couldn't post: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		byt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notify: invalid status code %d: %s", resp.StatusCode, string(byt))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+type request struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+func newServer(t *testing.T, status int) (*httptest.Server, *[]request) {
+	var reqs []request
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		byt, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		reqs = append(reqs, request{path: r.URL.Path, header: r.Header, body: string(byt)})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s, &reqs
+}
+
+func TestWebhooks(t *testing.T) {
+	tests := []struct {
+		name   string
+		new    func(url string) Notifier
+		path   string
+		field  string
+		header map[string]string
+	}{
+		{
+			name:  "webhook",
+			new:   func(url string) Notifier { return NewWebhook(url + "/hook") },
+			path:  "/hook",
+			field: "message",
+		},
+		{
+			name:  "slack",
+			new:   func(url string) Notifier { return NewSlack(url + "/services/a") },
+			path:  "/services/a",
+			field: "text",
+		},
+		{
+			name:  "discord",
+			new:   func(url string) Notifier { return NewDiscord(url + "/api/webhooks/1") },
+			path:  "/api/webhooks/1",
+			field: "content",
+		},
+		{
+			name: "telegram",
+			new: func(url string) Notifier {
+				n := NewTelegram("123:abc", "-100")
+				n.baseURL = url
+				return n
+			},
+			path:  "/bot123:abc/sendMessage",
+			field: "text",
+		},
+		{
+			name:   "ntfy",
+			new:    func(url string) Notifier { return NewNtfy(url + "/zeken") },
+			path:   "/zeken",
+			header: map[string]string{"Priority": "high", "Tags": "warning"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s, reqs := newServer(t, http.StatusOK)
+			if err := tt.new(s.URL).Notify(context.Background(), Warning, "✔️ IGO reached target 1"); err != nil {
+				t.Fatal(err)
+			}
+			if len(*reqs) != 1 {
+				t.Fatalf("wrong number of requests: %d", len(*reqs))
+			}
+			req := (*reqs)[0]
+			if req.path != tt.path {
+				t.Errorf("wrong path: want %s, got %s", tt.path, req.path)
+			}
+			for k, v := range tt.header {
+				if got := req.header.Get(k); got != v {
+					t.Errorf("wrong %s header: want %s, got %s", k, v, got)
+				}
+			}
+			msg := req.body
+			if tt.field != "" {
+				var payload map[string]string
+				if err := json.Unmarshal([]byte(req.body), &payload); err != nil {
+					t.Fatal(err)
+				}
+				msg = payload[tt.field]
+			}
+			if msg != "✔️ IGO reached target 1" {
+				t.Errorf("wrong message: %s", req.body)
+			}
+		})
+	}
+}
+
+func TestWebhookStatus(t *testing.T) {
+	s, _ := newServer(t, http.StatusForbidden)
+	if err := NewSlack(s.URL).Notify(context.Background(), Info, "msg"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	n := NewSMTP("smtp.example.com:587", "user", "pass", "a@example.com", []string{"b@example.com"})
+	var got string
+	n.sendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "smtp.example.com:587" || from != "a@example.com" || len(to) != 1 {
+			t.Errorf("wrong params: %s %s %v", addr, from, to)
+		}
+		got = string(msg)
+		return nil
+	}
+	if err := n.Notify(context.Background(), Error, "binance: timeout\ndetails"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "Subject: [zeken error] binance: timeout\r\n") {
+		t.Errorf("wrong subject: %s", got)
+	}
+	if !strings.HasSuffix(got, "\r\n\r\nbinance: timeout\r\ndetails\r\n") {
+		t.Errorf("wrong body: %s", got)
+	}
+}
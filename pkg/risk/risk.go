@@ -0,0 +1,205 @@
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Limits trip the guard when they are reached, zero disables a limit
+type Limits struct {
+	// DailyLoss and WeeklyLoss are the maximum losses of the day and the week
+	// in the quote currency, including losses of running trades
+	DailyLoss  decimal.Decimal
+	WeeklyLoss decimal.Decimal
+	// Drawdown is the maximum ratio the equity can drop from its peak
+	Drawdown decimal.Decimal
+}
+
+// Enabled returns true if any limit is set
+func (l Limits) Enabled() bool {
+	return l.DailyLoss.IsPositive() || l.WeeklyLoss.IsPositive() || l.Drawdown.IsPositive()
+}
+
+// PnL is the profit and loss used to check the limits
+type PnL struct {
+	// Daily and Weekly are the profits of trades finished during the day and
+	// the week plus the profits of running trades
+	Daily  decimal.Decimal
+	Weekly decimal.Decimal
+	// Equity is the balance plus the value of running trades, it is only
+	// required by the drawdown limit
+	Equity decimal.Decimal
+}
+
+// Reason is the limit that tripped the guard
+type Reason string
+
+const (
+	DailyLoss  Reason = "daily_loss"
+	WeeklyLoss Reason = "weekly_loss"
+	Drawdown   Reason = "drawdown"
+)
+
+// Trip is a breach of a limit
+type Trip struct {
+	Time   time.Time       `json:"time"`
+	Reason Reason          `json:"reason"`
+	Loss   decimal.Decimal `json:"loss"`
+	Limit  decimal.Decimal `json:"limit"`
+	// Until is the end of the period of the limit, drawdown trips don't
+	// expire
+	Until time.Time `json:"until,omitempty"`
+}
+
+// state is the persisted state of the guard
+type state struct {
+	Trip *Trip           `json:"trip,omitempty"`
+	Peak decimal.Decimal `json:"peak"`
+	// Losses are counted from the profits of the day and the week when the
+	// guard was resumed
+	Day        time.Time       `json:"day"`
+	DailyBase  decimal.Decimal `json:"daily_base"`
+	Week       time.Time       `json:"week"`
+	WeeklyBase decimal.Decimal `json:"weekly_base"`
+}
+
+// Guard stops trading when loss limits are reached
+type Guard struct {
+	limits Limits
+	path   string
+	lock   sync.Mutex
+	state  state
+}
+
+// New creates a guard with the limits.
+// If path isn't empty, state is persisted to that file so trips survive
+// restarts.
+func New(limits Limits, path string) (*Guard, error) {
+	g := &Guard{limits: limits, path: path}
+	if path == "" {
+		return g, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("risk: couldn't read state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &g.state); err != nil {
+		return nil, fmt.Errorf("risk: couldn't decode state %s: %w", path, err)
+	}
+	return g, nil
+}
+
+// Periods returns the start of the day and the week, weeks start on Monday
+func Periods(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day, day.AddDate(0, 0, -offset)
+}
+
+// Limits returns the limits of the guard
+func (g *Guard) Limits() Limits {
+	return g.limits
+}
+
+// Tripped returns the current trip or nil if trading is allowed
+func (g *Guard) Tripped() *Trip {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.state.Trip
+}
+
+// Check updates the guard with the current profits.
+// It returns the trip if a limit has just been reached and expired if the
+// previous trip expired at the end of its period.
+func (g *Guard) Check(now time.Time, pnl PnL) (trip *Trip, expired bool, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	now = now.UTC()
+	day, week := Periods(now)
+	prev := g.state
+
+	if t := g.state.Trip; t != nil && !t.Until.IsZero() && !now.Before(t.Until) {
+		g.state.Trip = nil
+		expired = true
+	}
+	if g.limits.Drawdown.IsPositive() && pnl.Equity.GreaterThan(g.state.Peak) {
+		g.state.Peak = pnl.Equity
+	}
+	if g.state.Trip == nil {
+		trip = g.check(now, day, week, pnl)
+		g.state.Trip = trip
+	}
+	if g.state.Trip != prev.Trip || !g.state.Peak.Equal(prev.Peak) {
+		err = g.save()
+	}
+	return trip, expired, err
+}
+
+// check returns the first limit reached
+func (g *Guard) check(now, day, week time.Time, pnl PnL) *Trip {
+	daily, weekly := pnl.Daily, pnl.Weekly
+	if g.state.Day.Equal(day) {
+		daily = daily.Sub(g.state.DailyBase)
+	}
+	if g.state.Week.Equal(week) {
+		weekly = weekly.Sub(g.state.WeeklyBase)
+	}
+	if limit := g.limits.DailyLoss; limit.IsPositive() && daily.Neg().GreaterThanOrEqual(limit) {
+		return &Trip{Time: now, Reason: DailyLoss, Loss: daily.Neg(), Limit: limit, Until: day.AddDate(0, 0, 1)}
+	}
+	if limit := g.limits.WeeklyLoss; limit.IsPositive() && weekly.Neg().GreaterThanOrEqual(limit) {
+		return &Trip{Time: now, Reason: WeeklyLoss, Loss: weekly.Neg(), Limit: limit, Until: week.AddDate(0, 0, 7)}
+	}
+	if limit := g.limits.Drawdown; limit.IsPositive() && g.state.Peak.IsPositive() {
+		drawdown := g.state.Peak.Sub(pnl.Equity).Div(g.state.Peak)
+		if drawdown.GreaterThanOrEqual(limit) {
+			return &Trip{Time: now, Reason: Drawdown, Loss: drawdown, Limit: limit}
+		}
+	}
+	return nil
+}
+
+// Resume allows trading again, losses of the current periods and the
+// drawdown are counted from the given profits
+func (g *Guard) Resume(now time.Time, pnl PnL) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	day, week := Periods(now)
+	g.state = state{
+		Peak:       pnl.Equity,
+		Day:        day,
+		DailyBase:  pnl.Daily,
+		Week:       week,
+		WeeklyBase: pnl.Weekly,
+	}
+	return g.save()
+}
+
+// save persists the state if a path has been provided
+func (g *Guard) save() error {
+	if g.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(g.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("risk: couldn't encode state: %w", err)
+	}
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("risk: couldn't write state %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, g.path); err != nil {
+		return fmt.Errorf("risk: couldn't write state %s: %w", g.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package risk
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestPeriods(t *testing.T) {
+	// Wednesday
+	day, week := Periods(time.Date(2021, 9, 1, 15, 4, 5, 0, time.UTC))
+	if want := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC); !day.Equal(want) {
+		t.Errorf("wrong day: want %s, got %s", want, day)
+	}
+	if want := time.Date(2021, 8, 30, 0, 0, 0, 0, time.UTC); !week.Equal(want) {
+		t.Errorf("wrong week: want %s, got %s", want, week)
+	}
+	// Sunday belongs to the previous week
+	if _, week := Periods(time.Date(2021, 9, 5, 23, 0, 0, 0, time.UTC)); !week.Equal(time.Date(2021, 8, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong sunday week: %s", week)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	limits := Limits{
+		DailyLoss:  decimal.NewFromInt(50),
+		WeeklyLoss: decimal.NewFromInt(100),
+		Drawdown:   decimal.NewFromFloat(0.2),
+	}
+	tests := []struct {
+		name  string
+		pnls  []PnL
+		want  Reason
+		until time.Time
+	}{
+		{
+			name: "no limit reached",
+			pnls: []PnL{{Daily: decimal.NewFromInt(-49), Weekly: decimal.NewFromInt(-99), Equity: decimal.NewFromInt(1000)}},
+		},
+		{
+			name:  "daily loss",
+			pnls:  []PnL{{Daily: decimal.NewFromInt(-50), Weekly: decimal.NewFromInt(-50), Equity: decimal.NewFromInt(1000)}},
+			want:  DailyLoss,
+			until: time.Date(2021, 9, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "weekly loss",
+			pnls:  []PnL{{Daily: decimal.NewFromInt(-10), Weekly: decimal.NewFromInt(-120), Equity: decimal.NewFromInt(1000)}},
+			want:  WeeklyLoss,
+			until: time.Date(2021, 9, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "drawdown from peak",
+			pnls: []PnL{
+				{Daily: decimal.NewFromInt(200), Weekly: decimal.NewFromInt(200), Equity: decimal.NewFromInt(1200)},
+				{Daily: decimal.NewFromInt(-40), Weekly: decimal.NewFromInt(-40), Equity: decimal.NewFromInt(960)},
+			},
+			want: Drawdown,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(limits, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var trip *Trip
+			for _, pnl := range tt.pnls {
+				if trip, _, err = g.Check(now, pnl); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.want == "" {
+				if trip != nil || g.Tripped() != nil {
+					t.Fatalf("unexpected trip: %+v", trip)
+				}
+				return
+			}
+			if trip == nil || trip.Reason != tt.want || !trip.Until.Equal(tt.until) {
+				t.Fatalf("wrong trip: want %s until %s, got %+v", tt.want, tt.until, trip)
+			}
+			if g.Tripped() != trip {
+				t.Error("guard should be tripped")
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "guard.json")
+	g, err := New(Limits{DailyLoss: decimal.NewFromInt(50)}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loss := PnL{Daily: decimal.NewFromInt(-60), Weekly: decimal.NewFromInt(-60)}
+	if trip, _, err := g.Check(now, loss); err != nil || trip == nil {
+		t.Fatalf("guard should be tripped: %v", err)
+	}
+
+	// State is restored after restarts
+	g, err = New(Limits{DailyLoss: decimal.NewFromInt(50)}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Tripped() == nil {
+		t.Fatal("trip should be restored")
+	}
+	// Trips aren't reported twice
+	if trip, _, err := g.Check(now.Add(time.Hour), loss); err != nil || trip != nil {
+		t.Fatalf("unexpected trip %+v: %v", trip, err)
+	}
+
+	// Losses are counted from the resume
+	if err := g.Resume(now, loss); err != nil {
+		t.Fatal(err)
+	}
+	if trip, _, err := g.Check(now.Add(time.Hour), PnL{Daily: decimal.NewFromInt(-100), Weekly: decimal.NewFromInt(-100)}); err != nil || trip != nil {
+		t.Fatalf("unexpected trip %+v: %v", trip, err)
+	}
+	trip, _, err := g.Check(now.Add(2*time.Hour), PnL{Daily: decimal.NewFromInt(-110), Weekly: decimal.NewFromInt(-110)})
+	if err != nil || trip == nil {
+		t.Fatalf("guard should be tripped after resume: %v", err)
+	}
+
+	// Trips expire at the end of the period
+	trip, expired, err := g.Check(time.Date(2021, 9, 2, 0, 0, 0, 0, time.UTC), PnL{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expired || trip != nil || g.Tripped() != nil {
+		t.Errorf("trip should have expired: %+v", trip)
+	}
+}
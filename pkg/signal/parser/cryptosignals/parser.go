@@ -30,6 +30,27 @@ func NewParser() (signal.Parser, error) {
 	}, nil
 }
 
+// ParseAction parses messages marked with ❌ as the trade being closed by the
+// channel
+func (p *parser) ParseAction(text string) (*signal.Action, error) {
+	if !strings.Contains(text, "❌") {
+		return nil, signal.ErrNoAction
+	}
+	lines := strings.Split(strings.ToUpper(text), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("trade hasn't enough lines: %d", len(lines))
+	}
+	symbol := p.text.FindAllString(lines[1], -1)
+	if len(symbol) < 2 {
+		return nil, fmt.Errorf("couldn't parse symbol: %s", lines[1])
+	}
+	return &signal.Action{
+		Type:  signal.CloseTrade,
+		Base:  symbol[0],
+		Quote: symbol[1],
+	}, nil
+}
+
 func (p *parser) Parse(text string) (*signal.Signal, error) {
 	if strings.Contains(text, "✅") || strings.Contains(text, "❌") {
 		return nil, errors.New("trade has already started or finished")
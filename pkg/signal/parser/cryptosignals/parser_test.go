@@ -143,6 +143,29 @@ Stop Loss: 185 %.❌`,
 	}
 }
 
+func TestParseAction(t *testing.T) {
+	p, err := NewParser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := p.(signal.ActionParser)
+	got, err := parser.ParseAction(`🔥BINANCE
+TFUEL-USDT
+Entrada: 0.34141
+Target 1: 0.36872 % 8
+Stop Loss: 0.30044 ❌`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &signal.Action{Type: signal.CloseTrade, Base: "TFUEL", Quote: "USDT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if _, err := parser.ParseAction("🔥BINANCE\nTFUEL-USDT\nEntrada: 0.34141"); err != signal.ErrNoAction {
+		t.Errorf("want error %v, got %v", signal.ErrNoAction, err)
+	}
+}
+
 func toDecimal(value string) decimal.Decimal {
 	d, err := decimal.NewFromString(value)
 	if err != nil {
@@ -2,7 +2,9 @@ package json
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/igolaizola/zeken/pkg/signal"
 	"github.com/shopspring/decimal"
@@ -17,6 +19,59 @@ type jsonSignal struct {
 	Start     string   `json:"start"`
 	Targets   []string `json:"targets"`
 	Stop      string   `json:"stop"`
+	// Allocation contains percentages of the quantity to be sold at each target
+	Allocation []string `json:"allocation,omitempty"`
+	// Entry contains the low and high prices of the entry zone, start is
+	// optional if it is set
+	Entry []string `json:"entry,omitempty"`
+	// Expiry is the duration the entry can be pending, e.g. 12h
+	Expiry string `json:"expiry,omitempty"`
+}
+
+type jsonAction struct {
+	// Action is close_trade, move_stop, update_targets or cancel_pending
+	Action  string   `json:"action"`
+	Base    string   `json:"base"`
+	Quote   string   `json:"quote"`
+	Stop    string   `json:"stop"`
+	Targets []string `json:"targets"`
+}
+
+// ParseAction parses messages with an action field as follow-ups
+func (p Parser) ParseAction(text string) (*signal.Action, error) {
+	var ja jsonAction
+	if err := json.Unmarshal([]byte(text), &ja); err != nil || ja.Action == "" {
+		return nil, signal.ErrNoAction
+	}
+	a := &signal.Action{
+		Type:  signal.ActionType(ja.Action),
+		Base:  ja.Base,
+		Quote: ja.Quote,
+	}
+	var err error
+	switch a.Type {
+	case signal.CloseTrade, signal.CancelPending:
+	case signal.MoveStop:
+		if ja.Stop != "" {
+			if a.StopPrice, err = decimal.NewFromString(ja.Stop); err != nil {
+				return nil, fmt.Errorf("json: couldn't parse stop price (%s): %w", ja.Stop, err)
+			}
+		}
+	case signal.UpdateTargets:
+		if len(ja.Targets) == 0 {
+			return nil, errors.New("json: missing targets")
+		}
+		for i, target := range ja.Targets {
+			d, err := decimal.NewFromString(target)
+			if err != nil {
+				return nil, fmt.Errorf("json: couldn't parse target %d price (%s): %w", i+1, target, err)
+			}
+			a.Targets = append(a.Targets, d)
+		}
+	default:
+		return nil, fmt.Errorf("json: unknown action %s", ja.Action)
+	}
+	return a, nil
 }
 
 func (p Parser) Parse(text string) (*signal.Signal, error) {
@@ -31,9 +86,27 @@ func (p Parser) Parse(text string) (*signal.Signal, error) {
 		Targets:   make([]decimal.Decimal, len(js.Targets)),
 	}
 	var err error
-	s.Start, err = decimal.NewFromString(js.Start)
-	if err != nil {
-		return nil, fmt.Errorf("json: couldn't parse start price (%s): %w", s.Start, err)
+	if len(js.Entry) > 0 {
+		if len(js.Entry) != 2 {
+			return nil, fmt.Errorf("json: entry must have low and high prices: %v", js.Entry)
+		}
+		if s.EntryLow, err = decimal.NewFromString(js.Entry[0]); err != nil {
+			return nil, fmt.Errorf("json: couldn't parse entry low price (%s): %w", js.Entry[0], err)
+		}
+		if s.EntryHigh, err = decimal.NewFromString(js.Entry[1]); err != nil {
+			return nil, fmt.Errorf("json: couldn't parse entry high price (%s): %w", js.Entry[1], err)
+		}
+	}
+	if js.Expiry != "" {
+		if s.Expiry, err = time.ParseDuration(js.Expiry); err != nil {
+			return nil, fmt.Errorf("json: couldn't parse expiry (%s): %w", js.Expiry, err)
+		}
+	}
+	if js.Start != "" || len(js.Entry) == 0 {
+		s.Start, err = decimal.NewFromString(js.Start)
+		if err != nil {
+			return nil, fmt.Errorf("json: couldn't parse start price (%s): %w", js.Start, err)
+		}
 	}
 	s.Stop, err = decimal.NewFromString(js.Stop)
 	if err != nil {
@@ -45,5 +118,12 @@ func (p Parser) Parse(text string) (*signal.Signal, error) {
 			return nil, fmt.Errorf("json: couldn't parse target %d price (%s): %w", i+1, target, err)
 		}
 	}
+	for i, alloc := range js.Allocation {
+		perc, err := decimal.NewFromString(alloc)
+		if err != nil {
+			return nil, fmt.Errorf("json: couldn't parse target %d allocation (%s): %w", i+1, alloc, err)
+		}
+		s.Allocation = append(s.Allocation, perc.Shift(-2))
+	}
 	return s, nil
 }
@@ -3,6 +3,7 @@ package json
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/igolaizola/zeken/pkg/signal"
 	"github.com/shopspring/decimal"
@@ -46,6 +47,60 @@ func TestParse(t *testing.T) {
 				Stop: toDecimal("0.30044"),
 			},
 		},
+		{
+			name: "allocation",
+			msg: `{
+	"exchanges": ["BINANCE"],
+	"base": "TFUEL",
+	"quote": "USDT",
+	"start": "0.34141",
+	"targets": ["0.36872", "0.39262"],
+	"stop": "0.30044",
+	"allocation": ["50", "50"]
+}`,
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE"},
+				Base:      "TFUEL",
+				Quote:     "USDT",
+				Start:     toDecimal("0.34141"),
+				Targets: []decimal.Decimal{
+					toDecimal("0.36872"),
+					toDecimal("0.39262"),
+				},
+				Stop:       toDecimal("0.30044"),
+				Allocation: []decimal.Decimal{toDecimal("0.50"), toDecimal("0.50")},
+			},
+		},
+		{
+			name: "entry zone",
+			msg: `{
+	"exchanges": ["BINANCE"],
+	"base": "TFUEL",
+	"quote": "USDT",
+	"entry": ["0.120", "0.125"],
+	"expiry": "12h",
+	"targets": ["0.13", "0.14"],
+	"stop": "0.11"
+}`,
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE"},
+				Base:      "TFUEL",
+				Quote:     "USDT",
+				Targets: []decimal.Decimal{
+					toDecimal("0.13"),
+					toDecimal("0.14"),
+				},
+				Stop:      toDecimal("0.11"),
+				EntryLow:  toDecimal("0.120"),
+				EntryHigh: toDecimal("0.125"),
+				Expiry:    12 * time.Hour,
+			},
+		},
+		{
+			name:    "invalid entry zone",
+			msg:     `{"exchanges": ["BINANCE"], "base": "TFUEL", "quote": "USDT", "entry": ["0.120"], "targets": ["0.13"], "stop": "0.11"}`,
+			wantErr: true,
+		},
 	}
 
 	parser := Parser{}
@@ -66,6 +121,65 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     string
+		want    *signal.Action
+		wantErr error
+	}{
+		{
+			name:    "new signal",
+			msg:     `{"exchanges": ["BINANCE"], "base": "TFUEL", "quote": "USDT", "start": "0.12", "targets": ["0.13"], "stop": "0.11"}`,
+			wantErr: signal.ErrNoAction,
+		},
+		{
+			name: "close trade",
+			msg:  `{"action": "close_trade", "base": "TFUEL", "quote": "USDT"}`,
+			want: &signal.Action{Type: signal.CloseTrade, Base: "TFUEL", Quote: "USDT"},
+		},
+		{
+			name: "move stop",
+			msg:  `{"action": "move_stop", "stop": "0.125"}`,
+			want: &signal.Action{Type: signal.MoveStop, StopPrice: toDecimal("0.125")},
+		},
+		{
+			name: "update targets",
+			msg:  `{"action": "update_targets", "targets": ["0.14", "0.15"]}`,
+			want: &signal.Action{Type: signal.UpdateTargets, Targets: []decimal.Decimal{toDecimal("0.14"), toDecimal("0.15")}},
+		},
+	}
+
+	parser := Parser{}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.ParseAction(tt.msg)
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, *tt.want) {
+				t.Errorf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+	for _, msg := range []string{
+		`{"action": "buy"}`,
+		`{"action": "update_targets"}`,
+		`{"action": "move_stop", "stop": "abc"}`,
+	} {
+		if _, err := parser.ParseAction(msg); err == nil || err == signal.ErrNoAction {
+			t.Errorf("%s: expected parse error, got %v", msg, err)
+		}
+	}
+}
+
 func toDecimal(value string) decimal.Decimal {
 	d, err := decimal.NewFromString(value)
 	if err != nil {
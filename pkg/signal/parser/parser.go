@@ -2,20 +2,37 @@ package parser
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/igolaizola/zeken/pkg/signal"
 	"github.com/igolaizola/zeken/pkg/signal/parser/cryptosignals"
 	"github.com/igolaizola/zeken/pkg/signal/parser/json"
+	"github.com/igolaizola/zeken/pkg/signal/parser/template"
+	"github.com/igolaizola/zeken/pkg/signal/parser/tradingview"
 )
 
 var ErrNotFound = errors.New("parser: not found")
 
+// NewParser returns the parser with the name.
+// The template parser requires the path of its rule file as argument, e.g.
+// `template:vip.json`.
 func NewParser(name string) (signal.Parser, error) {
+	var arg string
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, arg = name[:i], name[i+1:]
+	}
 	switch name {
 	case "json":
 		return json.Parser{}, nil
+	case "tradingview":
+		return tradingview.Parser{}, nil
 	case "cryptosignals":
 		return cryptosignals.NewParser()
+	case "template":
+		if arg == "" {
+			return nil, errors.New("parser: template rule file not provided")
+		}
+		return template.Load(arg)
 	default:
 		return nil, ErrNotFound
 	}
@@ -0,0 +1,440 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/shopspring/decimal"
+)
+
+// Config contains the rules to parse signal messages of a channel.
+//
+// Example of a rule file for the cryptosignals format:
+//
+//	{
+//	  "uppercase": true,
+//	  "skip": ["✅", "❌"],
+//	  "exchanges": {"line": 1, "regex": "[A-Z]+"},
+//	  "pair": {"line": 2, "regex": "([A-Z0-9]+)\\W+([A-Z0-9]+)"},
+//	  "entry": {"template": "ENTRADA: {price}"},
+//	  "targets": {"template": "TARGET {any}: {price}"},
+//	  "stop": {"template": "STOP LOSS: {price}"},
+//	  "actions": {"close_trade": {"template": "❌"}}
+//	}
+type Config struct {
+	// Uppercase converts the message to upper case before matching
+	Uppercase bool `json:"uppercase"`
+	// Skip rejects messages containing any of the keywords
+	Skip []string `json:"skip"`
+	// Require rejects messages that don't contain all of the keywords
+	Require []string `json:"require"`
+	// DecimalSeparator is "." or "," if numbers use thousands separators.
+	// By default a single "," or "." is used as decimal separator.
+	DecimalSeparator string `json:"decimal_separator"`
+	// Exchanges captures exchange names, all groups of all matches are used
+	Exchanges Rule `json:"exchanges"`
+	// DefaultExchanges are used if the exchanges rule is empty
+	DefaultExchanges []string `json:"default_exchanges"`
+	// Pair captures the base and the quote
+	Pair Rule `json:"pair"`
+	// DefaultQuote is used if the pair rule only captures the base
+	DefaultQuote string `json:"default_quote"`
+	// Entry captures the entry price or the low and high prices of the
+	// entry zone
+	Entry Rule `json:"entry"`
+	// Targets captures a price in each match
+	Targets Rule `json:"targets"`
+	Stop    Rule `json:"stop"`
+	// Actions detect follow-up messages by action type: close_trade,
+	// cancel_pending, move_stop and update_targets.
+	// The trade is selected with {base} and {quote} if the message isn't a
+	// reply, move_stop captures the new stop with {price}, the entry price is
+	// used if it is missing, and update_targets uses the targets rule.
+	Actions map[signal.ActionType]Rule `json:"actions"`
+}
+
+// actions are the supported follow-ups in matching order
+var actions = []signal.ActionType{
+	signal.CloseTrade,
+	signal.CancelPending,
+	signal.MoveStop,
+	signal.UpdateTargets,
+}
+
+// Rule is a regular expression or a line template.
+// Templates match text literally ignoring case, spaces match any number of
+// spaces and the following placeholders are supported: {exchange}, {base},
+// {quote}, {price} and {any}.
+// Values are captured from the groups of the regular expression or the
+// placeholders of the template, except {any}.
+type Rule struct {
+	Regex    string `json:"regex"`
+	Template string `json:"template"`
+	// Line is the number of the line to match starting at 1, the whole
+	// message is matched if it is 0
+	Line int `json:"line"`
+}
+
+var placeholders = map[string]string{
+	"exchange": `([A-Z0-9]+)`,
+	"base":     `([A-Z0-9]+)`,
+	"quote":    `([A-Z0-9]+)`,
+	"price":    `([0-9][0-9.,]*)`,
+	"any":      `.*?`,
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+var spaces = regexp.MustCompile(`\s+`)
+
+// matcher finds the values of a rule in a message
+type matcher struct {
+	regex *regexp.Regexp
+	line  int
+	// names are the placeholders or the group names of each group
+	names []string
+}
+
+func (m *matcher) text(text string) string {
+	if m.line == 0 {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	if m.line > len(lines) {
+		return ""
+	}
+	return lines[m.line-1]
+}
+
+// find returns the values of the first match
+func (m *matcher) find(text string) []string {
+	return values(m.regex.FindStringSubmatch(m.text(text)))
+}
+
+// named returns the values of the first match by placeholder or group name,
+// nil if there is no match
+func (m *matcher) named(text string) map[string]string {
+	match := m.regex.FindStringSubmatch(m.text(text))
+	if match == nil {
+		return nil
+	}
+	values := map[string]string{}
+	for i, name := range m.names {
+		if v := strings.TrimSpace(match[i+1]); name != "" && v != "" {
+			values[name] = v
+		}
+	}
+	return values
+}
+
+// findAll returns the values of each match
+func (m *matcher) findAll(text string) [][]string {
+	var all [][]string
+	for _, match := range m.regex.FindAllStringSubmatch(m.text(text), -1) {
+		all = append(all, values(match))
+	}
+	return all
+}
+
+// compile returns the matcher of the rule, nil if the rule is empty
+func (r Rule) compile() (*matcher, error) {
+	if r.Line < 0 {
+		return nil, fmt.Errorf("invalid line %d", r.Line)
+	}
+	regex, names, err := r.regex()
+	if err != nil || regex == nil {
+		return nil, err
+	}
+	return &matcher{regex: regex, line: r.Line, names: names}, nil
+}
+
+// regex returns the regular expression of the rule and the name of each group
+func (r Rule) regex() (*regexp.Regexp, []string, error) {
+	switch {
+	case r.Regex != "" && r.Template != "":
+		return nil, nil, errors.New("regex and template can't be used together")
+	case r.Regex != "":
+		regex, err := regexp.Compile("(?m)" + r.Regex)
+		if err != nil {
+			return nil, nil, err
+		}
+		return regex, regex.SubexpNames()[1:], nil
+	case r.Template != "":
+		var names []string
+		var sb strings.Builder
+		sb.WriteString("(?mi)")
+		last := 0
+		for _, loc := range placeholder.FindAllStringSubmatchIndex(r.Template, -1) {
+			sb.WriteString(literal(r.Template[last:loc[0]]))
+			name := r.Template[loc[2]:loc[3]]
+			expr, ok := placeholders[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown placeholder {%s}", name)
+			}
+			if name != "any" {
+				names = append(names, name)
+			}
+			sb.WriteString(expr)
+			last = loc[1]
+		}
+		sb.WriteString(literal(r.Template[last:]))
+		regex, err := regexp.Compile(sb.String())
+		return regex, names, err
+	default:
+		return nil, nil, nil
+	}
+}
+
+// literal quotes the text so spaces match any number of spaces
+func literal(text string) string {
+	var parts []string
+	for _, p := range spaces.Split(text, -1) {
+		parts = append(parts, regexp.QuoteMeta(p))
+	}
+	return strings.Join(parts, `\s*`)
+}
+
+// Parser parses signals using the rules of a config
+type Parser struct {
+	cfg       Config
+	exchanges *matcher
+	pair      *matcher
+	entry     *matcher
+	targets   *matcher
+	stop      *matcher
+	actions   map[signal.ActionType]*matcher
+}
+
+// New creates a parser from the config
+func New(cfg Config) (*Parser, error) {
+	switch cfg.DecimalSeparator {
+	case "", ".", ",":
+	default:
+		return nil, fmt.Errorf("template: invalid decimal separator %q", cfg.DecimalSeparator)
+	}
+	p := &Parser{cfg: cfg}
+	for _, r := range []struct {
+		name     string
+		rule     Rule
+		matcher  **matcher
+		required bool
+	}{
+		{"exchanges", cfg.Exchanges, &p.exchanges, len(cfg.DefaultExchanges) == 0},
+		{"pair", cfg.Pair, &p.pair, true},
+		{"entry", cfg.Entry, &p.entry, true},
+		{"targets", cfg.Targets, &p.targets, true},
+		{"stop", cfg.Stop, &p.stop, true},
+	} {
+		m, err := r.rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("template: couldn't compile %s rule: %w", r.name, err)
+		}
+		if m == nil && r.required {
+			return nil, fmt.Errorf("template: missing %s rule", r.name)
+		}
+		*r.matcher = m
+	}
+	p.actions = map[signal.ActionType]*matcher{}
+	for typ, rule := range cfg.Actions {
+		if !isAction(typ) {
+			return nil, fmt.Errorf("template: unknown action %s", typ)
+		}
+		m, err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("template: couldn't compile %s rule: %w", typ, err)
+		}
+		if m != nil {
+			p.actions[typ] = m
+		}
+	}
+	return p, nil
+}
+
+// Load creates a parser from a json rule file
+func Load(path string) (*Parser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("template: couldn't read %s: %w", path, err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("template: couldn't decode %s: %w", path, err)
+	}
+	return New(cfg)
+}
+
+func (p *Parser) Parse(text string) (*signal.Signal, error) {
+	for _, k := range p.cfg.Skip {
+		if strings.Contains(text, k) {
+			return nil, fmt.Errorf("message contains %s", k)
+		}
+	}
+	if p.cfg.Uppercase {
+		text = strings.ToUpper(text)
+	}
+	for _, k := range p.cfg.Require {
+		if !strings.Contains(text, k) {
+			return nil, fmt.Errorf("message doesn't contain %s", k)
+		}
+	}
+
+	s := &signal.Signal{}
+	if p.exchanges != nil {
+		for _, v := range p.exchanges.findAll(text) {
+			for _, e := range v {
+				s.Exchanges = append(s.Exchanges, strings.ToUpper(e))
+			}
+		}
+	}
+	if len(s.Exchanges) == 0 {
+		s.Exchanges = p.cfg.DefaultExchanges
+	}
+	if len(s.Exchanges) == 0 {
+		return nil, errors.New("couldn't parse exchanges")
+	}
+
+	pair := p.pair.find(text)
+	switch {
+	case len(pair) >= 2:
+		s.Base, s.Quote = strings.ToUpper(pair[0]), strings.ToUpper(pair[1])
+	case len(pair) == 1 && p.cfg.DefaultQuote != "":
+		s.Base, s.Quote = strings.ToUpper(pair[0]), p.cfg.DefaultQuote
+	default:
+		return nil, errors.New("couldn't parse pair")
+	}
+
+	var err error
+	// Entry rules that capture two prices define an entry zone
+	if entry := p.entry.find(text); len(entry) >= 2 {
+		if s.EntryLow, err = p.price("entry low", entry[:1]); err != nil {
+			return nil, err
+		}
+		if s.EntryHigh, err = p.price("entry high", entry[1:2]); err != nil {
+			return nil, err
+		}
+		if s.EntryLow.GreaterThan(s.EntryHigh) {
+			s.EntryLow, s.EntryHigh = s.EntryHigh, s.EntryLow
+		}
+	} else if s.Start, err = p.price("entry", entry); err != nil {
+		return nil, err
+	}
+	if s.Stop, err = p.price("stop", p.stop.find(text)); err != nil {
+		return nil, err
+	}
+	for i, v := range p.targets.findAll(text) {
+		target, err := p.price(fmt.Sprintf("target %d", i+1), v)
+		if err != nil {
+			return nil, err
+		}
+		s.Targets = append(s.Targets, target)
+	}
+	if len(s.Targets) == 0 {
+		return nil, errors.New("couldn't parse targets")
+	}
+	return s, nil
+}
+
+// ParseAction parses follow-ups using the action rules, skip and require
+// keywords only apply to new signals
+func (p *Parser) ParseAction(text string) (*signal.Action, error) {
+	if p.cfg.Uppercase {
+		text = strings.ToUpper(text)
+	}
+	for _, typ := range actions {
+		m, ok := p.actions[typ]
+		if !ok {
+			continue
+		}
+		values := m.named(text)
+		if values == nil {
+			continue
+		}
+		a := &signal.Action{
+			Type:  typ,
+			Base:  strings.ToUpper(values["base"]),
+			Quote: strings.ToUpper(values["quote"]),
+		}
+		if a.Base != "" && a.Quote == "" {
+			a.Quote = p.cfg.DefaultQuote
+		}
+		switch typ {
+		case signal.MoveStop:
+			if v, ok := values["price"]; ok {
+				stop, err := p.price("stop", []string{v})
+				if err != nil {
+					return nil, err
+				}
+				a.StopPrice = stop
+			}
+		case signal.UpdateTargets:
+			for i, v := range p.targets.findAll(text) {
+				target, err := p.price(fmt.Sprintf("target %d", i+1), v)
+				if err != nil {
+					return nil, err
+				}
+				a.Targets = append(a.Targets, target)
+			}
+			if len(a.Targets) == 0 {
+				return nil, errors.New("couldn't parse targets")
+			}
+		}
+		return a, nil
+	}
+	return nil, signal.ErrNoAction
+}
+
+func isAction(typ signal.ActionType) bool {
+	for _, a := range actions {
+		if a == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// price parses the first value as a price
+func (p *Parser) price(name string, v []string) (decimal.Decimal, error) {
+	if len(v) == 0 {
+		return decimal.Zero, fmt.Errorf("couldn't parse %s", name)
+	}
+	num := v[0]
+	switch p.cfg.DecimalSeparator {
+	case ".":
+		num = strings.ReplaceAll(num, ",", "")
+	case ",":
+		num = strings.ReplaceAll(num, ".", "")
+		num = strings.Replace(num, ",", ".", 1)
+	default:
+		num = strings.Replace(num, ",", ".", 1)
+	}
+	// Prices at the end of a sentence may have a trailing separator
+	num = strings.TrimRight(num, ".")
+	d, err := decimal.NewFromString(num)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("couldn't parse %s price %s: %w", name, v[0], err)
+	}
+	return d, nil
+}
+
+// values returns the non empty groups of the match or the whole match if the
+// regular expression has no groups
+func values(match []string) []string {
+	if len(match) == 0 {
+		return nil
+	}
+	if len(match) == 1 {
+		return []string{strings.TrimSpace(match[0])}
+	}
+	var vs []string
+	for _, m := range match[1:] {
+		if m = strings.TrimSpace(m); m != "" {
+			vs = append(vs, m)
+		}
+	}
+	return vs
+}
@@ -0,0 +1,288 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/shopspring/decimal"
+)
+
+var cryptosignals = Config{
+	Uppercase: true,
+	Skip:      []string{"✅", "❌"},
+	Exchanges: Rule{Line: 1, Regex: `[A-Z]+`},
+	Pair:      Rule{Line: 2, Regex: `([A-Z0-9]+)\W+([A-Z0-9]+)`},
+	Entry:     Rule{Template: "ENTRADA: {price}"},
+	Targets:   Rule{Template: "TARGET {any}: {price}"},
+	Stop:      Rule{Template: "STOP LOSS: {price}"},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		msg     string
+		want    *signal.Signal
+		wantErr bool
+	}{
+		{
+			name: "cryptosignals",
+			cfg:  cryptosignals,
+			msg: `🚨BINANCE / FTX
+SOL / USDT
+Entrada: 35.9
+Target 1: 38.8 % 8
+Target 2: 41.6 % 16
+Stop Loss: 31`,
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE", "FTX"},
+				Base:      "SOL",
+				Quote:     "USDT",
+				Start:     toDecimal("35.9"),
+				Targets:   []decimal.Decimal{toDecimal("38.8"), toDecimal("41.6")},
+				Stop:      toDecimal("31"),
+			},
+		},
+		{
+			name: "cryptosignals with commas",
+			cfg:  cryptosignals,
+			msg: `🔥BINANCE
+TFUEL-USDT
+Entrada: 0,34141
+Target 1: 0,36872 % 8
+Stop Loss: 0,30044`,
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE"},
+				Base:      "TFUEL",
+				Quote:     "USDT",
+				Start:     toDecimal("0.34141"),
+				Targets:   []decimal.Decimal{toDecimal("0.36872")},
+				Stop:      toDecimal("0.30044"),
+			},
+		},
+		{
+			name: "skip keyword",
+			cfg:  cryptosignals,
+			msg: `🔥BINANCE
+TLM-USDT
+Entrada: 0.2730
+Target 1: 0.2948 % 8.✅
+Stop Loss: 0.2239`,
+			wantErr: true,
+		},
+		{
+			name: "templates with default exchange and quote",
+			cfg: Config{
+				Require:          []string{"LONG"},
+				DecimalSeparator: ",",
+				DefaultExchanges: []string{"BINANCE"},
+				DefaultQuote:     "USDT",
+				Pair:             Rule{Template: "#{base} LONG"},
+				Entry:            Rule{Template: "Buy: {price}"},
+				Targets:          Rule{Template: "TP{any}: {price}"},
+				Stop:             Rule{Template: "SL: {price}"},
+			},
+			msg: `#btc LONG
+Buy: 45.000,5
+TP1: 46.000
+TP2: 47.500,25
+SL: 44.000.`,
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE"},
+				Base:      "BTC",
+				Quote:     "USDT",
+				Start:     toDecimal("45000.5"),
+				Targets:   []decimal.Decimal{toDecimal("46000"), toDecimal("47500.25")},
+				Stop:      toDecimal("44000"),
+			},
+		},
+		{
+			name: "entry zone",
+			cfg: Config{
+				DefaultExchanges: []string{"BINANCE"},
+				Pair:             Rule{Template: "#{base}/{quote}"},
+				Entry:            Rule{Template: "Buy {price} - {price}"},
+				Targets:          Rule{Template: "TP{any}: {price}"},
+				Stop:             Rule{Template: "SL: {price}"},
+			},
+			msg: "#IGO/USDT\nBuy 0.125 - 0.120\nTP1: 0.13\nSL: 0.11",
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE"},
+				Base:      "IGO",
+				Quote:     "USDT",
+				EntryLow:  toDecimal("0.120"),
+				EntryHigh: toDecimal("0.125"),
+				Targets:   []decimal.Decimal{toDecimal("0.13")},
+				Stop:      toDecimal("0.11"),
+			},
+		},
+		{
+			name: "required keyword",
+			cfg: Config{
+				Require:          []string{"LONG"},
+				DefaultExchanges: []string{"BINANCE"},
+				Pair:             Rule{Template: "#{base}/{quote}"},
+				Entry:            Rule{Template: "Buy: {price}"},
+				Targets:          Rule{Template: "TP{any}: {price}"},
+				Stop:             Rule{Template: "SL: {price}"},
+			},
+			msg:     "#BTC/USDT SHORT\nBuy: 45000\nTP1: 44000\nSL: 46000",
+			wantErr: true,
+		},
+		{
+			name: "missing targets",
+			cfg:  cryptosignals,
+			msg: `🔥BINANCE
+TFUEL-USDT
+Entrada: 0.34141
+Stop Loss: 0.30044`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Parse(tt.msg)
+			if err != nil {
+				if tt.wantErr {
+					return
+				}
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				t.Fatalf("expected error, got %v", got)
+			}
+			if !reflect.DeepEqual(*got, *tt.want) {
+				t.Errorf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	cfg := Config{
+		DefaultExchanges: []string{"BINANCE"},
+		DefaultQuote:     "USDT",
+		Pair:             Rule{Template: "#{base}/{quote}"},
+		Entry:            Rule{Template: "Buy: {price}"},
+		Targets:          Rule{Template: "TP{any}: {price}"},
+		Stop:             Rule{Template: "SL: {price}"},
+		Actions: map[signal.ActionType]Rule{
+			signal.CloseTrade:    {Template: "Close #{base}"},
+			signal.CancelPending: {Regex: `(?i)cancel(?:ed)?`},
+			signal.MoveStop:      {Template: "Move SL to {price}"},
+			signal.UpdateTargets: {Template: "New targets"},
+		},
+	}
+	tests := []struct {
+		name    string
+		msg     string
+		want    *signal.Action
+		wantErr error
+	}{
+		{
+			name:    "new signal",
+			msg:     "#BTC/USDT\nBuy: 45000\nTP1: 46000\nSL: 44000",
+			wantErr: signal.ErrNoAction,
+		},
+		{
+			name: "close trade with default quote",
+			msg:  "close #btc now",
+			want: &signal.Action{Type: signal.CloseTrade, Base: "BTC", Quote: "USDT"},
+		},
+		{
+			name: "cancel pending",
+			msg:  "Entry canceled",
+			want: &signal.Action{Type: signal.CancelPending},
+		},
+		{
+			name: "move stop",
+			msg:  "Move SL to 44500",
+			want: &signal.Action{Type: signal.MoveStop, StopPrice: toDecimal("44500")},
+		},
+		{
+			name: "update targets",
+			msg:  "New targets\nTP1: 47000\nTP2: 48000",
+			want: &signal.Action{Type: signal.UpdateTargets, Targets: []decimal.Decimal{toDecimal("47000"), toDecimal("48000")}},
+		},
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.ParseAction(tt.msg)
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Fatalf("want error %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, *tt.want) {
+				t.Errorf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"missing rule", Config{Exchanges: cryptosignals.Exchanges, Pair: cryptosignals.Pair}},
+		{"regex and template", Config{Exchanges: Rule{Regex: "A", Template: "A"}}},
+		{"unknown placeholder", Config{DefaultExchanges: []string{"BINANCE"}, Pair: Rule{Template: "{symbol}"}}},
+		{"invalid regex", Config{Exchanges: Rule{Regex: "("}}},
+		{"invalid separator", Config{DecimalSeparator: "'"}},
+		{"unknown action", Config{DefaultExchanges: []string{"BINANCE"}, Pair: Rule{Template: "{base}"}, Entry: Rule{Template: "{price}"}, Targets: Rule{Template: "{price}"}, Stop: Rule{Template: "{price}"}, Actions: map[signal.ActionType]Rule{"buy": {Template: "BUY"}}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.cfg); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{
+  "uppercase": true,
+  "exchanges": {"line": 1, "regex": "[A-Z]+"},
+  "pair": {"line": 2, "template": "{base}-{quote}"},
+  "entry": {"template": "ENTRADA: {price}"},
+  "targets": {"template": "TARGET {any}: {price}"},
+  "stop": {"template": "STOP LOSS: {price}"}
+}`
+	if err := os.WriteFile(path, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Parse("🔥BINANCE\nIGO-USDT\nEntrada: 10\nTarget 1: 11\nStop Loss: 9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Base != "IGO" || s.Quote != "USDT" || !s.Start.Equal(decimal.NewFromInt(10)) || len(s.Targets) != 1 {
+		t.Errorf("wrong signal: %+v", s)
+	}
+}
+
+func toDecimal(s string) decimal.Decimal {
+	d, _ := decimal.NewFromString(s)
+	return d
+}
@@ -0,0 +1,130 @@
+package tradingview
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	jsonparser "github.com/igolaizola/zeken/pkg/signal/parser/json"
+	"github.com/shopspring/decimal"
+)
+
+// quotes are the quote currencies used to split tickers without separator
+var quotes = []string{"USDT", "BUSD", "USDC", "TUSD", "BTC", "ETH", "BNB", "EUR"}
+
+// Parser parses TradingView alerts, messages that don't have a ticker are
+// parsed with the json parser.
+//
+// Alert message template example, targets and stop can be prices or
+// percentages relative to the price:
+//
+//	{"exchange": "{{exchange}}", "ticker": "{{ticker}}", "price": {{close}}, "targets": ["3%", "6%"], "stop": "2%"}
+type Parser struct{}
+
+type alert struct {
+	Exchange   string  `json:"exchange"`
+	Ticker     string  `json:"ticker"`
+	Quote      string  `json:"quote"`
+	Price      value   `json:"price"`
+	Targets    []value `json:"targets"`
+	Stop       value   `json:"stop"`
+	Allocation []value `json:"allocation,omitempty"`
+}
+
+// value is a json string or number
+type value string
+
+func (v *value) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*v = value(strings.TrimSpace(s))
+		return nil
+	}
+	*v = value(b)
+	return nil
+}
+
+func (p Parser) Parse(text string) (*signal.Signal, error) {
+	var a alert
+	if err := json.Unmarshal([]byte(text), &a); err != nil {
+		return nil, fmt.Errorf("tradingview: couldn't parse alert (%s): %w", text, err)
+	}
+	if a.Ticker == "" {
+		return jsonparser.Parser{}.Parse(text)
+	}
+	base, quote, err := split(a.Ticker, a.Quote)
+	if err != nil {
+		return nil, err
+	}
+	price, err := decimal.NewFromString(string(a.Price))
+	if err != nil {
+		return nil, fmt.Errorf("tradingview: couldn't parse price (%s): %w", a.Price, err)
+	}
+	s := &signal.Signal{
+		Exchanges: []string{strings.ToUpper(a.Exchange)},
+		Base:      base,
+		Quote:     quote,
+		Start:     price,
+	}
+	for i, t := range a.Targets {
+		target, err := relative(t, price, 1)
+		if err != nil {
+			return nil, fmt.Errorf("tradingview: couldn't parse target %d (%s): %w", i+1, t, err)
+		}
+		s.Targets = append(s.Targets, target)
+	}
+	if len(s.Targets) == 0 {
+		return nil, fmt.Errorf("tradingview: alert has no targets")
+	}
+	if s.Stop, err = relative(a.Stop, price, -1); err != nil {
+		return nil, fmt.Errorf("tradingview: couldn't parse stop (%s): %w", a.Stop, err)
+	}
+	for i, alloc := range a.Allocation {
+		perc, err := decimal.NewFromString(strings.TrimSuffix(string(alloc), "%"))
+		if err != nil {
+			return nil, fmt.Errorf("tradingview: couldn't parse target %d allocation (%s): %w", i+1, alloc, err)
+		}
+		s.Allocation = append(s.Allocation, perc.Shift(-2))
+	}
+	return s, nil
+}
+
+// split splits a ticker like BINANCE:IGOUSDT into base and quote
+func split(ticker, quote string) (string, string, error) {
+	ticker = strings.ToUpper(ticker)
+	if i := strings.LastIndex(ticker, ":"); i >= 0 {
+		ticker = ticker[i+1:]
+	}
+	ticker = strings.TrimSuffix(ticker, ".P")
+	candidates := quotes
+	if quote != "" {
+		candidates = []string{strings.ToUpper(quote)}
+	}
+	for _, q := range candidates {
+		if strings.HasSuffix(ticker, q) && len(ticker) > len(q) {
+			return strings.TrimSuffix(ticker, q), q, nil
+		}
+	}
+	return "", "", fmt.Errorf("tradingview: couldn't get quote currency of %s", ticker)
+}
+
+// relative parses a price or a percentage relative to the price, sign is the
+// direction of the percentage
+func relative(v value, price decimal.Decimal, sign int64) (decimal.Decimal, error) {
+	s := string(v)
+	if !strings.HasSuffix(s, "%") {
+		return decimal.NewFromString(s)
+	}
+	perc, err := decimal.NewFromString(strings.TrimSuffix(s, "%"))
+	if err != nil {
+		return decimal.Zero, err
+	}
+	ratio := decimal.NewFromInt(1).Add(perc.Abs().Shift(-2).Mul(decimal.NewFromInt(sign)))
+	return price.Mul(ratio), nil
+}
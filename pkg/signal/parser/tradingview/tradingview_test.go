@@ -0,0 +1,121 @@
+package tradingview
+
+import (
+	"testing"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/shopspring/decimal"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     string
+		want    *signal.Signal
+		wantErr bool
+	}{
+		{
+			name: "percentages",
+			msg:  `{"exchange": "binance", "ticker": "BINANCE:IGOUSDT", "price": 10, "targets": ["5%", "10%"], "stop": "2%", "allocation": ["50", "50"]}`,
+			want: &signal.Signal{
+				Exchanges:  []string{"BINANCE"},
+				Base:       "IGO",
+				Quote:      "USDT",
+				Start:      toDecimal("10"),
+				Targets:    []decimal.Decimal{toDecimal("10.5"), toDecimal("11")},
+				Stop:       toDecimal("9.8"),
+				Allocation: []decimal.Decimal{toDecimal("0.5"), toDecimal("0.5")},
+			},
+		},
+		{
+			name: "prices",
+			msg:  `{"exchange": "BINANCE", "ticker": "ETHBTC", "quote": "BTC", "price": "0.07", "targets": [0.075], "stop": 0.065}`,
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE"},
+				Base:      "ETH",
+				Quote:     "BTC",
+				Start:     toDecimal("0.07"),
+				Targets:   []decimal.Decimal{toDecimal("0.075")},
+				Stop:      toDecimal("0.065"),
+			},
+		},
+		{
+			name: "json signal",
+			msg:  `{"exchanges": ["BINANCE"], "base": "IGO", "quote": "USDT", "start": "10", "targets": ["11"], "stop": "9"}`,
+			want: &signal.Signal{
+				Exchanges: []string{"BINANCE"},
+				Base:      "IGO",
+				Quote:     "USDT",
+				Start:     toDecimal("10"),
+				Targets:   []decimal.Decimal{toDecimal("11")},
+				Stop:      toDecimal("9"),
+			},
+		},
+		{
+			name:    "unknown quote",
+			msg:     `{"exchange": "BINANCE", "ticker": "IGOXYZ", "price": 10, "targets": ["5%"], "stop": "2%"}`,
+			wantErr: true,
+		},
+		{
+			name:    "no targets",
+			msg:     `{"exchange": "BINANCE", "ticker": "IGOUSDT", "price": 10, "stop": "2%"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			msg:     `IGO long`,
+			wantErr: true,
+		},
+	}
+
+	parser := Parser{}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.Parse(tt.msg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equal(got, tt.want) {
+				t.Errorf("got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equal(a, b *signal.Signal) bool {
+	if len(a.Exchanges) != len(b.Exchanges) || a.Exchanges[0] != b.Exchanges[0] || a.Base != b.Base || a.Quote != b.Quote {
+		return false
+	}
+	if !a.Start.Equal(b.Start) || !a.Stop.Equal(b.Stop) {
+		return false
+	}
+	if len(a.Targets) != len(b.Targets) || len(a.Allocation) != len(b.Allocation) {
+		return false
+	}
+	for i := range a.Targets {
+		if !a.Targets[i].Equal(b.Targets[i]) {
+			return false
+		}
+	}
+	for i := range a.Allocation {
+		if !a.Allocation[i].Equal(b.Allocation[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func toDecimal(value string) decimal.Decimal {
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
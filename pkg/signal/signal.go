@@ -1,6 +1,10 @@
 package signal
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/shopspring/decimal"
 )
 
@@ -11,8 +15,70 @@ type Signal struct {
 	Start     decimal.Decimal
 	Targets   []decimal.Decimal
 	Stop      decimal.Decimal
+	// Allocation is the ratio of the quantity to be sold at each target,
+	// it overrides the default allocation if set
+	Allocation []decimal.Decimal
+	// EntryLow and EntryHigh are the entry zone, the trade waits for the
+	// price to be in the zone if they are set
+	EntryLow  decimal.Decimal
+	EntryHigh decimal.Decimal
+	// Expiry is the time the entry can be pending, it overrides the default
+	// expiry if set
+	Expiry time.Duration
 }
 
 type Parser interface {
 	Parse(text string) (*Signal, error)
 }
+
+// ActionType is the type of a signal message action
+type ActionType string
+
+const (
+	NewSignal     ActionType = "new_signal"
+	CloseTrade    ActionType = "close_trade"
+	MoveStop      ActionType = "move_stop"
+	UpdateTargets ActionType = "update_targets"
+	CancelPending ActionType = "cancel_pending"
+)
+
+// Action is a new signal or a follow-up of a previous signal.
+// Follow-ups apply to the trade of the message they reply to or to the trade
+// of the base and quote.
+type Action struct {
+	Type   ActionType
+	Signal *Signal
+	Base   string
+	Quote  string
+	// StopPrice is the new stop of MoveStop, the entry price is used if it
+	// is zero
+	StopPrice decimal.Decimal
+	// Targets are the new targets of UpdateTargets
+	Targets []decimal.Decimal
+}
+
+// ErrNoAction is returned by action parsers if the message isn't a follow-up
+var ErrNoAction = errors.New("signal: message isn't an action")
+
+// ActionParser is implemented by parsers that understand follow-up messages
+type ActionParser interface {
+	// ParseAction returns ErrNoAction if the message must be parsed as a
+	// new signal
+	ParseAction(text string) (*Action, error)
+}
+
+// Message is a message of a signal source.
+// ID and ReplyTo are optional and are used to link follow-ups with the
+// trade of a previous message.
+type Message struct {
+	ID      string
+	ReplyTo string
+	Text    string
+}
+
+// Source receives signal messages, e.g. from a chat or a webhook
+type Source interface {
+	// Run calls the handler with each message until the context is canceled.
+	// The handler returns an error if the signal is rejected.
+	Run(ctx context.Context, handler func(msg *Message) error) error
+}
@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+)
+
+// Source receives signals posted to an http endpoint.
+//
+// Requests are authenticated with one of:
+//   - X-Signature header with the hex HMAC-SHA256 of the body, optionally
+//     prefixed with `sha256=`
+//   - secret query parameter
+//   - secret field in the json body, as TradingView alerts can't set headers
+type Source struct {
+	addr   string
+	secret string
+}
+
+// New creates a webhook source listening on addr, the secret is required
+func New(addr, secret string) (*Source, error) {
+	if secret == "" {
+		return nil, errors.New("webhook: secret is required")
+	}
+	return &Source{addr: addr, secret: secret}, nil
+}
+
+func (s *Source) Run(ctx context.Context, handler func(msg *signal.Message) error) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("webhook: couldn't listen on %s: %w", s.addr, err)
+	}
+	srv := &http.Server{
+		Handler:      s.Handler(handler),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- srv.Serve(ln)
+	}()
+	select {
+	case err := <-errC:
+		return fmt.Errorf("webhook: server failed: %w", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+	return nil
+}
+
+// Handler returns the http handler that passes authenticated signals to the
+// handler
+func (s *Source) Handler(handler func(msg *signal.Message) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
+		if err != nil {
+			http.Error(w, "couldn't read body", http.StatusBadRequest)
+			return
+		}
+		text, ok := s.authenticate(r, body)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := handler(&signal.Message{Text: text}); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// authenticate checks the request and returns the signal text without the
+// secret
+func (s *Source) authenticate(r *http.Request, body []byte) (string, bool) {
+	if sig := r.Header.Get("X-Signature"); sig != "" {
+		got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+		if err != nil {
+			return "", false
+		}
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		return string(body), hmac.Equal(got, mac.Sum(nil))
+	}
+	if secret := r.URL.Query().Get("secret"); secret != "" {
+		return string(body), s.equal(secret)
+	}
+	// Numbers are kept as they are to avoid losing precision
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return "", false
+	}
+	secret, _ := fields["secret"].(string)
+	if !s.equal(secret) {
+		return "", false
+	}
+	// Remove the secret so it isn't logged
+	delete(fields, "secret")
+	byt, err := json.Marshal(fields)
+	if err != nil {
+		return "", false
+	}
+	return string(byt), true
+}
+
+func (s *Source) equal(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(s.secret)) == 1
+}
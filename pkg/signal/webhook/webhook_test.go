@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+)
+
+func TestHandler(t *testing.T) {
+	s, err := New("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	alert := `{"exchange": "BINANCE", "ticker": "IGOUSDT", "price": 10.123456789012345678}`
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header string
+		body   string
+		status int
+		text   string
+	}{
+		{
+			name:   "hmac",
+			url:    "/",
+			header: sign(alert),
+			body:   alert,
+			status: http.StatusAccepted,
+			text:   alert,
+		},
+		{
+			name:   "invalid hmac",
+			url:    "/",
+			header: sign("other"),
+			body:   alert,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "query secret",
+			url:    "/?secret=secret",
+			body:   alert,
+			status: http.StatusAccepted,
+			text:   alert,
+		},
+		{
+			name:   "body secret",
+			url:    "/",
+			body:   `{"secret": "secret", "ticker": "IGOUSDT", "price": 10.123456789012345678}`,
+			status: http.StatusAccepted,
+			text:   `{"price":10.123456789012345678,"ticker":"IGOUSDT"}`,
+		},
+		{
+			name:   "wrong body secret",
+			url:    "/",
+			body:   `{"secret": "wrong", "ticker": "IGOUSDT"}`,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "no secret",
+			url:    "/",
+			body:   alert,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "rejected",
+			url:    "/?secret=secret",
+			body:   "reject",
+			status: http.StatusUnprocessableEntity,
+			text:   "reject",
+		},
+		{
+			name:   "method",
+			method: http.MethodGet,
+			url:    "/?secret=secret",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := s.Handler(func(msg *signal.Message) error {
+				got = msg.Text
+				if msg.Text == "reject" {
+					return errors.New("rejected")
+				}
+				return nil
+			})
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, tt.url, strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set("X-Signature", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("wrong status: want %d, got %d", tt.status, rec.Code)
+			}
+			if got != tt.text {
+				t.Errorf("wrong text: want %q, got %q", tt.text, got)
+			}
+		})
+	}
+	if _, err := New(":0", ""); err == nil {
+		t.Error("expected error without secret")
+	}
+}
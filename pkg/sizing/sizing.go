@@ -0,0 +1,208 @@
+package sizing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	// ErrBelowMinimum is returned if the size is lower than the minimum
+	// notional of the exchange
+	ErrBelowMinimum = errors.New("sizing: quote quantity below minimum notional")
+	// ErrNotEnoughHistory is returned by strategies that require more
+	// finished trades of the signal source
+	ErrNotEnoughHistory = errors.New("sizing: not enough trade history")
+	// ErrNoEdge is returned if the source history has no positive expectancy
+	ErrNoEdge = errors.New("sizing: trade history has no edge")
+)
+
+// Params are the inputs used to size a trade
+type Params struct {
+	// Available is the free balance of the quote currency
+	Available decimal.Decimal
+	// Open is the quote quantity of running trades
+	Open      decimal.Decimal
+	MaxTrades int
+	// Entry and Stop are the prices of the signal
+	Entry decimal.Decimal
+	Stop  decimal.Decimal
+	// History returns the results of previous trades of the signal source,
+	// it is only called by strategies that need it
+	History func() (Stats, error)
+}
+
+// Equity returns the available balance plus running trades
+func (p Params) Equity() decimal.Decimal {
+	return p.Available.Add(p.Open)
+}
+
+// Stats are the results of finished trades
+type Stats struct {
+	Trades int
+	Wins   int
+	// AvgWin and AvgLoss are the average profit and loss ratios of winning
+	// and losing trades, both positive
+	AvgWin  decimal.Decimal
+	AvgLoss decimal.Decimal
+}
+
+// NewStats calculates the stats from the profit ratio of each trade
+func NewStats(profits []decimal.Decimal) Stats {
+	s := Stats{Trades: len(profits)}
+	win, loss := decimal.Zero, decimal.Zero
+	for _, p := range profits {
+		if p.IsPositive() {
+			s.Wins++
+			win = win.Add(p)
+		} else {
+			loss = loss.Sub(p)
+		}
+	}
+	if s.Wins > 0 {
+		s.AvgWin = win.Div(decimal.NewFromInt(int64(s.Wins)))
+	}
+	if losses := s.Trades - s.Wins; losses > 0 {
+		s.AvgLoss = loss.Div(decimal.NewFromInt(int64(losses)))
+	}
+	return s
+}
+
+// Strategy calculates the quote quantity of a trade
+type Strategy interface {
+	Size(p Params) (decimal.Decimal, error)
+}
+
+// Size returns the quote quantity of the strategy capped by the available
+// balance, ErrBelowMinimum is returned if it is lower than min
+func Size(s Strategy, p Params, min decimal.Decimal) (decimal.Decimal, error) {
+	qty, err := s.Size(p)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	// Keep some balance for fees
+	if qty.GreaterThanOrEqual(p.Available) {
+		qty = p.Available.Mul(decimal.NewFromFloat(0.99))
+	}
+	if !qty.IsPositive() || qty.LessThan(min) {
+		return decimal.Zero, fmt.Errorf("%w: %s < %s", ErrBelowMinimum, qty.StringFixed(2), min)
+	}
+	return qty, nil
+}
+
+// Balance splits a ratio of the equity between the maximum number of trades
+type Balance struct {
+	Ratio decimal.Decimal
+}
+
+func (s *Balance) Size(p Params) (decimal.Decimal, error) {
+	return p.Equity().Mul(s.Ratio).Div(decimal.NewFromInt(int64(p.MaxTrades))), nil
+}
+
+// Fixed uses the same quote quantity for every trade
+type Fixed struct {
+	Amount decimal.Decimal
+}
+
+func (s *Fixed) Size(p Params) (decimal.Decimal, error) {
+	return s.Amount, nil
+}
+
+// Percent uses a ratio of the equity
+type Percent struct {
+	Ratio decimal.Decimal
+}
+
+func (s *Percent) Size(p Params) (decimal.Decimal, error) {
+	return p.Equity().Mul(s.Ratio), nil
+}
+
+// Risk sizes trades so that the stop loses a ratio of the equity
+type Risk struct {
+	Ratio decimal.Decimal
+}
+
+func (s *Risk) Size(p Params) (decimal.Decimal, error) {
+	if !p.Stop.IsPositive() || !p.Stop.LessThan(p.Entry) {
+		return decimal.Zero, fmt.Errorf("sizing: stop %s must be lower than entry %s", p.Stop, p.Entry)
+	}
+	distance := p.Entry.Sub(p.Stop).Div(p.Entry)
+	return p.Equity().Mul(s.Ratio).Div(distance), nil
+}
+
+// Kelly uses a fraction of the kelly criterion calculated from the history of
+// the signal source
+type Kelly struct {
+	Fraction decimal.Decimal
+	// MinTrades is the minimum number of finished trades required
+	MinTrades int
+}
+
+func (s *Kelly) Size(p Params) (decimal.Decimal, error) {
+	if p.History == nil {
+		return decimal.Zero, ErrNotEnoughHistory
+	}
+	stats, err := p.History()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if stats.Trades == 0 || stats.Trades < s.MinTrades {
+		return decimal.Zero, fmt.Errorf("%w: %d trades", ErrNotEnoughHistory, stats.Trades)
+	}
+	winRate := decimal.NewFromInt(int64(stats.Wins)).Div(decimal.NewFromInt(int64(stats.Trades)))
+	kelly := winRate
+	if stats.AvgLoss.IsPositive() {
+		if !stats.AvgWin.IsPositive() {
+			return decimal.Zero, ErrNoEdge
+		}
+		// f = W - (1 - W) / R, where R is the win/loss ratio
+		ratio := stats.AvgWin.Div(stats.AvgLoss)
+		kelly = winRate.Sub(decimal.NewFromInt(1).Sub(winRate).Div(ratio))
+	}
+	if !kelly.IsPositive() {
+		return decimal.Zero, fmt.Errorf("%w: kelly %s", ErrNoEdge, kelly.StringFixed(4))
+	}
+	return p.Equity().Mul(kelly).Mul(s.Fraction), nil
+}
+
+// kellyMinTrades is the default minimum history of the kelly strategy
+const kellyMinTrades = 20
+
+// Parse parses a strategy, percentages are relative to the equity:
+//
+//	balance:99   99% of the equity divided by max trades
+//	fixed:100    100 of the quote currency
+//	percent:10   10% of the equity
+//	risk:1       the stop loses 1% of the equity
+//	kelly:50     50% of the kelly criterion of the source history
+func Parse(value string) (Strategy, error) {
+	split := strings.SplitN(value, ":", 2)
+	if len(split) != 2 {
+		return nil, fmt.Errorf("sizing: invalid strategy %q", value)
+	}
+	name := strings.TrimSpace(split[0])
+	v, err := decimal.NewFromString(strings.TrimSpace(split[1]))
+	if err != nil {
+		return nil, fmt.Errorf("sizing: couldn't parse %s value: %w", name, err)
+	}
+	if !v.IsPositive() {
+		return nil, fmt.Errorf("sizing: %s value must be positive", name)
+	}
+	ratio := v.Shift(-2)
+	switch name {
+	case "balance":
+		return &Balance{Ratio: ratio}, nil
+	case "fixed":
+		return &Fixed{Amount: v}, nil
+	case "percent":
+		return &Percent{Ratio: ratio}, nil
+	case "risk":
+		return &Risk{Ratio: ratio}, nil
+	case "kelly":
+		return &Kelly{Fraction: ratio, MinTrades: kellyMinTrades}, nil
+	default:
+		return nil, fmt.Errorf("sizing: unknown strategy %s", name)
+	}
+}
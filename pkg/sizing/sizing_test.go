@@ -0,0 +1,88 @@
+package sizing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSize(t *testing.T) {
+	history := func(profits ...float64) func() (Stats, error) {
+		return func() (Stats, error) {
+			var ds []decimal.Decimal
+			for _, p := range profits {
+				ds = append(ds, decimal.NewFromFloat(p))
+			}
+			return NewStats(ds), nil
+		}
+	}
+	params := Params{
+		Available: decimal.NewFromInt(800),
+		Open:      decimal.NewFromInt(200),
+		MaxTrades: 5,
+		Entry:     decimal.NewFromInt(10),
+		Stop:      decimal.NewFromInt(9),
+	}
+	tests := []struct {
+		name     string
+		strategy string
+		history  func() (Stats, error)
+		want     float64
+		err      error
+	}{
+		{name: "balance", strategy: "balance:50", want: 100},
+		{name: "fixed", strategy: "fixed:25", want: 25},
+		{name: "percent", strategy: "percent:10", want: 100},
+		// 1% of 1000 is lost with a 10% stop
+		{name: "risk", strategy: "risk:1", want: 100},
+		{name: "capped by available balance", strategy: "fixed:900", want: 792},
+		{name: "below minimum notional", strategy: "fixed:5", err: ErrBelowMinimum},
+		// 60% win rate with 2:1 win/loss ratio, kelly is 0.4 and half is used
+		{
+			name:     "kelly",
+			strategy: "kelly:50",
+			history:  history(0.2, 0.2, 0.2, -0.1, -0.1, 0.2, 0.2, 0.2, -0.1, -0.1, 0.2, 0.2, 0.2, -0.1, -0.1, 0.2, 0.2, 0.2, -0.1, -0.1),
+			want:     200,
+		},
+		{name: "kelly without history", strategy: "kelly:50", history: history(0.2, -0.1), err: ErrNotEnoughHistory},
+		{
+			name:     "kelly without edge",
+			strategy: "kelly:50",
+			history:  history(0.1, -0.2, -0.2, -0.2, 0.1, -0.2, -0.2, -0.2, 0.1, -0.2, -0.2, -0.2, 0.1, -0.2, -0.2, -0.2, 0.1, -0.2, -0.2, -0.2),
+			err:      ErrNoEdge,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := params
+			p.History = tt.history
+			got, err := Size(s, p, decimal.NewFromInt(10))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("want error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decimal.NewFromFloat(tt.want); !got.Equal(want) {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, value := range []string{"", "fixed", "fixed:abc", "percent:-1", "martingale:2"} {
+		if _, err := Parse(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
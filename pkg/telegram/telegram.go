@@ -6,6 +6,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	tb "gopkg.in/tucnak/telebot.v2"
@@ -16,6 +17,19 @@ type Bot struct {
 	chat     *tb.Chat
 	boot     time.Time
 	messages chan string
+	lock     sync.Mutex
+	chats    map[int64]func(m *Message)
+	// first is the chat of the first handler, it also receives the messages
+	// of the control chat
+	first int64
+}
+
+// Message is a text message of a chat, ReplyTo is the id of the message it
+// replies to or 0
+type Message struct {
+	ID      int
+	ReplyTo int
+	Text    string
 }
 
 func New(token string, chatID int) (*Bot, error) {
@@ -35,23 +49,42 @@ func New(token string, chatID int) (*Bot, error) {
 		chat:     chat,
 		boot:     time.Now(),
 		messages: make(chan string, 100),
+		chats:    make(map[int64]func(m *Message)),
 	}
+	b.Handle(tb.OnText, bot.onText)
 	return bot, nil
 }
 
-func (b *Bot) HandleChat(chatID int64, skipReply bool, handler func(string)) {
-	b.bot.Handle(tb.OnText, func(m *tb.Message) {
-		if m.Chat.ID != chatID && m.Chat.ID != b.chat.ID {
-			return
-		}
-		if m.Time().Before(b.boot) {
-			return
-		}
-		if m.IsReply() && skipReply {
-			return
-		}
-		handler(m.Text)
-	})
+// HandleChat handles the text messages of a chat, it can be called for
+// multiple chats.
+// Messages of the control chat are handled by the first chat handler.
+func (b *Bot) HandleChat(chatID int64, handler func(m *Message)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.chats) == 0 {
+		b.first = chatID
+	}
+	b.chats[chatID] = handler
+}
+
+func (b *Bot) onText(m *tb.Message) {
+	b.lock.Lock()
+	handler, ok := b.chats[m.Chat.ID]
+	if !ok && m.Chat.ID == b.chat.ID {
+		handler, ok = b.chats[b.first]
+	}
+	b.lock.Unlock()
+	if !ok {
+		return
+	}
+	if m.Time().Before(b.boot) {
+		return
+	}
+	msg := &Message{ID: m.ID, Text: m.Text}
+	if m.IsReply() {
+		msg.ReplyTo = m.ReplyTo.ID
+	}
+	handler(msg)
 }
 
 func (b *Bot) HandleCommand(command string, handler func(string)) {
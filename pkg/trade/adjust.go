@@ -0,0 +1,51 @@
+package trade
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+// adjustment changes the stop or the targets of a running trade
+type adjustment struct {
+	stop    decimal.Decimal
+	targets []decimal.Decimal
+}
+
+var errAdjustmentPending = errors.New("trade: previous update hasn't been applied yet")
+
+// MoveStop moves the stop loss of the trade, the entry price is used if the
+// stop is zero.
+// The sell orders are replaced when the trade is running.
+func (t *Trader) MoveStop(stop decimal.Decimal) error {
+	if !stop.IsPositive() {
+		stop = t.StartPrice
+	}
+	if stop.GreaterThanOrEqual(t.Targets[len(t.Targets)-1]) {
+		return errors.New("trade: stop must be lower than the last target")
+	}
+	return t.send(adjustment{stop: stop})
+}
+
+// UpdateTargets replaces the targets of the trade.
+// The sell orders are replaced when the trade is running.
+func (t *Trader) UpdateTargets(targets []decimal.Decimal) error {
+	if len(targets) == 0 {
+		return errors.New("trade: targets not provided")
+	}
+	for _, target := range targets {
+		if !target.GreaterThan(t.StopPrice) {
+			return errors.New("trade: targets must be greater than the stop")
+		}
+	}
+	return t.send(adjustment{targets: targets})
+}
+
+func (t *Trader) send(a adjustment) error {
+	select {
+	case t.adjust <- a:
+		return nil
+	default:
+		return errAdjustmentPending
+	}
+}
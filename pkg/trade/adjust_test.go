@@ -0,0 +1,108 @@
+package trade
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/shopspring/decimal"
+)
+
+func TestAdjust(t *testing.T) {
+	tr := New("IGO", "USDT", decimal.NewFromFloat(10.0), testTargets(), decimal.NewFromFloat(9.0), decimal.NewFromFloat(100.0))
+	ex := &mockExchange{price: decimal.NewFromFloat(10.1)}
+	updated := make(chan *event.TradeUpdated, 2)
+	publish := func(e event.Event) {
+		if u, ok := e.(*event.TradeUpdated); ok {
+			updated <- u
+		}
+	}
+	trader := NewTrader(publish, ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
+	if err := trader.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := trader.MoveStop(decimal.NewFromInt(20)); err == nil {
+		t.Error("expected error moving the stop above the targets")
+	}
+	if err := trader.UpdateTargets([]decimal.Decimal{decimal.NewFromInt(8)}); err == nil {
+		t.Error("expected error updating targets below the stop")
+	}
+
+	errC := make(chan error, 1)
+	go func() { errC <- trader.Run(context.Background()) }()
+	wait := func() *event.TradeUpdated {
+		t.Helper()
+		select {
+		case u := <-updated:
+			return u
+		case <-time.After(5 * time.Second):
+			t.Fatal("trade not updated")
+			return nil
+		}
+	}
+
+	// Zero moves the stop to the entry price
+	if err := trader.MoveStop(decimal.Zero); err != nil {
+		t.Fatal(err)
+	}
+	if u := wait(); !u.StopPrice.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("wrong stop: want 10, got %s", u.StopPrice)
+	}
+	targets := []decimal.Decimal{decimal.NewFromInt(12), decimal.NewFromInt(13)}
+	if err := trader.UpdateTargets(targets); err != nil {
+		t.Fatal(err)
+	}
+	if u := wait(); !reflect.DeepEqual(u.Targets, targets) {
+		t.Errorf("wrong targets: want %v, got %v", targets, u.Targets)
+	}
+	trader.Sell()
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+
+	if !tr.StopPrice.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("wrong trade stop: want 10, got %s", tr.StopPrice)
+	}
+	// Sell orders are replaced on each update
+	if ex.created != 3 {
+		t.Errorf("wrong number of created orders: want 3, got %d", ex.created)
+	}
+	if !ex.stop.Equal(decimal.NewFromInt(10)) || !ex.target.Equal(decimal.NewFromInt(13)) {
+		t.Errorf("wrong orders: want 13/10, got %s/%s", ex.target, ex.stop)
+	}
+}
+
+func TestAdjustStopAbovePrice(t *testing.T) {
+	tr := New("IGO", "USDT", decimal.NewFromFloat(10.0), testTargets(), decimal.NewFromFloat(9.0), decimal.NewFromFloat(100.0))
+	ex := &mockExchange{price: decimal.NewFromFloat(10.1)}
+	trader := NewTrader(func(event.Event) {}, ex, clock.New(), tr, 5, time.Hour, func(t *Trade) error { return nil }, nil)
+	if err := trader.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	trader.lastPrice = ex.price
+
+	// The stop is hit as soon as it is moved above the price
+	if err := trader.MoveStop(decimal.NewFromFloat(10.5)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := trader.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !ex.sold {
+		t.Error("trade not sold")
+	}
+	if ex.created != 1 {
+		t.Errorf("orders created after moving the stop: want 1, got %d", ex.created)
+	}
+	if tr.EndTime.IsZero() {
+		t.Error("trade not finished")
+	}
+	if want := decimal.NewFromFloat(101); !tr.EndQuoteQuantity.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, tr.EndQuoteQuantity)
+	}
+}
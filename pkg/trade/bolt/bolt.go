@@ -1,7 +1,7 @@
 package bolt
 
 import (
-	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -17,13 +17,9 @@ func New(path string) (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("bolt: couldn't open bold db %s: %w", path, err)
 	}
-	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists([]byte("trades")); err != nil {
-			return err
-		}
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("bolt: couldn't create bucket: %w", err)
+	if _, _, err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
 	}
 	return &Store{db: db}, nil
 }
@@ -39,39 +35,53 @@ func (s *Store) Close() {
 func (s *Store) List(from time.Time, to time.Time, finished bool) ([]*trade.Trade, error) {
 	var trades []*trade.Trade
 	if err := s.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte("trades")).Cursor()
-
-		// Time range
-		min := []byte(from.UTC().Format(time.RFC3339Nano))
-		max := []byte(to.UTC().Format(time.RFC3339Nano))
-
-		for k, v := c.Seek(min); k != nil && bytes.Compare(k, max) <= 0; k, v = c.Next() {
+		// Trades are keyed by id, so all of them must be checked
+		return tx.Bucket([]byte("trades")).ForEach(func(_, v []byte) error {
 			var t trade.Trade
 			if err := json.Unmarshal(v, &t); err != nil {
 				return fmt.Errorf("couldn't decode: %w", err)
 			}
 			if t.StartTime.Before(from) {
-				continue
+				return nil
 			}
 			if t.StartTime.After(to) {
-				continue
+				return nil
 			}
 			hasFinished := t.EndTime != time.Time{}
 			if hasFinished != finished {
-				continue
+				return nil
 			}
 			trades = append(trades, &t)
-		}
-
-		return nil
+			return nil
+		})
 	}); err != nil {
 		return nil, fmt.Errorf("bold: couldn't query: %w", err)
 	}
 	return trades, nil
 }
 
+func (s *Store) Ended(from time.Time, to time.Time) ([]*trade.Trade, error) {
+	var trades []*trade.Trade
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("trades")).ForEach(func(_, v []byte) error {
+			var t trade.Trade
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("couldn't decode: %w", err)
+			}
+			if t.EndTime.IsZero() || t.EndTime.Before(from) || t.EndTime.After(to) {
+				return nil
+			}
+			trades = append(trades, &t)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("bolt: couldn't query: %w", err)
+	}
+	return trades, nil
+}
+
 func (s *Store) Update(t *trade.Trade) error {
-	key := []byte(t.StartTime.UTC().Format(time.RFC3339Nano))
+	key := []byte(t.Key())
 	if err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("trades"))
 		byt, err := json.Marshal(t)
@@ -86,13 +96,60 @@ func (s *Store) Update(t *trade.Trade) error {
 }
 
 func (s *Store) Delete(t *trade.Trade) error {
-	key := []byte(t.StartTime.UTC().Format(time.RFC3339Nano))
+	key := []byte(t.Key())
 	if err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("trades"))
-		key := []byte(t.StartTime.UTC().Format(time.RFC3339Nano))
-		return b.Delete([]byte(key))
+		return b.Delete(key)
 	}); err != nil {
 		return fmt.Errorf("store: couldn't delete %s: %w", key, err)
 	}
 	return nil
 }
+
+// AddOrder adds an order event to the ledger.
+// Events are stored in a bucket per trade that isn't removed when the trade
+// is deleted, so they can be audited later.
+func (s *Store) AddOrder(e *trade.OrderEvent) error {
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("orders")).CreateBucketIfNotExists([]byte(e.Trade))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		byt, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("couldn't encode: %w", err)
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, byt)
+	}); err != nil {
+		return fmt.Errorf("bolt: couldn't add order of %s: %w", e.Trade, err)
+	}
+	return nil
+}
+
+// Orders returns the order events of a trade in insertion order
+func (s *Store) Orders(t *trade.Trade) ([]*trade.OrderEvent, error) {
+	var events []*trade.OrderEvent
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("orders")).Bucket([]byte(t.Key()))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var e trade.OrderEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("couldn't decode: %w", err)
+			}
+			events = append(events, &e)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("bolt: couldn't get orders of %s: %w", t.Key(), err)
+	}
+	return events, nil
+}
@@ -0,0 +1,20 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/igolaizola/zeken/pkg/trade/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) trade.Store {
+		s, err := New(filepath.Join(t.TempDir(), "zeken.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		return s
+	})
+}
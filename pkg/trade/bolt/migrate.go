@@ -0,0 +1,219 @@
+package bolt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/igolaizola/zeken/pkg/trade"
+)
+
+// SchemaVersion is the schema version of databases created by this package
+const SchemaVersion = 1
+
+// ErrNewerSchema is returned when the database has been created by a newer
+// version
+var ErrNewerSchema = errors.New("bolt: database schema is newer than supported")
+
+// migration upgrades the database schema to its version
+type migration struct {
+	version int
+	name    string
+	run     func(tx *bolt.Tx) error
+}
+
+// migrations must be sorted by version, the last one must match the schema
+// version
+var migrations = []migration{
+	{version: 1, name: "trade ids", run: migrateTradeIDs},
+}
+
+// Info contains the state of a database
+type Info struct {
+	Version  int
+	Trades   int
+	Finished int
+	Open     []*trade.Trade
+}
+
+// Migrate opens the database and runs pending migrations.
+// It returns the schema version before and after the migration.
+func Migrate(path string) (int, int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, 0, fmt.Errorf("bolt: couldn't open bold db %s: %w", path, err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, 0, fmt.Errorf("bolt: couldn't open bold db %s: %w", path, err)
+	}
+	defer db.Close()
+	return migrate(db)
+}
+
+// ReadInfo returns the schema version and the trades of a database without
+// modifying it
+func ReadInfo(path string) (*Info, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("bolt: couldn't open bold db %s: %w", path, err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("bolt: couldn't open bold db %s: %w", path, err)
+	}
+	defer db.Close()
+	info := &Info{}
+	if err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		if info.Version, err = version(tx); err != nil {
+			return err
+		}
+		trades := tx.Bucket([]byte("trades"))
+		if trades == nil {
+			return nil
+		}
+		return trades.ForEach(func(k, v []byte) error {
+			var t trade.Trade
+			if err := json.Unmarshal(v, &t); err != nil {
+				return fmt.Errorf("couldn't decode %s: %w", k, err)
+			}
+			info.Trades++
+			if t.EndTime.IsZero() {
+				info.Open = append(info.Open, &t)
+			} else {
+				info.Finished++
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("bolt: couldn't read info: %w", err)
+	}
+	return info, nil
+}
+
+// migrate creates missing buckets and runs pending migrations in a single
+// transaction
+func migrate(db *bolt.DB) (int, int, error) {
+	var from, to int
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"trades", "orders", "meta"} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return fmt.Errorf("couldn't create bucket %s: %w", name, err)
+			}
+		}
+		var err error
+		if from, err = version(tx); err != nil {
+			return err
+		}
+		if from > SchemaVersion {
+			return fmt.Errorf("%w: %d > %d", ErrNewerSchema, from, SchemaVersion)
+		}
+		to = from
+		for _, m := range migrations {
+			if m.version <= from {
+				continue
+			}
+			if err := m.run(tx); err != nil {
+				return fmt.Errorf("couldn't run migration %d (%s): %w", m.version, m.name, err)
+			}
+			to = m.version
+		}
+		return tx.Bucket([]byte("meta")).Put([]byte("version"), []byte(strconv.Itoa(to)))
+	}); err != nil {
+		return 0, 0, fmt.Errorf("bolt: couldn't migrate: %w", err)
+	}
+	return from, to, nil
+}
+
+// version returns the schema version, databases without version are 0
+func version(tx *bolt.Tx) (int, error) {
+	meta := tx.Bucket([]byte("meta"))
+	if meta == nil {
+		return 0, nil
+	}
+	v := meta.Get([]byte("version"))
+	if v == nil {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse schema version %s: %w", v, err)
+	}
+	return n, nil
+}
+
+// migrateTradeIDs generates ids for trades that were keyed by start time and
+// moves their order ledger to the new key
+func migrateTradeIDs(tx *bolt.Tx) error {
+	trades := tx.Bucket([]byte("trades"))
+	orders := tx.Bucket([]byte("orders"))
+
+	// Keys are collected first because buckets can't be modified while
+	// iterating
+	var keys [][]byte
+	if err := trades.ForEach(func(k, _ []byte) error {
+		keys = append(keys, append([]byte{}, k...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		var t trade.Trade
+		if err := json.Unmarshal(trades.Get(k), &t); err != nil {
+			return fmt.Errorf("couldn't decode %s: %w", k, err)
+		}
+		if t.ID != "" && t.ID == string(k) {
+			continue
+		}
+		if t.ID == "" {
+			t.ID = trade.NewID()
+		}
+		byt, err := json.Marshal(&t)
+		if err != nil {
+			return fmt.Errorf("couldn't encode %s: %w", k, err)
+		}
+		if err := trades.Put([]byte(t.ID), byt); err != nil {
+			return err
+		}
+		if err := trades.Delete(k); err != nil {
+			return err
+		}
+		if err := moveOrders(orders, k, []byte(t.ID)); err != nil {
+			return fmt.Errorf("couldn't move orders of %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// moveOrders moves the order ledger of a trade to a new key
+func moveOrders(orders *bolt.Bucket, from, to []byte) error {
+	old := orders.Bucket(from)
+	if old == nil {
+		return nil
+	}
+	b, err := orders.CreateBucketIfNotExists(to)
+	if err != nil {
+		return err
+	}
+	if err := old.ForEach(func(k, v []byte) error {
+		var e trade.OrderEvent
+		if err := json.Unmarshal(v, &e); err != nil {
+			return fmt.Errorf("couldn't decode: %w", err)
+		}
+		e.Trade = string(to)
+		byt, err := json.Marshal(&e)
+		if err != nil {
+			return fmt.Errorf("couldn't encode: %w", err)
+		}
+		return b.Put(k, byt)
+	}); err != nil {
+		return err
+	}
+	if err := b.SetSequence(old.Sequence()); err != nil {
+		return err
+	}
+	return orders.DeleteBucket(from)
+}
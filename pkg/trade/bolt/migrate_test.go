@@ -0,0 +1,127 @@
+package bolt
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		fixture string
+		from    int
+	}{
+		{fixture: "v0.db", from: 0},
+		{fixture: "v1.db", from: 1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.fixture, func(t *testing.T) {
+			path := copyFixture(t, tt.fixture)
+			from, to, err := Migrate(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from != tt.from || to != SchemaVersion {
+				t.Errorf("wrong versions: want %d -> %d, got %d -> %d", tt.from, SchemaVersion, from, to)
+			}
+
+			s, err := New(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			min, max := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			finished, err := s.List(min, max, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			open, err := s.List(min, max, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(finished) != 1 || len(open) != 1 {
+				t.Fatalf("wrong number of trades: want 1 finished and 1 open, got %d and %d", len(finished), len(open))
+			}
+			tr := open[0]
+			if tr.ID == "" || tr.Base != "IGO" || len(tr.OrderIDs) != 2 {
+				t.Errorf("wrong open trade: %+v", tr)
+			}
+			events, err := s.Orders(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 2 {
+				t.Fatalf("wrong number of orders: want 2, got %d", len(events))
+			}
+			for _, e := range events {
+				if e.Trade != tr.ID {
+					t.Errorf("wrong order trade: want %s, got %s", tr.ID, e.Trade)
+				}
+			}
+		})
+	}
+}
+
+func TestNewerSchema(t *testing.T) {
+	path := copyFixture(t, "v1.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("meta")).Put([]byte("version"), []byte("99"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := New(path); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("expected newer schema error, got %v", err)
+	}
+}
+
+func TestReadInfo(t *testing.T) {
+	path := copyFixture(t, "v0.db")
+	info, err := ReadInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 0 || info.Trades != 2 || info.Finished != 1 || len(info.Open) != 1 {
+		t.Errorf("wrong info: %+v", info)
+	}
+	// Reading info doesn't migrate the database
+	info, err = ReadInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 0 {
+		t.Errorf("database has been modified: version %d", info.Version)
+	}
+	if _, err := ReadInfo(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("expected error on missing database")
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	if last := migrations[len(migrations)-1].version; last != SchemaVersion {
+		t.Errorf("last migration %d doesn't match schema version %d", last, SchemaVersion)
+	}
+}
+
+// copyFixture copies a fixture database to a temporary directory
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	byt, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, byt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,85 @@
+package trade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/igolaizola/zeken/pkg/event"
+)
+
+var (
+	ErrEntryExpired  = errors.New("trade: entry expired")
+	ErrEntryCanceled = errors.New("trade: entry canceled")
+)
+
+// HasEntryZone returns whether the trade buys when the price is in an entry
+// zone instead of immediately
+func (t *Trade) HasEntryZone() bool {
+	return t.EntryLow.IsPositive() && t.EntryHigh.IsPositive()
+}
+
+// Pending returns whether the trade quantity hasn't been bought yet
+func (t *Trade) Pending() bool {
+	return t.Quantity.IsZero() && t.EndTime.IsZero()
+}
+
+// waitEntry waits until the price is in the entry zone.
+// The pending trade is stored so it can be resumed, it is canceled if the
+// entry expires or the trade is sold.
+func (t *Trader) waitEntry(ctx context.Context) error {
+	if err := t.update(t.Trade); err != nil {
+		t.error(fmt.Errorf("trade: couldn't update %s: %w", t.Base, err))
+	}
+	t.publish(&event.EntryPending{
+		Time:   t.clock.Now().UTC(),
+		Trade:  t.ID,
+		Base:   t.Base,
+		Quote:  t.Quote,
+		Low:    t.EntryLow,
+		High:   t.EntryHigh,
+		Expiry: t.EntryExpiry,
+	})
+	tick := newTicker(t.clock, t.wait)
+	for {
+		t.sync()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.sell:
+			t.cancelEntry(false)
+			return ErrEntryCanceled
+		case <-tick.C():
+		}
+		if !t.EntryExpiry.IsZero() && !t.clock.Now().Before(t.EntryExpiry) {
+			t.cancelEntry(true)
+			return ErrEntryExpired
+		}
+		price, err := t.exchange.Price(ctx, t.symbol)
+		if t.limited(tick, "price", err) {
+			continue
+		}
+		if err != nil {
+			t.error(fmt.Errorf("trade: couldn't get %s price: %w", t.symbol, err))
+			continue
+		}
+		t.lastPrice = price
+		if price.LessThan(t.EntryLow) || price.GreaterThan(t.EntryHigh) {
+			continue
+		}
+		// The entry price is used as reference for the buy and the stop
+		// levels
+		t.StartPrice = price
+		return nil
+	}
+}
+
+func (t *Trader) cancelEntry(expired bool) {
+	t.publish(&event.EntryCanceled{
+		Time:    t.clock.Now().UTC(),
+		Trade:   t.ID,
+		Base:    t.Base,
+		Quote:   t.Quote,
+		Expired: expired,
+	})
+}
@@ -0,0 +1,96 @@
+package trade
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/shopspring/decimal"
+)
+
+func TestEntryZone(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		price  float64
+		inc    float64
+		expiry time.Duration
+		err    error
+		start  decimal.Decimal
+	}{
+		// Price goes down 14, 13, 12 and it is bought at 12
+		{name: "enter zone", price: 15, inc: -1, start: decimal.NewFromInt(12)},
+		// Price goes up and never enters the zone
+		{name: "expired", price: 13, inc: 1, expiry: time.Hour, err: ErrEntryExpired},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewVirtual(start)
+			tr := New("IGO", "USDT", decimal.NewFromInt(12), testTargets(), decimal.NewFromInt(9), decimal.NewFromInt(100))
+			tr.StartTime = start
+			tr.EntryLow = decimal.NewFromInt(11)
+			tr.EntryHigh = decimal.NewFromInt(12)
+			if tt.expiry > 0 {
+				tr.EntryExpiry = start.Add(tt.expiry)
+			}
+			ex := &mockExchange{
+				price: decimal.NewFromFloat(tt.price),
+				inc:   decimal.NewFromFloat(tt.inc),
+			}
+			var updates int
+			var events []string
+			publish := func(e event.Event) { events = append(events, e.Name()) }
+			trader := NewTrader(publish, ex, clk, tr, 5, time.Minute, func(t *Trade) error { updates++; return nil }, nil)
+			if !trader.Pending() {
+				t.Fatal("trade should be pending")
+			}
+
+			err := trader.Create(context.Background())
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("want error %v, got %v", tt.err, err)
+				}
+				if !trader.Pending() {
+					t.Error("trade shouldn't be bought")
+				}
+				if want := []string{"entry_pending", "entry_canceled"}; !reflect.DeepEqual(events, want) {
+					t.Errorf("wrong events: want %v, got %v", want, events)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trader.Pending() {
+				t.Error("trade should be bought")
+			}
+			if !tr.StartPrice.Equal(tt.start) {
+				t.Errorf("wrong start price: want %s, got %s", tt.start, tr.StartPrice)
+			}
+			// The pending trade is stored before waiting
+			if updates != 2 {
+				t.Errorf("wrong number of updates: want 2, got %d", updates)
+			}
+			if want := []string{"entry_pending", "buy_filled", "oco_placed"}; !reflect.DeepEqual(events, want) {
+				t.Errorf("wrong events: want %v, got %v", want, events)
+			}
+		})
+	}
+}
+
+func TestEntryCanceled(t *testing.T) {
+	tr := New("IGO", "USDT", decimal.NewFromInt(12), testTargets(), decimal.NewFromInt(9), decimal.NewFromInt(100))
+	tr.EntryLow = decimal.NewFromInt(11)
+	tr.EntryHigh = decimal.NewFromInt(12)
+	ex := &mockExchange{price: decimal.NewFromInt(20)}
+	trader := NewTrader(func(event.Event) {}, ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
+	trader.Sell()
+	if err := trader.Create(context.Background()); !errors.Is(err, ErrEntryCanceled) {
+		t.Fatalf("want error %v, got %v", ErrEntryCanceled, err)
+	}
+}
@@ -7,36 +7,83 @@ import (
 	"github.com/igolaizola/zeken/pkg/trade"
 )
 
+// Store keeps trades in memory, it is safe for concurrent use.
+// Trades are copied when stored and listed, like in persistent stores.
 type Store struct {
-	trades sync.Map
+	trades map[string]*trade.Trade
+	orders map[string][]*trade.OrderEvent
+	lock   sync.Mutex
 }
 
-func (s *Store) List(from time.Time, to time.Time, finished bool) ([]trade.Trade, error) {
-	var trades []trade.Trade
-	s.trades.Range(func(key interface{}, value interface{}) bool {
-		t := value.(trade.Trade)
+func New() *Store {
+	return &Store{
+		trades: make(map[string]*trade.Trade),
+		orders: make(map[string][]*trade.OrderEvent),
+	}
+}
+
+func (s *Store) List(from time.Time, to time.Time, finished bool) ([]*trade.Trade, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var trades []*trade.Trade
+	for _, t := range s.trades {
 		if t.StartTime.Before(from) {
-			return true
+			continue
 		}
 		if t.StartTime.After(to) {
-			return true
+			continue
 		}
 		hasFinished := t.EndTime != time.Time{}
 		if hasFinished != finished {
-			return true
+			continue
+		}
+		trades = append(trades, t.Copy())
+	}
+	return trades, nil
+}
+
+func (s *Store) Ended(from time.Time, to time.Time) ([]*trade.Trade, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var trades []*trade.Trade
+	for _, t := range s.trades {
+		if t.EndTime.IsZero() || t.EndTime.Before(from) || t.EndTime.After(to) {
+			continue
 		}
-		trades = append(trades, t)
-		return true
-	})
+		trades = append(trades, t.Copy())
+	}
 	return trades, nil
 }
 
-func (s *Store) Save(t trade.Trade) error {
-	s.trades.Store(t.StartTime, t)
+func (s *Store) Update(t *trade.Trade) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.trades[t.ID] = t.Copy()
 	return nil
 }
 
-func (s *Store) Delete(t trade.Trade) error {
-	s.trades.Delete(t.StartTime)
+func (s *Store) Delete(t *trade.Trade) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.trades, t.ID)
 	return nil
 }
+
+func (s *Store) AddOrder(e *trade.OrderEvent) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := *e
+	s.orders[e.Trade] = append(s.orders[e.Trade], &c)
+	return nil
+}
+
+func (s *Store) Orders(t *trade.Trade) ([]*trade.OrderEvent, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var events []*trade.OrderEvent
+	for _, e := range s.orders[t.ID] {
+		c := *e
+		events = append(events, &c)
+	}
+	return events, nil
+}
@@ -0,0 +1,34 @@
+package inmem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/igolaizola/zeken/pkg/trade/storetest"
+	"github.com/shopspring/decimal"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) trade.Store {
+		return New()
+	})
+}
+
+func TestCopy(t *testing.T) {
+	s := New()
+	tr := &trade.Trade{ID: "a", Targets: []decimal.Decimal{decimal.NewFromInt(1)}}
+	if err := s.Update(tr); err != nil {
+		t.Fatal(err)
+	}
+	// Changes aren't stored until the trade is updated
+	tr.Targets[0] = decimal.NewFromInt(2)
+	tr.CurrentTarget = 1
+	got, err := s.List(time.Time{}, time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].CurrentTarget != 0 || !got[0].Targets[0].Equal(decimal.NewFromInt(1)) {
+		t.Errorf("stored trade has been modified: %+v", got)
+	}
+}
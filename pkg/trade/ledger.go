@@ -0,0 +1,96 @@
+package trade
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+type OrderEventType string
+
+const (
+	OrderCreated         OrderEventType = "CREATED"
+	OrderCanceled        OrderEventType = "CANCELED"
+	OrderPartiallyFilled OrderEventType = "PARTIALLY_FILLED"
+	OrderFilled          OrderEventType = "FILLED"
+)
+
+type OrderSide string
+
+const (
+	Buy  OrderSide = "BUY"
+	Sell OrderSide = "SELL"
+)
+
+// OrderEvent is an entry of the order ledger of a trade
+type OrderEvent struct {
+	Time time.Time
+	// Trade is the key of the trade the order belongs to
+	Trade       string
+	Type        OrderEventType
+	Side        OrderSide
+	OrderID     string
+	OrderListID string
+	// Price is the limit price of created orders or the average fill price
+	Price         decimal.Decimal
+	StopPrice     decimal.Decimal
+	Quantity      decimal.Decimal
+	QuoteQuantity decimal.Decimal
+	Fee           decimal.Decimal
+	FeeAsset      string
+}
+
+// ExitPrice returns the average price of the filled sell orders
+func ExitPrice(events []*OrderEvent) decimal.Decimal {
+	qty, quoteQty := decimal.Zero, decimal.Zero
+	for _, e := range events {
+		if e.Side != Sell || e.Type != OrderFilled {
+			continue
+		}
+		qty = qty.Add(e.Quantity)
+		quoteQty = quoteQty.Add(e.QuoteQuantity)
+	}
+	if qty.IsZero() {
+		return decimal.Zero
+	}
+	return quoteQty.Div(qty)
+}
+
+// record adds an event to the order ledger
+func (t *Trader) record(e *OrderEvent) {
+	if t.recordOrder == nil {
+		return
+	}
+	e.Time = t.clock.Now().UTC()
+	e.Trade = t.Key()
+	if e.Price.IsZero() && e.Quantity.IsPositive() {
+		e.Price = e.QuoteQuantity.Div(e.Quantity)
+	}
+	if err := t.recordOrder(e); err != nil {
+		t.error(fmt.Errorf("trade: couldn't record %s order: %w", t.Base, err))
+	}
+}
+
+// recordUpdate adds an order update received from the exchange to the ledger
+func (t *Trader) recordUpdate(u exchange.OrderUpdate) {
+	typ := OrderFilled
+	switch u.Status {
+	case exchange.OrderFilled:
+	case exchange.OrderPartiallyFilled:
+		typ = OrderPartiallyFilled
+	default:
+		return
+	}
+	t.record(&OrderEvent{
+		Type:          typ,
+		Side:          Sell,
+		OrderID:       u.OrderID,
+		OrderListID:   t.OrderListID,
+		Quantity:      u.Quantity,
+		QuoteQuantity: u.QuoteQuantity,
+		Fee:           u.Fee,
+		FeeAsset:      u.FeeAsset,
+	})
+}
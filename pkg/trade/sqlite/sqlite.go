@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// Pure go sqlite driver, it doesn't require cgo
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/igolaizola/zeken/pkg/trade"
+)
+
+// SchemaVersion is the schema version stored in the user_version pragma
+const SchemaVersion = 3
+
+// timeLayout has fixed width so times can be compared as text and it is
+// understood by sqlite date functions
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// migrations upgrade the schema, the schema version is the number of
+// migrations applied.
+// Tables contain columns that can be queried with sql, the full trade and
+// order event are also stored as json in the data column.
+var migrations = []string{`
+CREATE TABLE IF NOT EXISTS trades (
+	id TEXT PRIMARY KEY,
+	start_time TEXT NOT NULL,
+	end_time TEXT,
+	base TEXT NOT NULL,
+	quote TEXT NOT NULL,
+	start_price NUMERIC,
+	stop_price NUMERIC,
+	quote_quantity NUMERIC,
+	quantity NUMERIC,
+	end_quote_quantity NUMERIC,
+	profit NUMERIC,
+	current_target INTEGER,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS trades_start_time ON trades (start_time);
+CREATE TABLE IF NOT EXISTS orders (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	trade_id TEXT NOT NULL,
+	time TEXT NOT NULL,
+	type TEXT NOT NULL,
+	side TEXT NOT NULL,
+	order_id TEXT,
+	order_list_id TEXT,
+	price NUMERIC,
+	stop_price NUMERIC,
+	quantity NUMERIC,
+	quote_quantity NUMERIC,
+	fee NUMERIC,
+	fee_asset TEXT,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS orders_trade_id ON orders (trade_id);
+`,
+	`ALTER TABLE trades ADD COLUMN source TEXT;
+CREATE INDEX IF NOT EXISTS trades_source ON trades (source);
+`,
+	`CREATE INDEX IF NOT EXISTS trades_end_time ON trades (end_time);
+`,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+// New opens the sqlite database, it will be created if it doesn't exist
+func New(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)", path))
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: couldn't open db %s: %w", path, err)
+	}
+	// Avoid concurrent writes from multiple connections
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("sqlite: couldn't get schema version: %w", err)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("sqlite: database schema is newer than supported: %d > %d", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("sqlite: couldn't begin migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite: couldn't migrate schema to version %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite: couldn't set schema version: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("sqlite: couldn't commit migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) Close() {
+	s.db.Close()
+}
+
+func (s *Store) List(from time.Time, to time.Time, finished bool) ([]*trade.Trade, error) {
+	return s.query(
+		"SELECT data FROM trades WHERE start_time >= ? AND start_time <= ? AND (end_time IS NOT NULL) = ? ORDER BY start_time",
+		formatTime(from), formatTime(to), finished,
+	)
+}
+
+func (s *Store) Ended(from time.Time, to time.Time) ([]*trade.Trade, error) {
+	return s.query(
+		"SELECT data FROM trades WHERE end_time >= ? AND end_time <= ? ORDER BY end_time",
+		formatTime(from), formatTime(to),
+	)
+}
+
+// query returns the trades decoded from the data column
+func (s *Store) query(query string, args ...interface{}) ([]*trade.Trade, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: couldn't query: %w", err)
+	}
+	defer rows.Close()
+	var trades []*trade.Trade
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("sqlite: couldn't scan: %w", err)
+		}
+		var t trade.Trade
+		if err := json.Unmarshal([]byte(data), &t); err != nil {
+			return nil, fmt.Errorf("sqlite: couldn't decode: %w", err)
+		}
+		trades = append(trades, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: couldn't query: %w", err)
+	}
+	return trades, nil
+}
+
+func (s *Store) Update(t *trade.Trade) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("sqlite: couldn't encode %s: %w", t.ID, err)
+	}
+	var endTime, endQuoteQty, profit interface{}
+	if !t.EndTime.IsZero() {
+		endTime = formatTime(t.EndTime)
+		endQuoteQty = t.EndQuoteQuantity.String()
+		profit = t.EndQuoteQuantity.Sub(t.QuoteQuantity).String()
+	}
+	if _, err := s.db.Exec(`INSERT INTO trades (
+		id, start_time, end_time, base, quote, start_price, stop_price, quote_quantity,
+		quantity, end_quote_quantity, profit, current_target, source, data
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET
+		start_time = excluded.start_time,
+		end_time = excluded.end_time,
+		base = excluded.base,
+		quote = excluded.quote,
+		start_price = excluded.start_price,
+		stop_price = excluded.stop_price,
+		quote_quantity = excluded.quote_quantity,
+		quantity = excluded.quantity,
+		end_quote_quantity = excluded.end_quote_quantity,
+		profit = excluded.profit,
+		current_target = excluded.current_target,
+		source = excluded.source,
+		data = excluded.data`,
+		t.ID, formatTime(t.StartTime), endTime, t.Base, t.Quote, t.StartPrice.String(), t.StopPrice.String(), t.QuoteQuantity.String(),
+		t.Quantity.String(), endQuoteQty, profit, t.CurrentTarget, t.Source, string(data),
+	); err != nil {
+		return fmt.Errorf("sqlite: couldn't put %s: %w", t.ID, err)
+	}
+	return nil
+}
+
+func (s *Store) Delete(t *trade.Trade) error {
+	if _, err := s.db.Exec("DELETE FROM trades WHERE id = ?", t.ID); err != nil {
+		return fmt.Errorf("sqlite: couldn't delete %s: %w", t.ID, err)
+	}
+	return nil
+}
+
+// AddOrder adds an order event to the ledger.
+// Events aren't removed when the trade is deleted, so they can be audited
+// later.
+func (s *Store) AddOrder(e *trade.OrderEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("sqlite: couldn't encode order of %s: %w", e.Trade, err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO orders (
+		trade_id, time, type, side, order_id, order_list_id, price, stop_price,
+		quantity, quote_quantity, fee, fee_asset, data
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Trade, formatTime(e.Time), string(e.Type), string(e.Side), e.OrderID, e.OrderListID, e.Price.String(), e.StopPrice.String(),
+		e.Quantity.String(), e.QuoteQuantity.String(), e.Fee.String(), e.FeeAsset, string(data),
+	); err != nil {
+		return fmt.Errorf("sqlite: couldn't add order of %s: %w", e.Trade, err)
+	}
+	return nil
+}
+
+// Orders returns the order events of a trade in insertion order
+func (s *Store) Orders(t *trade.Trade) ([]*trade.OrderEvent, error) {
+	rows, err := s.db.Query("SELECT data FROM orders WHERE trade_id = ? ORDER BY id", t.ID)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: couldn't get orders of %s: %w", t.ID, err)
+	}
+	defer rows.Close()
+	var events []*trade.OrderEvent
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("sqlite: couldn't scan: %w", err)
+		}
+		var e trade.OrderEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("sqlite: couldn't decode: %w", err)
+		}
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: couldn't get orders of %s: %w", t.ID, err)
+	}
+	return events, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/igolaizola/zeken/pkg/trade/storetest"
+	"github.com/shopspring/decimal"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) trade.Store {
+		s, err := New(filepath.Join(t.TempDir(), "zeken.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		return s
+	})
+}
+
+func TestQuery(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "zeken.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 2021-09-01 is a wednesday
+	start := time.Date(2021, 9, 1, 12, 0, 0, 123456789, time.UTC)
+	for i, profit := range []string{"10.5", "-4.25", "2"} {
+		tr := &trade.Trade{
+			ID:               trade.NewID(),
+			StartTime:        start.Add(time.Duration(i) * time.Hour),
+			EndTime:          start.Add(time.Duration(i+1) * time.Hour),
+			Base:             "IGO",
+			Quote:            "USDT",
+			QuoteQuantity:    decimal.NewFromInt(100),
+			EndQuoteQuantity: decimal.NewFromInt(100).Add(decimal.RequireFromString(profit)),
+		}
+		if err := s.Update(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var weekday string
+	var profit float64
+	if err := s.db.QueryRow("SELECT strftime('%w', start_time), SUM(profit) FROM trades WHERE base = 'IGO' GROUP BY 1").Scan(&weekday, &profit); err != nil {
+		t.Fatal(err)
+	}
+	if weekday != "3" || profit != 8.25 {
+		t.Errorf("wrong query result: want 3 8.25, got %s %v", weekday, profit)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zeken.sqlite")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Create a database with the first schema version
+	if _, err := db.Exec(migrations[0] + "PRAGMA user_version = 1;"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO trades (id, start_time, base, quote, data) VALUES ('abcd', '2021-09-01', 'IGO', 'USDT', '{}')"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("wrong schema version: want %d, got %d", SchemaVersion, version)
+	}
+	tr := &trade.Trade{ID: "abcd", StartTime: time.Now(), Base: "IGO", Quote: "USDT", Source: "vip"}
+	if err := s.Update(tr); err != nil {
+		t.Fatal(err)
+	}
+	var source string
+	if err := s.db.QueryRow("SELECT source FROM trades WHERE id = 'abcd'").Scan(&source); err != nil {
+		t.Fatal(err)
+	}
+	if source != "vip" {
+		t.Errorf("wrong source: want vip, got %s", source)
+	}
+}
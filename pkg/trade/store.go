@@ -4,6 +4,12 @@ import "time"
 
 type Store interface {
 	List(from time.Time, to time.Time, finished bool) ([]*Trade, error)
+	// Ended returns the finished trades with an end time between from and to
+	Ended(from time.Time, to time.Time) ([]*Trade, error)
 	Update(*Trade) error
 	Delete(*Trade) error
+	// AddOrder adds an event to the order ledger of a trade
+	AddOrder(*OrderEvent) error
+	// Orders returns the order ledger of a trade sorted by time
+	Orders(*Trade) ([]*OrderEvent, error)
 }
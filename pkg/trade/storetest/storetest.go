@@ -0,0 +1,346 @@
+// Package storetest provides a conformance suite for trade.Store
+// implementations.
+package storetest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/shopspring/decimal"
+)
+
+// Run runs the conformance suite using a new empty store for each test
+func Run(t *testing.T, newStore func(t *testing.T) trade.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s trade.Store)
+	}{
+		{"List", testList},
+		{"Ended", testEnded},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"Orders", testOrders},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+var start = time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+// newTrade returns a trade started the given number of hours after start
+func newTrade(id string, hours int, finished bool) *trade.Trade {
+	t := &trade.Trade{
+		ID:            id,
+		StartTime:     start.Add(time.Duration(hours) * time.Hour),
+		Base:          "IGO",
+		Quote:         "USDT",
+		StartPrice:    decimal.RequireFromString("10.5"),
+		Targets:       []decimal.Decimal{decimal.RequireFromString("11.25"), decimal.RequireFromString("12")},
+		StopPrice:     decimal.RequireFromString("9.75"),
+		QuoteQuantity: decimal.RequireFromString("100"),
+		Quantity:      decimal.RequireFromString("9.52380952"),
+		OrderIDs:      []string{"1", "2"},
+		OrderListID:   "3",
+	}
+	if finished {
+		t.EndTime = t.StartTime.Add(30 * time.Minute)
+		t.EndQuoteQuantity = decimal.RequireFromString("107.1428571")
+	}
+	return t
+}
+
+func testList(t *testing.T, s trade.Store) {
+	trades := []*trade.Trade{
+		newTrade("a", 0, true),
+		newTrade("b", 1, false),
+		newTrade("c", 2, true),
+		newTrade("d", 3, false),
+		newTrade("e", 48, true),
+	}
+	for _, tr := range trades {
+		if err := s.Update(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		from, to time.Time
+		finished bool
+		want     []string
+	}{
+		{"all finished", start.Add(-time.Hour), start.Add(100 * time.Hour), true, []string{"a", "c", "e"}},
+		{"all open", start.Add(-time.Hour), start.Add(100 * time.Hour), false, []string{"b", "d"}},
+		{"inclusive range", start, start.Add(2 * time.Hour), true, []string{"a", "c"}},
+		{"partial range", start.Add(90 * time.Minute), start.Add(24 * time.Hour), false, []string{"d"}},
+		{"empty range", start.Add(4 * time.Hour), start.Add(24 * time.Hour), true, nil},
+	}
+	for _, tt := range tests {
+		got, err := s.List(tt.from, tt.to, tt.finished)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := ids(got); !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, ids)
+		}
+	}
+
+	// Trades are decoded with the same values
+	got, err := s.List(start, start, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("wrong number of trades: want 1, got %d", len(got))
+	}
+	assertTrade(t, got[0], trades[0])
+}
+
+func testEnded(t *testing.T, s trade.Store) {
+	// A trade that started long before the others but ended later
+	old := newTrade("old", -24*60, true)
+	old.EndTime = start.Add(3 * time.Hour)
+	trades := []*trade.Trade{
+		old,
+		newTrade("a", 0, true),
+		newTrade("b", 1, false),
+		newTrade("c", 2, true),
+	}
+	for _, tr := range trades {
+		if err := s.Update(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"all", start.Add(-100 * 24 * time.Hour), start.Add(100 * time.Hour), []string{"a", "c", "old"}},
+		{"inclusive range", start.Add(30 * time.Minute), start.Add(150 * time.Minute), []string{"a", "c"}},
+		{"started before range", start.Add(time.Hour), start.Add(24 * time.Hour), []string{"c", "old"}},
+		{"empty range", start.Add(4 * time.Hour), start.Add(24 * time.Hour), nil},
+	}
+	for _, tt := range tests {
+		got, err := s.Ended(tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := ids(got); !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, ids)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, s trade.Store) {
+	tr := newTrade("a", 0, false)
+	for i := 0; i < 2; i++ {
+		if err := s.Update(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr.CurrentTarget = 1
+	tr.StopPrice = decimal.RequireFromString("10.5")
+	tr.Fills = []trade.Fill{{
+		Time:          start.Add(time.Minute),
+		Target:        1,
+		Quantity:      decimal.RequireFromString("2.5"),
+		QuoteQuantity: decimal.RequireFromString("28.125"),
+	}}
+	if err := s.Update(tr); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.List(start, start, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("wrong number of trades after updates: want 1, got %d", len(got))
+	}
+	assertTrade(t, got[0], tr)
+
+	// Finished trades aren't listed as open
+	tr.EndTime = start.Add(time.Hour)
+	tr.EndQuoteQuantity = decimal.RequireFromString("110")
+	if err := s.Update(tr); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.List(start, start, false); err != nil || len(got) != 0 {
+		t.Errorf("finished trade listed as open: %v %v", ids(got), err)
+	}
+	if got, err := s.List(start, start, true); err != nil || len(got) != 1 {
+		t.Errorf("finished trade not listed: %v %v", ids(got), err)
+	}
+}
+
+func testDelete(t *testing.T, s trade.Store) {
+	a, b := newTrade("a", 0, false), newTrade("b", 0, false)
+	for _, tr := range []*trade.Trade{a, b} {
+		if err := s.Update(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete(a); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.List(start, start, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := ids(got); !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Errorf("wrong trades after delete: %v", ids)
+	}
+	// Deleting a missing trade isn't an error
+	if err := s.Delete(a); err != nil {
+		t.Errorf("couldn't delete missing trade: %v", err)
+	}
+}
+
+func testOrders(t *testing.T, s trade.Store) {
+	a, b := newTrade("a", 0, false), newTrade("b", 0, false)
+	events := []*trade.OrderEvent{
+		{Time: start, Trade: "a", Type: trade.OrderFilled, Side: trade.Buy, Price: decimal.RequireFromString("10.5"), Quantity: decimal.RequireFromString("9.52380952"), QuoteQuantity: decimal.RequireFromString("100")},
+		{Time: start, Trade: "b", Type: trade.OrderFilled, Side: trade.Buy},
+		{Time: start, Trade: "a", Type: trade.OrderCreated, Side: trade.Sell, OrderListID: "3", Price: decimal.RequireFromString("12"), StopPrice: decimal.RequireFromString("9.75")},
+		{Time: start.Add(time.Minute), Trade: "a", Type: trade.OrderFilled, Side: trade.Sell, OrderID: "2", OrderListID: "3", Quantity: decimal.RequireFromString("9.52380952"), QuoteQuantity: decimal.RequireFromString("114.2857142"), Fee: decimal.RequireFromString("0.1142857"), FeeAsset: "USDT"},
+	}
+	for _, e := range events {
+		if err := s.AddOrder(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.Orders(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*trade.OrderEvent{events[0], events[2], events[3]}
+	if len(got) != len(want) {
+		t.Fatalf("wrong number of orders: want %d, got %d", len(want), len(got))
+	}
+	for i := range want {
+		assertOrder(t, got[i], want[i])
+	}
+	got, err = s.Orders(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("wrong number of orders: want 1, got %d", len(got))
+	}
+	got, err = s.Orders(newTrade("missing", 0, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("orders of missing trade: %d", len(got))
+	}
+}
+
+func testConcurrent(t *testing.T, s trade.Store) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		tr := newTrade(fmt.Sprintf("t%d", i), i, false)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2; j++ {
+				if err := s.Update(tr); err != nil {
+					errs <- err
+				}
+				if err := s.AddOrder(&trade.OrderEvent{Time: start, Trade: tr.ID, Type: trade.OrderCreated, Side: trade.Sell}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	got, err := s.List(start, start.Add(24*time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 {
+		t.Errorf("wrong number of trades: want 10, got %d", len(got))
+	}
+	for _, tr := range got {
+		events, err := s.Orders(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 {
+			t.Errorf("wrong number of orders of %s: want 2, got %d", tr.ID, len(events))
+		}
+	}
+}
+
+func ids(trades []*trade.Trade) []string {
+	var ids []string
+	for _, t := range trades {
+		ids = append(ids, t.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func assertTrade(t *testing.T, got, want *trade.Trade) {
+	t.Helper()
+	if got.ID != want.ID || got.Base != want.Base || got.Quote != want.Quote ||
+		!got.StartTime.Equal(want.StartTime) || !got.EndTime.Equal(want.EndTime) ||
+		got.OrderListID != want.OrderListID || !reflect.DeepEqual(got.OrderIDs, want.OrderIDs) ||
+		got.CurrentTarget != want.CurrentTarget || len(got.Targets) != len(want.Targets) ||
+		len(got.Fills) != len(want.Fills) {
+		t.Fatalf("wrong trade:\nwant %+v\ngot  %+v", want, got)
+	}
+	decimals := [][2]decimal.Decimal{
+		{got.StartPrice, want.StartPrice},
+		{got.StopPrice, want.StopPrice},
+		{got.QuoteQuantity, want.QuoteQuantity},
+		{got.Quantity, want.Quantity},
+		{got.EndQuoteQuantity, want.EndQuoteQuantity},
+	}
+	for i := range got.Targets {
+		decimals = append(decimals, [2]decimal.Decimal{got.Targets[i], want.Targets[i]})
+	}
+	for i := range got.Fills {
+		decimals = append(decimals, [2]decimal.Decimal{got.Fills[i].QuoteQuantity, want.Fills[i].QuoteQuantity})
+	}
+	for _, d := range decimals {
+		if !d[0].Equal(d[1]) {
+			t.Errorf("wrong trade value: want %s, got %s", d[1], d[0])
+		}
+	}
+}
+
+func assertOrder(t *testing.T, got, want *trade.OrderEvent) {
+	t.Helper()
+	if got.Trade != want.Trade || got.Type != want.Type || got.Side != want.Side ||
+		got.OrderID != want.OrderID || got.OrderListID != want.OrderListID ||
+		got.FeeAsset != want.FeeAsset || !got.Time.Equal(want.Time) {
+		t.Fatalf("wrong order:\nwant %+v\ngot  %+v", want, got)
+	}
+	decimals := [][2]decimal.Decimal{
+		{got.Price, want.Price},
+		{got.StopPrice, want.StopPrice},
+		{got.Quantity, want.Quantity},
+		{got.QuoteQuantity, want.QuoteQuantity},
+		{got.Fee, want.Fee},
+	}
+	for _, d := range decimals {
+		if !d[0].Equal(d[1]) {
+			t.Errorf("wrong order value: want %s, got %s", d[1], d[0])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package trade
+
+import (
+	"context"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// streamPollWait is the polling wait used as safety net while streams
+	// are active
+	streamPollWait = time.Minute
+	// subscribeWait is the minimum wait between subscription attempts
+	subscribeWait = time.Minute
+)
+
+// stream handles price and order subscriptions of a trader
+type stream struct {
+	streamer   exchange.Streamer
+	symbol     string
+	prices     <-chan decimal.Decimal
+	orders     <-chan exchange.OrderUpdate
+	cancel     context.CancelFunc
+	subscribed time.Time
+}
+
+func newStream(ex exchange.Exchange, symbol string) *stream {
+	streamer, ok := ex.(exchange.Streamer)
+	if !ok {
+		return nil
+	}
+	return &stream{
+		streamer: streamer,
+		symbol:   symbol,
+	}
+}
+
+// subscribe subscribes to price and order streams if they aren't active.
+// It returns true if a new subscription has been made.
+func (s *stream) subscribe(ctx context.Context, now time.Time) (bool, error) {
+	if s == nil || s.active() {
+		return false, nil
+	}
+	if now.Sub(s.subscribed) < subscribeWait {
+		return false, nil
+	}
+	s.subscribed = now
+	ctx, cancel := context.WithCancel(ctx)
+	prices, err := s.streamer.SubscribePrice(ctx, s.symbol)
+	if err != nil {
+		cancel()
+		return false, err
+	}
+	orders, err := s.streamer.SubscribeOrders(ctx, s.symbol)
+	if err != nil {
+		cancel()
+		return false, err
+	}
+	s.prices, s.orders, s.cancel = prices, orders, cancel
+	return true, nil
+}
+
+func (s *stream) active() bool {
+	return s != nil && s.cancel != nil
+}
+
+// close stops the subscriptions
+func (s *stream) close() {
+	if !s.active() {
+		return
+	}
+	s.cancel()
+	s.prices, s.orders, s.cancel = nil, nil, nil
+}
+
+// priceUpdates returns the price channel, it is nil if the stream isn't active
+func (s *stream) priceUpdates() <-chan decimal.Decimal {
+	if s == nil {
+		return nil
+	}
+	return s.prices
+}
+
+// orderUpdates returns the order channel, it is nil if the stream isn't active
+func (s *stream) orderUpdates() <-chan exchange.OrderUpdate {
+	if s == nil {
+		return nil
+	}
+	return s.orders
+}
@@ -0,0 +1,154 @@
+package trade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+// Fill is a partial sell of the trade quantity
+type Fill struct {
+	Time          time.Time
+	Target        int
+	Quantity      decimal.Decimal
+	QuoteQuantity decimal.Decimal
+}
+
+// ParseAllocation parses a comma separated list of percentages of the bought
+// quantity to be sold at each target, e.g. `25,25,25,25`.
+// It returns nil if the value is empty.
+func ParseAllocation(value string) ([]decimal.Decimal, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	var allocation []decimal.Decimal
+	for _, v := range strings.Split(value, ",") {
+		perc, err := decimal.NewFromString(strings.TrimSuffix(strings.TrimSpace(v), "%"))
+		if err != nil {
+			return nil, fmt.Errorf("trade: couldn't parse allocation %s: %w", value, err)
+		}
+		allocation = append(allocation, perc.Div(decimal.NewFromInt(100)))
+	}
+	if err := ValidateAllocation(allocation); err != nil {
+		return nil, err
+	}
+	return allocation, nil
+}
+
+// ValidateAllocation checks that allocation ratios are positive and don't
+// exceed the total quantity
+func ValidateAllocation(allocation []decimal.Decimal) error {
+	total := decimal.Zero
+	for _, a := range allocation {
+		if a.IsNegative() {
+			return fmt.Errorf("trade: allocation can't be negative: %s", a)
+		}
+		total = total.Add(a)
+	}
+	if total.GreaterThan(decimal.NewFromInt(1)) {
+		return fmt.Errorf("trade: allocation exceeds 100%%: %s%%", total.Mul(decimal.NewFromInt(100)))
+	}
+	return nil
+}
+
+// Remaining returns the quantity that hasn't been sold yet
+func (t *Trade) Remaining() decimal.Decimal {
+	remaining := t.Quantity
+	for _, f := range t.Fills {
+		remaining = remaining.Sub(f.Quantity)
+	}
+	return remaining
+}
+
+// Filled returns the quote quantity obtained from partial sells
+func (t *Trade) Filled() decimal.Decimal {
+	filled := decimal.Zero
+	for _, f := range t.Fills {
+		filled = filled.Add(f.QuoteQuantity)
+	}
+	return filled
+}
+
+// Value returns the quote quantity of the trade at the given price, including
+// partial sells
+func (t *Trade) Value(price decimal.Decimal) decimal.Decimal {
+	return t.Filled().Add(t.Remaining().Mul(price))
+}
+
+// takeProfitQuantity returns the quantity to be sold when the target index is
+// reached
+func (t *Trade) takeProfitQuantity(target int) decimal.Decimal {
+	if target >= len(t.Allocation) {
+		return decimal.Zero
+	}
+	qty := t.Quantity.Mul(t.Allocation[target])
+	if remaining := t.Remaining(); qty.GreaterThan(remaining) {
+		qty = remaining
+	}
+	return qty
+}
+
+// takeProfit sells part of the quantity and records the fill
+func (t *Trader) takeProfit(ctx context.Context, qty decimal.Decimal) error {
+	var nerr int
+	tick := newTicker(t.clock, 5*time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C():
+		}
+		quoteQty, err := t.exchange.Sell(ctx, t.symbol, qty)
+		var netErr net.Error
+		if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
+			continue
+		}
+		// The quantity is kept and sold with the rest of the trade
+		if errors.Is(err, exchange.ErrDust) {
+			t.warn(fmt.Sprintf("%s take profit quantity %s is too small to be sold, skipping: %v", t.symbol, qty, err))
+			return nil
+		}
+		if t.limited(tick, "take_profit", err) {
+			continue
+		}
+		if err != nil {
+			err := fmt.Errorf("trade: couldn't take profit %s: %w", t.Base, err)
+			nerr++
+			if nerr > 100 {
+				return err
+			}
+			t.retry("take_profit", err)
+			continue
+		}
+		t.record(&OrderEvent{
+			Type:          OrderFilled,
+			Side:          Sell,
+			Quantity:      qty,
+			QuoteQuantity: quoteQty,
+		})
+		t.Fills = append(t.Fills, Fill{
+			Time:          t.clock.Now().UTC(),
+			Target:        t.CurrentTarget,
+			Quantity:      qty,
+			QuoteQuantity: quoteQty,
+		})
+		t.publish(&event.TakeProfitFilled{
+			Time:          t.clock.Now().UTC(),
+			Trade:         t.ID,
+			Base:          t.Base,
+			Quote:         t.Quote,
+			Target:        t.CurrentTarget,
+			Quantity:      qty,
+			QuoteQuantity: quoteQty,
+		})
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/shopspring/decimal"
+)
+
+func TestParseAllocation(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []float64
+		err   bool
+	}{
+		{value: "", want: nil},
+		{value: "25,25,25,25", want: []float64{0.25, 0.25, 0.25, 0.25}},
+		{value: "50%, 0, 30%", want: []float64{0.5, 0, 0.3}},
+		{value: "60,60", err: true},
+		{value: "-10,50", err: true},
+		{value: "a,b", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAllocation(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.value, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.value, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(decimal.NewFromFloat(tt.want[i])) {
+				t.Errorf("%s: want %v, got %v", tt.value, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestTakeProfit(t *testing.T) {
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	klines := newTrailingSeries("IGOUSDT", start, 10, 11, 12, 13, 14, 14)
+	clk := clock.NewVirtual(start)
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(100)})
+
+	targets := []decimal.Decimal{decimal.NewFromInt(11), decimal.NewFromInt(12), decimal.NewFromInt(13), decimal.NewFromInt(14)}
+	tr := New("IGO", "USDT", decimal.NewFromInt(10), targets, decimal.NewFromInt(9), decimal.NewFromInt(100))
+	var err error
+	tr.Allocation, err = ParseAllocation("25,25,25,25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*OrderEvent
+	var published []string
+	print := event.Printer(log.Println)
+	publish := func(e event.Event) {
+		published = append(published, e.Name())
+		print(e)
+	}
+	trader := NewTrader(publish, ex, clk, tr, 5, 5*time.Second, func(t *Trade) error { return nil }, func(e *OrderEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err := trader.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := trader.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Fills) != 3 {
+		t.Fatalf("wrong number of fills: want 3, got %d", len(tr.Fills))
+	}
+	for i, f := range tr.Fills {
+		if f.Target != i+1 || !f.Quantity.Equal(decimal.NewFromFloat(2.5)) {
+			t.Errorf("wrong fill %d: %+v", i, f)
+		}
+	}
+	// 2.5 sold at 11, 12 and 13 and the last 2.5 at the last target 14
+	if want := decimal.NewFromInt(125); !tr.EndQuoteQuantity.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, tr.EndQuoteQuantity)
+	}
+
+	// Ledger contains the buy, the partial sells and the order lists
+	var types []string
+	for _, e := range events {
+		if e.Trade != tr.Key() {
+			t.Errorf("wrong trade key: %s", e.Trade)
+		}
+		types = append(types, fmt.Sprintf("%s %s", e.Side, e.Type))
+	}
+	want := []string{"BUY FILLED"}
+	for i := 0; i < 3; i++ {
+		want = append(want, "SELL CREATED", "SELL CANCELED", "SELL FILLED")
+	}
+	want = append(want, "SELL CREATED", "SELL FILLED")
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("wrong ledger:\nwant %v\ngot  %v", want, types)
+	}
+
+	// Lifecycle events are published for each target
+	wantPublished := []string{"buy_filled", "oco_placed"}
+	for i := 0; i < 3; i++ {
+		wantPublished = append(wantPublished, "oco_canceled", "target_reached", "take_profit_filled", "oco_placed")
+	}
+	if !reflect.DeepEqual(published, wantPublished) {
+		t.Errorf("wrong events:\nwant %v\ngot  %v", wantPublished, published)
+	}
+	if want := decimal.NewFromFloat(12.5); !ExitPrice(events).Equal(want) {
+		t.Errorf("wrong exit price: want %s, got %s", want, ExitPrice(events))
+	}
+
+	balance, err := ex.Balance(context.Background(), "USDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balance.Equal(tr.EndQuoteQuantity) {
+		t.Errorf("wrong balance: want %s, got %s", tr.EndQuoteQuantity, balance)
+	}
+}
+
+func TestTradeValue(t *testing.T) {
+	tr := &Trade{
+		Quantity: decimal.NewFromInt(10),
+		Fills: []Fill{
+			{Quantity: decimal.NewFromInt(4), QuoteQuantity: decimal.NewFromInt(48)},
+		},
+	}
+	if want := decimal.NewFromInt(6); !tr.Remaining().Equal(want) {
+		t.Errorf("wrong remaining: want %s, got %s", want, tr.Remaining())
+	}
+	if want := decimal.NewFromInt(108); !tr.Value(decimal.NewFromInt(10)).Equal(want) {
+		t.Errorf("wrong value: want %s, got %s", want, tr.Value(decimal.NewFromInt(10)))
+	}
+}
@@ -2,16 +2,22 @@ package trade
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
 	"github.com/igolaizola/zeken/pkg/exchange"
 	"github.com/shopspring/decimal"
 )
 
 type Trade struct {
+	ID               string
 	StartTime        time.Time
 	Base             string
 	Quote            string
@@ -22,14 +28,28 @@ type Trade struct {
 	Quantity         decimal.Decimal
 	EndQuoteQuantity decimal.Decimal
 	EndTime          time.Time
-	// TODO(igolaizola): change implementation to have a single ID
-	OrderIDs      []string
-	OrderListID   string
-	CurrentTarget int
+	OrderIDs         []string
+	OrderListID      string
+	CurrentTarget    int
+	Trailing         *TrailingStop
+	// Allocation is the ratio of the quantity to be sold at each target
+	Allocation []decimal.Decimal
+	Fills      []Fill
+	// Source is the name of the signal source that started the trade
+	Source string
+	// SignalID is the id of the source message that started the trade, it
+	// links follow-up messages with the trade
+	SignalID string
+	// EntryLow and EntryHigh are the entry zone, the trade is pending until
+	// the price is in the zone
+	EntryLow    decimal.Decimal
+	EntryHigh   decimal.Decimal
+	EntryExpiry time.Time
 }
 
 func New(base, quote string, start decimal.Decimal, targets []decimal.Decimal, stop, quoteQuantity decimal.Decimal) *Trade {
 	return &Trade{
+		ID:            NewID(),
 		StartTime:     time.Now().UTC(),
 		Base:          base,
 		Quote:         quote,
@@ -40,94 +60,290 @@ func New(base, quote string, start decimal.Decimal, targets []decimal.Decimal, s
 	}
 }
 
+// NewID generates a random trade ID, it is long enough to avoid collisions
+// because stores overwrite trades with the same ID
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("trade: couldn't generate id: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Key returns the key that identifies the trade
+func (t *Trade) Key() string {
+	return t.ID
+}
+
+// Copy returns a copy of the trade that doesn't share slices
+func (t *Trade) Copy() *Trade {
+	c := *t
+	c.Targets = append([]decimal.Decimal(nil), t.Targets...)
+	c.OrderIDs = append([]string(nil), t.OrderIDs...)
+	c.Allocation = append([]decimal.Decimal(nil), t.Allocation...)
+	c.Fills = append([]Fill(nil), t.Fills...)
+	c.Trailing = t.Trailing.Copy()
+	return &c
+}
+
+// Snapshot is a copy of the trade state taken by the trader, it must not be
+// modified
+type Snapshot struct {
+	*Trade
+	// Price is the last price received, it is zero until the first one
+	Price decimal.Decimal
+}
+
+// Status returns the profit, the profit ratio and the elapsed time of the
+// trade at the given time
+func (s *Snapshot) Status(now time.Time) (decimal.Decimal, decimal.Decimal, time.Duration) {
+	elapsed := now.Sub(s.StartTime)
+	if s.Pending() {
+		return decimal.Zero, decimal.Zero, elapsed
+	}
+	// The start price is used until the first price is received
+	price := s.Price
+	if price.IsZero() {
+		price = s.StartPrice
+	}
+	currentQuoteQuantity := s.Value(price)
+	profit := currentQuoteQuantity.Sub(s.QuoteQuantity)
+	percentage := profit.Div(s.QuoteQuantity)
+	return profit, percentage, elapsed
+}
+
 type Trader struct {
 	*Trade
-	lastPrice decimal.Decimal
-	symbol    string
-	log       func(v ...interface{})
-	exchange  exchange.Exchange
-	sell      chan struct{}
-	maxTarget int
-	wait      time.Duration
-	update    func(t *Trade) error
+	lastPrice   decimal.Decimal
+	symbol      string
+	publish     func(e event.Event)
+	exchange    exchange.Exchange
+	clock       clock.Clock
+	sell        chan struct{}
+	sellOnce    sync.Once
+	adjust      chan adjustment
+	maxTarget   int
+	wait        time.Duration
+	update      func(t *Trade) error
+	recordOrder func(e *OrderEvent) error
+	// The trade is only modified by the trader, other goroutines read the
+	// snapshot it takes before each wait
+	lock     sync.Mutex
+	snapshot *Snapshot
 }
 
-func NewTrader(log func(v ...interface{}), ex exchange.Exchange, t *Trade, maxTarget int, wait time.Duration, update func(t *Trade) error) *Trader {
-	return &Trader{
-		Trade:     t,
-		symbol:    ex.Symbol(t.Base, t.Quote),
-		log:       log,
-		exchange:  ex,
-		sell:      make(chan struct{}),
-		maxTarget: maxTarget,
-		wait:      wait,
-		update:    update,
+// NewTrader creates a trader that publishes its lifecycle events, record is
+// used to add order events to the ledger and may be nil
+func NewTrader(publish func(e event.Event), ex exchange.Exchange, clk clock.Clock, t *Trade, maxTarget int, wait time.Duration, update func(t *Trade) error, record func(e *OrderEvent) error) *Trader {
+	tr := &Trader{
+		Trade:       t,
+		symbol:      ex.Symbol(t.Base, t.Quote),
+		publish:     publish,
+		exchange:    ex,
+		clock:       clk,
+		sell:        make(chan struct{}),
+		adjust:      make(chan adjustment, 1),
+		maxTarget:   maxTarget,
+		wait:        wait,
+		update:      update,
+		recordOrder: record,
 	}
+	tr.sync()
+	return tr
 }
 
+// Create buys the trade quantity and creates the sell orders, trades with an
+// entry zone wait until the price is in the zone
 func (t *Trader) Create(ctx context.Context) error {
+	defer t.sync()
+	if t.HasEntryZone() {
+		if err := t.waitEntry(ctx); err != nil {
+			return err
+		}
+	}
 	if err := t.buy(ctx); err != nil {
 		return err
 	}
+	if t.Trailing != nil {
+		t.initTrailingStop(ctx)
+	}
 	lower := t.StopPrice
 	upper := t.Targets[len(t.Targets)-1]
-	if err := t.createStopLimit(ctx, upper, lower); err != nil {
-		defer t.log(fmt.Sprintf("⚠️ Warning! %s has been bought, but order creation failed. You must sell it manually", t.symbol))
+	if err := t.createStopLimit(ctx, upper, lower); errors.Is(err, exchange.ErrDust) {
+		return t.dust(err)
+	} else if err != nil {
+		defer t.warn(fmt.Sprintf("Warning! %s has been bought, but order creation failed. You must sell it manually", t.symbol))
 		return fmt.Errorf("trade: couldn't create order for %s: %w", t.symbol, err)
 	}
 	if err := t.update(t.Trade); err != nil {
-		t.log("trade: couldn't update %s: %w", t.Base, err)
+		t.error(fmt.Errorf("trade: couldn't update %s: %w", t.Base, err))
 	}
 	return nil
 }
 
 func (t *Trader) Run(ctx context.Context) error {
-	lower := t.StopPrice
+	defer t.sync()
+	// The trade may have been finished while it was created
+	if !t.EndTime.IsZero() {
+		return nil
+	}
 	idx := len(t.Targets) - 1
 	if t.maxTarget-1 < idx {
 		idx = t.maxTarget - 1
 	}
 	upper := t.Targets[idx]
-	previous := t.StartPrice
 	target := t.Targets[t.CurrentTarget]
 
-	tick, update := ticker(t.wait)
+	// Restore stop levels of the current target
+	lower := t.StopPrice
+	previous := t.StartPrice
+	if t.CurrentTarget > 0 {
+		lower = t.StartPrice
+		previous = t.Targets[t.CurrentTarget-1]
+	}
+	if t.CurrentTarget > 1 {
+		lower = t.Targets[t.CurrentTarget-2]
+	}
+	if t.Trailing != nil && t.Trailing.Stop.GreaterThan(lower) {
+		lower = t.Trailing.Stop
+	}
+	// The stop may have been moved by a follow-up
+	if t.StopPrice.GreaterThan(lower) {
+		lower = t.StopPrice
+	}
+
+	// Use price and order streams if available, polling is used as fallback
+	st := newStream(t.exchange, t.symbol)
+	defer st.close()
+	tick := newTicker(t.clock, t.wait)
+	var tickC <-chan time.Time
 
 	var canceled bool
 	var forceSell bool
 	for {
+		t.sync()
+		if ok, err := st.subscribe(ctx, t.clock.Now()); err != nil {
+			t.error(fmt.Errorf("trade: couldn't subscribe to %s streams, polling: %w", t.symbol, err))
+		} else if ok {
+			tick.wait = streamPollWait
+			tickC = nil
+		}
+		if tickC == nil {
+			tickC = tick.C()
+		}
+
+		var price decimal.Decimal
+		var poll bool
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-tick:
+		case <-tickC:
+			tickC = nil
+			poll = true
 		case <-t.sell:
 			forceSell = true
-		}
-		tick = update
-
-		// Check if orders have been completed
-		ok, endQuoteQty, err := t.status(ctx, t.OrderIDs)
-		if err != nil {
-			return err
-		}
-		if ok {
-			t.EndQuoteQuantity = endQuoteQty
-			t.EndTime = time.Now().UTC()
+			poll = true
+		case a := <-t.adjust:
+			if !canceled {
+				if err := t.cancelStopLimit(ctx); err != nil {
+					return err
+				}
+			}
+			canceled = true
+			if len(a.targets) > 0 {
+				t.Targets = a.targets
+				if t.CurrentTarget > len(t.Targets)-1 {
+					t.CurrentTarget = len(t.Targets) - 1
+				}
+				idx := len(t.Targets) - 1
+				if t.maxTarget-1 < idx {
+					idx = t.maxTarget - 1
+				}
+				upper = t.Targets[idx]
+				target = t.Targets[t.CurrentTarget]
+			}
+			if a.stop.IsPositive() {
+				t.StopPrice = a.stop
+				lower = a.stop
+			}
+			t.publish(&event.TradeUpdated{
+				Time:      t.clock.Now().UTC(),
+				Trade:     t.ID,
+				Base:      t.Base,
+				StopPrice: lower,
+				Targets:   t.Targets,
+			})
+			// A stop at or above the price is already hit, so the trade is
+			// sold instead of creating orders the exchange would reject
+			if a.stop.IsPositive() && t.lastPrice.IsPositive() && a.stop.GreaterThanOrEqual(t.lastPrice) {
+				t.warn(fmt.Sprintf("%s stop %s is above the price %s, selling", t.symbol, a.stop, t.lastPrice))
+				forceSell = true
+				price = t.lastPrice
+				break
+			}
+			if err := t.createStopLimit(ctx, upper, lower); err != nil {
+				return t.dust(err)
+			}
 			if err := t.update(t.Trade); err != nil {
-				t.log("trade: couldn't update %s: %w", t.Base, err)
+				t.error(fmt.Errorf("trade: couldn't update %s: %w", t.Base, err))
 			}
+			canceled = false
+			continue
+		case p, ok := <-st.priceUpdates():
+			if !ok {
+				t.fallback(st, tick)
+				tickC = nil
+				continue
+			}
+			price = p
+		case u, ok := <-st.orderUpdates():
+			if !ok {
+				t.fallback(st, tick)
+				tickC = nil
+				continue
+			}
+			if !contains(t.OrderIDs, u.OrderID) {
+				continue
+			}
+			t.recordUpdate(u)
+			if u.Status != exchange.OrderFilled {
+				continue
+			}
+			t.finish(u.QuoteQuantity)
 			return nil
 		}
 
-		// Check price
-		price, err := t.exchange.Price(ctx, t.symbol)
-		var netErr net.Error
-		if errors.As(err, &netErr) && netErr.Timeout() {
-			continue
-		}
-		if err != nil {
-			t.log(fmt.Sprintf("%v (%T)", err, err))
-			continue
+		if poll {
+			// Check if orders have been completed
+			ok, endQuoteQty, err := t.status(ctx, t.OrderIDs)
+			if err != nil {
+				return err
+			}
+			if ok {
+				t.record(&OrderEvent{
+					Type:          OrderFilled,
+					Side:          Sell,
+					OrderListID:   t.OrderListID,
+					Quantity:      t.Remaining(),
+					QuoteQuantity: endQuoteQty,
+				})
+				t.finish(endQuoteQty)
+				return nil
+			}
+
+			// Check price
+			price, err = t.exchange.Price(ctx, t.symbol)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if t.limited(tick, "price", err) {
+				continue
+			}
+			if err != nil {
+				t.error(fmt.Errorf("trade: couldn't get %s price: %w (%T)", t.symbol, err, err))
+				continue
+			}
 		}
 		t.lastPrice = price
 
@@ -135,28 +351,54 @@ func (t *Trader) Run(ctx context.Context) error {
 		if forceSell || price.LessThan(lower.Mul(decimal.NewFromFloat(0.99))) || price.GreaterThan(upper.Mul(decimal.NewFromFloat(1.01))) {
 			if !canceled {
 				if err := t.cancelStopLimit(ctx); err != nil {
-					t.log(err)
+					t.error(err)
 					continue
 				}
 			}
 			canceled = true
 			if err := t.forceSell(ctx); err != nil {
-				t.log(err)
+				t.error(err)
 				continue
 			}
 			if err := t.update(t.Trade); err != nil {
-				t.log("trade: couldn't update %s: %w", t.Base, err)
+				t.error(fmt.Errorf("trade: couldn't update %s: %w", t.Base, err))
 			}
 			return nil
 		}
 
+		lastTarget := t.CurrentTarget >= t.maxTarget-1 || t.CurrentTarget >= len(t.Targets)-1
+
+		// Move trailing stop if price is a new high
+		if t.Trailing != nil && (price.LessThan(target) || lastTarget) {
+			if stop, ok := t.Trailing.next(price, lower); ok {
+				if err := t.cancelStopLimit(ctx); err != nil {
+					return err
+				}
+				lower = stop
+				t.Trailing.Stop = stop
+				t.publish(&event.StopMoved{
+					Time:      t.clock.Now().UTC(),
+					Trade:     t.ID,
+					Base:      t.Base,
+					StopPrice: stop,
+				})
+				if err := t.createStopLimit(ctx, upper, lower); err != nil {
+					return t.dust(err)
+				}
+				if err := t.update(t.Trade); err != nil {
+					t.error(fmt.Errorf("trade: couldn't update %s: %w", t.Base, err))
+				}
+				canceled = false
+			}
+		}
+
 		// Target not reached
 		if price.LessThan(target) {
 			continue
 		}
 
 		// This was the last target
-		if t.CurrentTarget >= t.maxTarget-1 || t.CurrentTarget >= len(t.Targets)-1 {
+		if lastTarget {
 			continue
 		}
 
@@ -166,42 +408,133 @@ func (t *Trader) Run(ctx context.Context) error {
 		}
 		t.CurrentTarget++
 		lower = previous
+		if t.Trailing != nil && t.Trailing.Stop.GreaterThan(lower) {
+			lower = t.Trailing.Stop
+		}
+		if t.StopPrice.GreaterThan(lower) {
+			lower = t.StopPrice
+		}
 		previous = target
 		target = t.Targets[t.CurrentTarget]
-		t.log(fmt.Sprintf("✔️ %s reached target %d", t.Base, t.CurrentTarget))
+		t.publish(&event.TargetReached{
+			Time:   t.clock.Now().UTC(),
+			Trade:  t.ID,
+			Base:   t.Base,
+			Target: t.CurrentTarget,
+		})
+
+		// Sell the target allocation
+		if qty := t.takeProfitQuantity(t.CurrentTarget - 1); qty.IsPositive() {
+			if err := t.takeProfit(ctx, qty); err != nil {
+				return err
+			}
+			if !t.Remaining().IsPositive() {
+				t.finish(decimal.Zero)
+				return nil
+			}
+		}
 		if err := t.createStopLimit(ctx, upper, lower); err != nil {
-			return err
+			return t.dust(err)
 		}
 		if err := t.update(t.Trade); err != nil {
-			t.log("trade: couldn't update %s: %w", t.Base, err)
+			t.error(fmt.Errorf("trade: couldn't update %s: %w", t.Base, err))
 		}
 		canceled = false
 	}
 }
 
+// error publishes an error of the trade
+func (t *Trader) error(err error) {
+	t.publish(&event.Error{Time: t.clock.Now().UTC(), Trade: t.ID, Base: t.Base, Err: err})
+}
+
+// retry publishes an error of an operation that will be retried
+func (t *Trader) retry(operation string, err error) {
+	t.publish(&event.ErrorRetry{Time: t.clock.Now().UTC(), Trade: t.ID, Base: t.Base, Operation: operation, Err: err})
+}
+
+// limited delays the next tick until the rate limit of the exchange is
+// reset, it returns false if the error isn't caused by a rate limit
+func (t *Trader) limited(tick *ticker, operation string, err error) bool {
+	var rateErr *exchange.RateLimitError
+	if !errors.As(err, &rateErr) {
+		return false
+	}
+	t.retry(operation, err)
+	tick.next = rateErr.RetryAfter
+	return true
+}
+
+// warn publishes a warning of the trade
+func (t *Trader) warn(text string) {
+	t.publish(&event.Warning{Time: t.clock.Now().UTC(), Trade: t.ID, Base: t.Base, Text: text})
+}
+
+// dust finishes the trade if the error is caused by a remaining quantity
+// too small to be sold, other errors are returned
+func (t *Trader) dust(err error) error {
+	if !errors.Is(err, exchange.ErrDust) {
+		return err
+	}
+	t.warn(fmt.Sprintf("%s remaining quantity %s is too small to be sold, closing trade: %v", t.symbol, t.Remaining(), err))
+	t.finish(decimal.Zero)
+	return nil
+}
+
+// finish sets the trade as finished after its orders have been completed
+func (t *Trader) finish(endQuoteQty decimal.Decimal) {
+	t.EndQuoteQuantity = t.Filled().Add(endQuoteQty)
+	t.EndTime = t.clock.Now().UTC()
+	if err := t.update(t.Trade); err != nil {
+		t.error(fmt.Errorf("trade: couldn't update %s: %w", t.Base, err))
+	}
+}
+
+// fallback closes streams and goes back to polling
+func (t *Trader) fallback(st *stream, tick *ticker) {
+	t.warn(fmt.Sprintf("%s streams dropped, falling back to polling", t.symbol))
+	st.close()
+	tick.wait = t.wait
+}
+
+// Status returns the profit, the profit ratio and the elapsed time of the
+// last snapshot
 func (t *Trader) Status() (decimal.Decimal, decimal.Decimal, time.Duration) {
-	currentQuoteQuantity := t.lastPrice.Mul(t.Quantity)
-	profit := currentQuoteQuantity.Sub(t.QuoteQuantity)
-	percentage := profit.Div(t.QuoteQuantity)
-	elapsed := time.Since(t.StartTime)
-	return profit, percentage, elapsed
+	return t.Snapshot().Status(t.clock.Now())
+}
+
+// Snapshot returns the trade state, it is safe to call while the trader runs
+func (t *Trader) Snapshot() *Snapshot {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.snapshot
+}
+
+// sync copies the trade state to the snapshot
+func (t *Trader) sync() {
+	s := &Snapshot{Trade: t.Trade.Copy(), Price: t.lastPrice}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.snapshot = s
 }
 
+// Sell forces the trade to be sold, it can be called more than once
 func (t *Trader) Sell() {
-	close(t.sell)
+	t.sellOnce.Do(func() {
+		close(t.sell)
+	})
 }
 
 func (t *Trader) status(ctx context.Context, ids []string) (bool, decimal.Decimal, error) {
 	var nerr int
-	tick, update := ticker(5 * time.Second)
+	tick := newTicker(t.clock, 5*time.Second)
 	for {
 		var statusErr error
 		for _, id := range ids {
 			select {
 			case <-ctx.Done():
 				return false, decimal.Decimal{}, ctx.Err()
-			case <-tick:
-				tick = update
+			case <-tick.C():
 			}
 			ok, endQuoteQty, err := t.exchange.Status(ctx, t.symbol, id)
 			if err != nil {
@@ -215,13 +548,16 @@ func (t *Trader) status(ctx context.Context, ids []string) (bool, decimal.Decima
 		if errors.As(statusErr, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
 			continue
 		}
+		if t.limited(tick, "status", statusErr) {
+			continue
+		}
 		if statusErr != nil {
 			err := fmt.Errorf("trade: couldn't get order status: %w", statusErr)
 			nerr++
 			if nerr > 100 {
 				return false, decimal.Decimal{}, err
 			}
-			t.log(err, "retrying...")
+			t.retry("status", err)
 			continue
 		}
 		return false, decimal.Decimal{}, nil
@@ -230,28 +566,42 @@ func (t *Trader) status(ctx context.Context, ids []string) (bool, decimal.Decima
 
 func (t *Trader) cancelStopLimit(ctx context.Context) error {
 	var nerr int
-	tick, update := ticker(5 * time.Second)
+	tick := newTicker(t.clock, 5*time.Second)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-tick:
-			tick = update
+		case <-tick.C():
 		}
 		err := t.exchange.CancelStopLimit(ctx, t.symbol, t.OrderListID)
 		var netErr net.Error
 		if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
 			continue
 		}
+		if t.limited(tick, "cancel_oco", err) {
+			continue
+		}
 		if err != nil {
 			err := fmt.Errorf("trade: couldn't delete order list %s:  %w", t.OrderListID, err)
 			nerr++
 			if nerr > 100 {
 				return err
 			}
-			t.log(err, "retrying...")
+			t.retry("cancel_oco", err)
 			continue
 		}
+		t.record(&OrderEvent{
+			Type:        OrderCanceled,
+			Side:        Sell,
+			OrderListID: t.OrderListID,
+		})
+		t.publish(&event.OCOCanceled{
+			Time:        t.clock.Now().UTC(),
+			Trade:       t.ID,
+			Base:        t.Base,
+			Quote:       t.Quote,
+			OrderListID: t.OrderListID,
+		})
 		t.OrderListID = ""
 		t.OrderIDs = nil
 		return nil
@@ -260,99 +610,184 @@ func (t *Trader) cancelStopLimit(ctx context.Context) error {
 
 func (t *Trader) createStopLimit(ctx context.Context, upper, lower decimal.Decimal) error {
 	var nerr int
-	tick, update := ticker(5 * time.Second)
+	tick := newTicker(t.clock, 5*time.Second)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-tick:
-			tick = update
+		case <-tick.C():
 		}
-		orderListID, orderIDs, err := t.exchange.CreateStopLimit(ctx, t.symbol, t.Quantity, upper, lower)
+		orderListID, orderIDs, err := t.exchange.CreateStopLimit(ctx, t.symbol, t.Remaining(), upper, lower)
 		var netErr net.Error
 		if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
 			continue
 		}
+		if errors.Is(err, exchange.ErrDust) {
+			return fmt.Errorf("trade: couldn't create order for %s: %w", t.symbol, err)
+		}
+		if t.limited(tick, "create_oco", err) {
+			continue
+		}
 		if err != nil {
 			err := fmt.Errorf("trade: couldn't create order for %s: %w", t.symbol, err)
 			nerr++
 			if nerr > 100 {
 				return err
 			}
-			t.log(err, "retrying...")
+			t.retry("create_oco", err)
 			continue
 		}
 		t.OrderListID = orderListID
 		t.OrderIDs = orderIDs
+		t.record(&OrderEvent{
+			Type:        OrderCreated,
+			Side:        Sell,
+			OrderListID: orderListID,
+			Price:       upper,
+			StopPrice:   lower,
+			Quantity:    t.Remaining(),
+		})
+		t.publish(&event.OCOPlaced{
+			Time:        t.clock.Now().UTC(),
+			Trade:       t.ID,
+			Base:        t.Base,
+			Quote:       t.Quote,
+			OrderListID: orderListID,
+			Quantity:    t.Remaining(),
+			Price:       upper,
+			StopPrice:   lower,
+		})
 		return nil
 	}
 }
 
 func (t *Trader) buy(ctx context.Context) error {
 	var nerr int
-	tick, update := ticker(5 * time.Second)
+	tick := newTicker(t.clock, 5*time.Second)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-tick:
-			tick = update
+		case <-tick.C():
 		}
 		quoteQty, qty, err := t.exchange.Buy(ctx, t.symbol, t.QuoteQuantity, t.StartPrice)
 		var netErr net.Error
 		if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
 			continue
 		}
+		// Retrying won't increase the quantity
+		if errors.Is(err, exchange.ErrDust) {
+			return fmt.Errorf("trade: couldn't buy %s: %w", t.Base, err)
+		}
+		if t.limited(tick, "buy", err) {
+			continue
+		}
 		if err != nil {
 			err := fmt.Errorf("trade: couldn't buy %s at price %s: %w", t.Base, t.StartPrice, err)
 			nerr++
 			if nerr > 100 {
 				return err
 			}
-			t.log(err, "retrying...")
+			t.retry("buy", err)
 			continue
 		}
 		t.QuoteQuantity = quoteQty
 		t.Quantity = qty
+		t.record(&OrderEvent{
+			Type:          OrderFilled,
+			Side:          Buy,
+			Quantity:      qty,
+			QuoteQuantity: quoteQty,
+		})
+		t.publish(&event.BuyFilled{
+			Time:          t.clock.Now().UTC(),
+			Trade:         t.ID,
+			Base:          t.Base,
+			Quote:         t.Quote,
+			Quantity:      qty,
+			QuoteQuantity: quoteQty,
+		})
 		return nil
 	}
 }
 
 func (t *Trader) forceSell(ctx context.Context) error {
 	var nerr int
-	tick, update := ticker(5 * time.Second)
+	tick := newTicker(t.clock, 5*time.Second)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-tick:
-			tick = update
+		case <-tick.C():
 		}
-		quoteQty, err := t.exchange.Sell(ctx, t.symbol, t.Quantity)
+		quoteQty, err := t.exchange.Sell(ctx, t.symbol, t.Remaining())
 		var netErr net.Error
 		if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
 			continue
 		}
+		// The remaining quantity is left in the account
+		if errors.Is(err, exchange.ErrDust) {
+			t.warn(fmt.Sprintf("%s remaining quantity %s is too small to be sold: %v", t.symbol, t.Remaining(), err))
+			quoteQty, err = decimal.Zero, nil
+		}
+		if t.limited(tick, "force_sell", err) {
+			continue
+		}
 		if err != nil {
 			err := fmt.Errorf("trade: couldn't force sell %s: %w (%T)", t.Base, err, err)
 			nerr++
 			if nerr > 100 {
 				return err
 			}
-			t.log(err, "retrying...")
+			t.retry("force_sell", err)
 			continue
 		}
-		t.EndQuoteQuantity = quoteQty
-		t.EndTime = time.Now().UTC()
+		t.record(&OrderEvent{
+			Type:          OrderFilled,
+			Side:          Sell,
+			Quantity:      t.Remaining(),
+			QuoteQuantity: quoteQty,
+		})
+		t.EndQuoteQuantity = t.Filled().Add(quoteQty)
+		t.EndTime = t.clock.Now().UTC()
 		return nil
 	}
 }
 
-func ticker(wait time.Duration) (<-chan time.Time, <-chan time.Time) {
+type ticker struct {
+	clock   clock.Clock
+	wait    time.Duration
+	started bool
+	// next replaces the wait of the next tick if it is positive
+	next time.Duration
+}
+
+func newTicker(clk clock.Clock, wait time.Duration) *ticker {
+	return &ticker{clock: clk, wait: wait}
+}
+
+// C returns a channel that fires after the ticker wait
+func (t *ticker) C() <-chan time.Time {
 	// Don't wait ticker time on first run
-	closedTick := make(chan time.Time)
-	close(closedTick)
-	tick := (<-chan time.Time)(closedTick)
-	ticker := time.NewTicker(wait)
-	return tick, ticker.C
+	if !t.started {
+		t.started = true
+		closedTick := make(chan time.Time)
+		close(closedTick)
+		return closedTick
+	}
+	if t.next > 0 {
+		next := t.next
+		t.next = 0
+		return t.clock.After(next)
+	}
+	return t.clock.After(t.wait)
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
 }
@@ -2,14 +2,32 @@ package trade
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"testing"
 	"time"
 
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange"
 	"github.com/shopspring/decimal"
 )
 
+func TestNewID(t *testing.T) {
+	ids := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := NewID()
+		if len(id) != 32 {
+			t.Fatalf("wrong id length: want 32, got %d", len(id))
+		}
+		if ids[id] {
+			t.Fatalf("duplicated id %s", id)
+		}
+		ids[id] = true
+	}
+}
+
 func TestOrderCompleted(t *testing.T) {
 	targets := []decimal.Decimal{
 		decimal.NewFromFloat(11.0),
@@ -28,7 +46,7 @@ func TestOrderCompleted(t *testing.T) {
 		inc:   decimal.NewFromFloat(1.0),
 	}
 
-	trader := NewTrader(log.Println, ex, tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil })
+	trader := NewTrader(event.Printer(log.Println), ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
 	if err := trader.Create(context.Background()); err != nil {
 		t.Fatal(err)
 	}
@@ -48,6 +66,55 @@ func TestOrderCompleted(t *testing.T) {
 	}
 }
 
+func TestSnapshot(t *testing.T) {
+	targets := []decimal.Decimal{
+		decimal.NewFromFloat(11.0),
+		decimal.NewFromFloat(12.0),
+		decimal.NewFromFloat(13.0),
+	}
+	tr := New("IGO", "USDT", decimal.NewFromFloat(10.0), targets, decimal.NewFromFloat(9.0), decimal.NewFromFloat(100.0))
+	ex := &mockExchange{
+		price: decimal.NewFromFloat(10.1),
+		inc:   decimal.NewFromFloat(1.0),
+	}
+	trader := NewTrader(func(event.Event) {}, ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
+	before := trader.Snapshot()
+
+	// Snapshots can be read while the trader runs
+	done := make(chan error, 1)
+	go func() {
+		if err := trader.Create(context.Background()); err != nil {
+			done <- err
+			return
+		}
+		done <- trader.Run(context.Background())
+	}()
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+			s := trader.Snapshot()
+			_, _, _ = s.Status(time.Now())
+			_ = len(s.Fills)
+		}
+	}
+
+	if !before.Pending() {
+		t.Error("previous snapshot was modified")
+	}
+	after := trader.Snapshot()
+	if after.Pending() || after.EndTime.IsZero() {
+		t.Errorf("snapshot not updated: %+v", after.Trade)
+	}
+	if !after.EndQuoteQuantity.Equal(tr.EndQuoteQuantity) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", tr.EndQuoteQuantity, after.EndQuoteQuantity)
+	}
+}
+
 func TestForceSell(t *testing.T) {
 	targets := []decimal.Decimal{
 		decimal.NewFromFloat(11.0),
@@ -67,7 +134,7 @@ func TestForceSell(t *testing.T) {
 		inc:       decimal.NewFromFloat(1.0),
 	}
 
-	trader := NewTrader(log.Println, ex, tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil })
+	trader := NewTrader(event.Printer(log.Println), ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
 	if err := trader.Create(context.Background()); err != nil {
 		t.Fatal(err)
 	}
@@ -84,26 +151,234 @@ func TestForceSell(t *testing.T) {
 	}
 }
 
+func TestDust(t *testing.T) {
+	ex := &mockExchange{
+		price:  decimal.NewFromFloat(10.1),
+		inc:    decimal.NewFromFloat(1.0),
+		minQty: decimal.NewFromInt(1),
+	}
+	var warnings int
+	print := event.Printer(log.Println)
+	publish := func(e event.Event) {
+		if _, ok := e.(*event.Warning); ok {
+			warnings++
+		}
+		print(e)
+	}
+
+	// Buying dust fails without retries
+	tr := New("IGO", "USDT", decimal.NewFromInt(10), testTargets(), decimal.NewFromInt(9), decimal.NewFromInt(5))
+	trader := NewTrader(publish, ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := trader.Create(ctx); !errors.Is(err, exchange.ErrDust) {
+		t.Fatalf("expected dust error, got %v", err)
+	}
+
+	// The trade is closed if the remaining quantity after taking profit is dust
+	tr = New("IGO", "USDT", decimal.NewFromInt(10), testTargets(), decimal.NewFromInt(9), decimal.NewFromInt(100))
+	tr.Allocation = []decimal.Decimal{decimal.NewFromFloat(0.95)}
+	trader = NewTrader(publish, ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
+	if err := trader.Create(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := trader.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tr.EndTime.IsZero() {
+		t.Fatal("trade hasn't finished")
+	}
+	if want := decimal.NewFromFloat(9.5 * 11.1); !tr.EndQuoteQuantity.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, tr.EndQuoteQuantity)
+	}
+	if warnings != 1 {
+		t.Errorf("wrong number of warnings: want 1, got %d", warnings)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	ex := &mockExchange{price: decimal.NewFromFloat(10.1), limited: 2}
+	var retries int
+	publish := func(e event.Event) {
+		if r, ok := e.(*event.ErrorRetry); ok && r.Operation == "buy" {
+			retries++
+		}
+	}
+	tr := New("IGO", "USDT", decimal.NewFromInt(10), testTargets(), decimal.NewFromInt(9), decimal.NewFromInt(100))
+	trader := NewTrader(publish, ex, clk, tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
+	if err := trader.buy(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if retries != 2 {
+		t.Errorf("wrong number of retries: want 2, got %d", retries)
+	}
+	// Each retry waits for the rate limit instead of the retry interval
+	if got, want := clk.Now().Sub(start), 2*time.Minute; got != want {
+		t.Errorf("wrong elapsed time: want %s, got %s", want, got)
+	}
+}
+
+func TestStreamFallback(t *testing.T) {
+	tr := New("IGO", "USDT", decimal.NewFromFloat(10.0), testTargets(), decimal.NewFromFloat(9.0), decimal.NewFromFloat(100.0))
+	ex := &mockStreamExchange{
+		mockExchange: &mockExchange{
+			price: decimal.NewFromFloat(10.1),
+			inc:   decimal.NewFromFloat(1.0),
+		},
+		prices: []decimal.Decimal{decimal.NewFromFloat(11.5), decimal.NewFromFloat(12.5)},
+	}
+
+	trader := NewTrader(event.Printer(log.Println), ex, clock.New(), tr, 5, 10*time.Millisecond, func(t *Trade) error { return nil }, nil)
+	if err := trader.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := trader.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ex.subscriptions == 0 {
+		t.Error("streams haven't been used")
+	}
+	// Polling is used after the price stream is closed
+	got := tr.EndQuoteQuantity
+	want := decimal.NewFromFloat(151.0)
+	if !got.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, got)
+	}
+	if ex.created != 5 {
+		t.Errorf("wrong number of created orders: want 5, got %d", ex.created)
+	}
+}
+
+func TestStreamOrderFilled(t *testing.T) {
+	tr := New("IGO", "USDT", decimal.NewFromFloat(10.0), testTargets(), decimal.NewFromFloat(9.0), decimal.NewFromFloat(100.0))
+	ex := &mockStreamExchange{
+		mockExchange: &mockExchange{
+			price: decimal.NewFromFloat(10.1),
+			inc:   decimal.NewFromFloat(1.0),
+		},
+		keepOpen: true,
+		updates: []exchange.OrderUpdate{
+			{OrderID: "other", Status: exchange.OrderFilled, QuoteQuantity: decimal.NewFromFloat(1.0)},
+			{OrderID: "", Status: exchange.OrderFilled, QuoteQuantity: decimal.NewFromFloat(123.0)},
+		},
+	}
+
+	trader := NewTrader(event.Printer(log.Println), ex, clock.New(), tr, 5, time.Hour, func(t *Trade) error { return nil }, nil)
+	if err := trader.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := trader.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := tr.EndQuoteQuantity
+	want := decimal.NewFromFloat(123.0)
+	if !got.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, got)
+	}
+}
+
+func testTargets() []decimal.Decimal {
+	return []decimal.Decimal{
+		decimal.NewFromFloat(11.0),
+		decimal.NewFromFloat(12.0),
+		decimal.NewFromFloat(13.0),
+		decimal.NewFromFloat(14.0),
+		decimal.NewFromFloat(15.0),
+		decimal.NewFromFloat(16.0),
+	}
+}
+
+type mockStreamExchange struct {
+	*mockExchange
+	prices        []decimal.Decimal
+	updates       []exchange.OrderUpdate
+	keepOpen      bool
+	subscriptions int
+}
+
+func (e *mockStreamExchange) SubscribePrice(ctx context.Context, symbol string) (<-chan decimal.Decimal, error) {
+	e.subscriptions++
+	ch := make(chan decimal.Decimal)
+	go func() {
+		defer close(ch)
+		for _, p := range e.prices {
+			select {
+			case ch <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if e.keepOpen {
+			<-ctx.Done()
+		}
+	}()
+	return ch, nil
+}
+
+func (e *mockStreamExchange) SubscribeOrders(ctx context.Context, symbol string) (<-chan exchange.OrderUpdate, error) {
+	ch := make(chan exchange.OrderUpdate)
+	go func() {
+		defer close(ch)
+		for _, u := range e.updates {
+			select {
+			case ch <- u:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}()
+	return ch, nil
+}
+
 type mockExchange struct {
 	forceSell bool
 	price     decimal.Decimal
 	inc       decimal.Decimal
 	target    decimal.Decimal
+	stop      decimal.Decimal
 	quantity  decimal.Decimal
 	created   int
 	canceled  int
 	sold      bool
+	// minQty rejects smaller quantities as dust
+	minQty decimal.Decimal
+	// limited is the number of buys rejected by rate limits
+	limited int
+}
+
+func (e *mockExchange) dust(symbol string, quantity decimal.Decimal) error {
+	if quantity.LessThan(e.minQty) {
+		return &exchange.FilterError{Symbol: symbol, Filter: "LOT_SIZE", Value: quantity, Limit: e.minQty, Dust: true}
+	}
+	return nil
 }
 
 func (e *mockExchange) Buy(ctx context.Context, symbol string, quoteQuantity, price decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	if e.limited > 0 {
+		e.limited--
+		return decimal.Zero, decimal.Zero, &exchange.RateLimitError{RetryAfter: time.Minute}
+	}
+	if err := e.dust(symbol, quoteQuantity.Div(price)); err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
 	return quoteQuantity, quoteQuantity.Div(price), nil
 }
 func (e *mockExchange) Sell(ctx context.Context, symbol string, quantity decimal.Decimal) (decimal.Decimal, error) {
+	if err := e.dust(symbol, quantity); err != nil {
+		return decimal.Zero, err
+	}
 	e.sold = true
 	return quantity.Mul(e.price), nil
 }
 func (e *mockExchange) CreateStopLimit(ctx context.Context, symbol string, quantity, target, stoploss decimal.Decimal) (string, []string, error) {
+	if err := e.dust(symbol, quantity); err != nil {
+		return "", nil, err
+	}
 	e.target = target
+	e.stop = stoploss
 	e.quantity = quantity
 	e.created++
 	return "", []string{""}, nil
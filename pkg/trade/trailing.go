@@ -0,0 +1,132 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	atrInterval = "1h"
+	atrPeriod   = 14
+)
+
+// TrailingStop moves the stop loss up as the price makes new highs
+type TrailingStop struct {
+	// Ratio is the distance to the highest price as a ratio of the price
+	Ratio decimal.Decimal
+	// ATR is the distance to the highest price as a multiple of the ATR
+	ATR decimal.Decimal
+	// Step is the minimum ratio the stop must increase to be moved
+	Step decimal.Decimal
+	// Distance is the ATR based distance calculated on trade creation
+	Distance decimal.Decimal
+	// High is the highest price since the trade was created
+	High decimal.Decimal
+	// Stop is the current trailing stop price
+	Stop decimal.Decimal
+}
+
+// ParseTrailingStop parses a trailing stop distance as a percentage (`5%`)
+// or as a multiple of the hourly ATR (`2atr`).
+// Step is the percentage the stop must increase before the order is moved.
+// It returns nil if the value is empty.
+func ParseTrailingStop(value string, step float64) (*TrailingStop, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return nil, nil
+	}
+	ts := &TrailingStop{
+		Step: decimal.NewFromFloat(step).Div(decimal.NewFromInt(100)),
+	}
+	switch {
+	case strings.HasSuffix(value, "%"):
+		perc, err := decimal.NewFromString(strings.TrimSuffix(value, "%"))
+		if err != nil {
+			return nil, fmt.Errorf("trade: couldn't parse trailing stop %s: %w", value, err)
+		}
+		ts.Ratio = perc.Div(decimal.NewFromInt(100))
+	case strings.HasSuffix(value, "atr"):
+		mult, err := decimal.NewFromString(strings.TrimSuffix(value, "atr"))
+		if err != nil {
+			return nil, fmt.Errorf("trade: couldn't parse trailing stop %s: %w", value, err)
+		}
+		ts.ATR = mult
+	default:
+		return nil, fmt.Errorf("trade: invalid trailing stop %s, use a percentage (5%%) or an atr multiple (2atr)", value)
+	}
+	if !ts.Ratio.IsPositive() && !ts.ATR.IsPositive() {
+		return nil, fmt.Errorf("trade: trailing stop must be positive: %s", value)
+	}
+	return ts, nil
+}
+
+// Copy returns a copy of the trailing stop to be used on a new trade
+func (ts *TrailingStop) Copy() *TrailingStop {
+	if ts == nil {
+		return nil
+	}
+	c := *ts
+	return &c
+}
+
+// next returns the new stop price if the price is a new high and the stop
+// has increased more than the step over the current stop
+func (ts *TrailingStop) next(price, stop decimal.Decimal) (decimal.Decimal, bool) {
+	if !price.GreaterThan(ts.High) {
+		return decimal.Zero, false
+	}
+	ts.High = price
+	distance := ts.Distance
+	if distance.IsZero() {
+		distance = price.Mul(ts.Ratio)
+	}
+	next := price.Sub(distance)
+	if !next.GreaterThan(stop.Add(stop.Mul(ts.Step))) {
+		return decimal.Zero, false
+	}
+	return next, true
+}
+
+// init sets the initial trailing state after buying
+func (t *Trader) initTrailingStop(ctx context.Context) {
+	ts := t.Trailing
+	ts.High = t.QuoteQuantity.Div(t.Quantity)
+	if !ts.ATR.IsPositive() {
+		return
+	}
+	candler, ok := t.exchange.(exchange.Candler)
+	if !ok {
+		t.warn(fmt.Sprintf("%s trailing stop disabled, exchange doesn't provide candles", t.Base))
+		t.Trailing = nil
+		return
+	}
+	candles, err := candler.Candles(ctx, t.symbol, atrInterval, atrPeriod+1)
+	if err != nil {
+		t.warn(fmt.Sprintf("%s trailing stop disabled, couldn't get candles: %v", t.Base, err))
+		t.Trailing = nil
+		return
+	}
+	if len(candles) < 2 {
+		t.warn(fmt.Sprintf("%s trailing stop disabled, not enough candles", t.Base))
+		t.Trailing = nil
+		return
+	}
+	ts.Distance = atr(candles).Mul(ts.ATR)
+}
+
+// atr calculates the average true range of the candles
+func atr(candles []exchange.Candle) decimal.Decimal {
+	sum := decimal.Zero
+	for i := 1; i < len(candles); i++ {
+		c, prev := candles[i], candles[i-1]
+		tr := c.High.Sub(c.Low)
+		tr = decimal.Max(tr, c.High.Sub(prev.Close).Abs())
+		tr = decimal.Max(tr, c.Low.Sub(prev.Close).Abs())
+		sum = sum.Add(tr)
+	}
+	return sum.Div(decimal.NewFromInt(int64(len(candles) - 1)))
+}
@@ -0,0 +1,119 @@
+package trade
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/shopspring/decimal"
+)
+
+func TestParseTrailingStop(t *testing.T) {
+	tests := []struct {
+		value string
+		ratio float64
+		atr   float64
+		err   bool
+	}{
+		{value: "5%", ratio: 0.05},
+		{value: " 2.5ATR ", atr: 2.5},
+		{value: "5", err: true},
+		{value: "-1%", err: true},
+		{value: "xatr", err: true},
+	}
+	for _, tt := range tests {
+		ts, err := ParseTrailingStop(tt.value, 0.5)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.value, err)
+			continue
+		}
+		if !ts.Ratio.Equal(decimal.NewFromFloat(tt.ratio)) || !ts.ATR.Equal(decimal.NewFromFloat(tt.atr)) {
+			t.Errorf("%s: wrong trailing stop: %+v", tt.value, ts)
+		}
+		if !ts.Step.Equal(decimal.NewFromFloat(0.005)) {
+			t.Errorf("%s: wrong step: %s", tt.value, ts.Step)
+		}
+	}
+	if ts, err := ParseTrailingStop("", 0.5); ts != nil || err != nil {
+		t.Errorf("empty value should be disabled: %v %v", ts, err)
+	}
+}
+
+func TestTrailingStop(t *testing.T) {
+	start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	klines := newTrailingSeries("IGOUSDT", start, 10, 11, 12, 13, 14, 13.5, 12, 11)
+	clk := clock.NewVirtual(start)
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(100)})
+
+	targets := []decimal.Decimal{decimal.NewFromInt(20), decimal.NewFromInt(30)}
+	tr := New("IGO", "USDT", decimal.NewFromInt(10), targets, decimal.NewFromInt(9), decimal.NewFromInt(100))
+	var err error
+	tr.Trailing, err = ParseTrailingStop("10%", 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updates int
+	trader := NewTrader(event.Printer(log.Println), ex, clk, tr, 5, 5*time.Second, func(t *Trade) error {
+		updates++
+		return nil
+	}, nil)
+	if err := trader.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := trader.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Stop is moved to 90% of the highest price 14 and filled when the price
+	// drops to 12
+	if want := decimal.NewFromFloat(12.6); !tr.Trailing.Stop.Equal(want) {
+		t.Errorf("wrong trailing stop: want %s, got %s", want, tr.Trailing.Stop)
+	}
+	if want := decimal.NewFromInt(126); !tr.EndQuoteQuantity.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, tr.EndQuoteQuantity)
+	}
+	if updates < 4 {
+		t.Errorf("trailing stop updates haven't been stored: %d", updates)
+	}
+}
+
+func TestATR(t *testing.T) {
+	candles := []exchange.Candle{
+		{High: decimal.NewFromInt(11), Low: decimal.NewFromInt(9), Close: decimal.NewFromInt(10)},
+		{High: decimal.NewFromInt(12), Low: decimal.NewFromInt(11), Close: decimal.NewFromInt(11)},
+		{High: decimal.NewFromInt(11), Low: decimal.NewFromInt(8), Close: decimal.NewFromInt(9)},
+	}
+	// True ranges are 2 (12-10) and 3 (11-8)
+	if got, want := atr(candles), decimal.NewFromFloat(2.5); !got.Equal(want) {
+		t.Errorf("wrong atr: want %s, got %s", want, got)
+	}
+}
+
+// newTrailingSeries creates one minute candles opening at the previous close
+func newTrailingSeries(symbol string, start time.Time, open float64, closes ...float64) backtest.Series {
+	var candles []exchange.Candle
+	o := decimal.NewFromFloat(open)
+	for i, v := range closes {
+		c := decimal.NewFromFloat(v)
+		candles = append(candles, exchange.Candle{
+			OpenTime:  start.Add(time.Duration(i) * time.Minute),
+			CloseTime: start.Add(time.Duration(i+1)*time.Minute - time.Millisecond),
+			Open:      o,
+			High:      decimal.Max(o, c),
+			Low:       decimal.Min(o, c),
+			Close:     c,
+		})
+		o = c
+	}
+	return backtest.Series{symbol: candles}
+}
@@ -0,0 +1,80 @@
+package zeken
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/igolaizola/zeken/pkg/sizing"
+	"github.com/shopspring/decimal"
+)
+
+// size returns the quote quantity of a new trade using the sizing strategy of
+// the source or the bot, the lock must be held
+func (b *Bot) size(sig *signal.Signal, src *source) (decimal.Decimal, error) {
+	open := decimal.Zero
+	for _, tr := range b.trades {
+		t := tr.Snapshot()
+		// The quote of pending trades is still part of the balance
+		if t.Pending() {
+			continue
+		}
+		open = open.Add(t.QuoteQuantity)
+	}
+	available, err := b.exchange.Balance(b.ctx, sig.Quote)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: %v", errBalance, err)
+	}
+	entry := sig.Start
+	if entry.IsZero() {
+		entry = sig.EntryHigh
+	}
+	params := sizing.Params{
+		Available: available,
+		Open:      open,
+		MaxTrades: b.maxTrades,
+		Entry:     entry,
+		Stop:      sig.Stop,
+		History: func() (sizing.Stats, error) {
+			return b.sourceStats(src.Name)
+		},
+	}
+
+	// Balance ratio is used by default
+	balance := &sizing.Balance{Ratio: decimal.NewFromFloat(src.balanceRatio(b.balanceRatio))}
+	strategy := src.Sizing
+	if strategy == nil {
+		strategy = b.sizing
+	}
+	if strategy == nil {
+		strategy = balance
+	}
+	quoteQty, err := sizing.Size(strategy, params, b.minNotional)
+	// Sources without enough history use the balance ratio
+	if errors.Is(err, sizing.ErrNotEnoughHistory) {
+		quoteQty, err = sizing.Size(balance, params, b.minNotional)
+	}
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: %v", errSize, err)
+	}
+	return quoteQty, nil
+}
+
+// sourceStats returns the results of the trades of the source finished in
+// the last year
+func (b *Bot) sourceStats(name string) (sizing.Stats, error) {
+	now := b.clock.Now().UTC()
+	trades, err := b.store.List(now.Add(-365*24*time.Hour), now, true)
+	if err != nil {
+		return sizing.Stats{}, fmt.Errorf("zeken: couldn't list trades: %w", err)
+	}
+	var profits []decimal.Decimal
+	for _, t := range trades {
+		if t.Source != name || !t.QuoteQuantity.IsPositive() {
+			continue
+		}
+		profits = append(profits, t.EndQuoteQuantity.Sub(t.QuoteQuantity).Div(t.QuoteQuantity))
+	}
+	return sizing.NewStats(profits), nil
+}
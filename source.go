@@ -0,0 +1,178 @@
+package zeken
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/igolaizola/zeken/pkg/sizing"
+	"github.com/igolaizola/zeken/pkg/telegram"
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/shopspring/decimal"
+)
+
+// SourceSettings are the settings of a signal source, zero values fall back
+// to the bot settings
+type SourceSettings struct {
+	// Name identifies the source and is recorded on its trades
+	Name         string
+	Parser       signal.Parser
+	MaxTrades    int
+	BalanceRatio float64
+	MaxTarget    int
+	// Sizing overrides the bot sizing strategy
+	Sizing sizing.Strategy
+}
+
+// source is a signal source with its settings
+type source struct {
+	signal.Source
+	SourceSettings
+}
+
+func (s *source) balanceRatio(def float64) float64 {
+	if s.BalanceRatio > 0 {
+		return s.BalanceRatio
+	}
+	return def
+}
+
+func (s *source) maxTarget(def int) int {
+	if s.MaxTarget > 0 {
+		return s.MaxTarget
+	}
+	return def
+}
+
+// source returns the source with the name or a source with default settings
+// if it doesn't exist
+func (b *Bot) source(name string) *source {
+	for _, s := range b.sources {
+		if s.Name == name {
+			return s
+		}
+	}
+	return &source{SourceSettings: SourceSettings{Name: name}}
+}
+
+// SourceConfig is the configuration of a telegram signal chat
+type SourceConfig struct {
+	SourceSettings
+	ParserName string
+	ChatID     int64
+}
+
+// ParseSourceConfig parses a source configuration with comma separated
+// key=value pairs, e.g.
+// name=vip,chat=-100123,parser=cryptosignals,max-trades=2,balance-ratio=0.5,max-target=3,sizing=risk:1
+func ParseSourceConfig(value string) (*SourceConfig, error) {
+	var c SourceConfig
+	for _, kv := range strings.Split(value, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		split := strings.SplitN(kv, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("zeken: invalid source setting %q", kv)
+		}
+		k, v := strings.TrimSpace(split[0]), strings.TrimSpace(split[1])
+		var err error
+		switch k {
+		case "name":
+			c.Name = v
+		case "chat":
+			c.ChatID, err = strconv.ParseInt(v, 10, 64)
+		case "parser":
+			c.ParserName = v
+		case "max-trades":
+			c.MaxTrades, err = strconv.Atoi(v)
+		case "balance-ratio":
+			c.BalanceRatio, err = strconv.ParseFloat(v, 64)
+		case "max-target":
+			c.MaxTarget, err = strconv.Atoi(v)
+		case "sizing":
+			c.Sizing, err = sizing.Parse(v)
+		default:
+			return nil, fmt.Errorf("zeken: unknown source setting %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zeken: couldn't parse source setting %s: %w", k, err)
+		}
+	}
+	if c.ChatID == 0 {
+		return nil, fmt.Errorf("zeken: source chat not provided: %s", value)
+	}
+	if c.Name == "" {
+		c.Name = strconv.FormatInt(c.ChatID, 10)
+	}
+	if c.BalanceRatio < 0 || c.BalanceRatio > 1 {
+		return nil, fmt.Errorf("zeken: invalid source balance ratio: %v", c.BalanceRatio)
+	}
+	return &c, nil
+}
+
+// sourceProfit is the profit of the trades of a source
+type sourceProfit struct {
+	Source string
+	Trades int
+	Profit decimal.Decimal
+}
+
+// profitBySource groups the profit of the trades by source sorted by name
+func profitBySource(trades []*trade.Trade) []sourceProfit {
+	bySource := make(map[string]*sourceProfit)
+	for _, t := range trades {
+		name := t.Source
+		if name == "" {
+			name = "unknown"
+		}
+		p, ok := bySource[name]
+		if !ok {
+			p = &sourceProfit{Source: name}
+			bySource[name] = p
+		}
+		p.Trades++
+		p.Profit = p.Profit.Add(t.EndQuoteQuantity.Sub(t.QuoteQuantity))
+	}
+	var profits []sourceProfit
+	for _, p := range bySource {
+		profits = append(profits, *p)
+	}
+	sort.Slice(profits, func(i, j int) bool {
+		return profits[i].Source < profits[j].Source
+	})
+	return profits
+}
+
+// telegramSource reads signals from a telegram chat
+type telegramSource struct {
+	messages chan *signal.Message
+}
+
+func newTelegramSource(bot *telegram.Bot, chatID int64) *telegramSource {
+	s := &telegramSource{messages: make(chan *signal.Message, 100)}
+	// Handlers must be added before the telegram bot starts
+	bot.HandleChat(chatID, func(m *telegram.Message) {
+		msg := &signal.Message{ID: strconv.Itoa(m.ID), Text: m.Text}
+		if m.ReplyTo != 0 {
+			msg.ReplyTo = strconv.Itoa(m.ReplyTo)
+		}
+		s.messages <- msg
+	})
+	return s
+}
+
+func (s *telegramSource) Run(ctx context.Context, handler func(msg *signal.Message) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-s.messages:
+			_ = handler(msg)
+		}
+	}
+}
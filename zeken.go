@@ -4,19 +4,30 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
 	"github.com/igolaizola/zeken/pkg/exchange"
 	"github.com/igolaizola/zeken/pkg/exchange/binance"
+	"github.com/igolaizola/zeken/pkg/exchange/paper"
+	"github.com/igolaizola/zeken/pkg/metrics"
+	"github.com/igolaizola/zeken/pkg/notify"
+	"github.com/igolaizola/zeken/pkg/risk"
 	"github.com/igolaizola/zeken/pkg/signal"
 	"github.com/igolaizola/zeken/pkg/signal/parser"
+	"github.com/igolaizola/zeken/pkg/signal/parser/tradingview"
+	"github.com/igolaizola/zeken/pkg/signal/webhook"
+	"github.com/igolaizola/zeken/pkg/sizing"
 	"github.com/igolaizola/zeken/pkg/telegram"
 	"github.com/igolaizola/zeken/pkg/trade"
 	"github.com/igolaizola/zeken/pkg/trade/bolt"
+	"github.com/igolaizola/zeken/pkg/trade/sqlite"
 	"github.com/shopspring/decimal"
 )
 
@@ -27,170 +38,687 @@ type Bot struct {
 	ctx          context.Context
 	cancel       context.CancelFunc
 	exchange     exchange.Exchange
+	clock        clock.Clock
 	log          func(v ...interface{})
+	bus          *event.Bus
 	parser       signal.Parser
 	maxTrades    int
 	maxTarget    int
 	balanceRatio float64
+	trailing     *trade.TrailingStop
+	allocation   []decimal.Decimal
+	samePair     bool
+	entryExpiry  time.Duration
+	wait         time.Duration
 	trades       map[string]*trade.Trader
 	lock         sync.Mutex
 	store        trade.Store
 	currency     string
 	dry          bool
+	httpAddr     string
+	httpToken    string
+	metrics      *metrics.Metrics
+	metricsAddr  string
+	notifier     notify.Notifier
+	sources      []*source
+	sizing       sizing.Strategy
+	minNotional  decimal.Decimal
+	guard        *risk.Guard
+	guardSell    bool
 }
 
-func NewBot(dbPath, apiKey, apiSecret, proxy, token, parserName string, controlChatID, signalChatID, maxTrades, maxTarget int, balanceRatio float64, currency string, dry, debug bool) (*Bot, error) {
-	tgbot, err := telegram.New(token, controlChatID)
-	if err != nil {
-		return nil, fmt.Errorf("zeken: couldn't create telegram bot: %w", err)
+// Messenger prints bot logs and receives commands
+type Messenger interface {
+	Print(v ...interface{})
+	HandleCommand(command string, handler func(args string))
+	Run(ctx context.Context) error
+}
+
+// Option configures optional bot settings
+type Option func(*Bot)
+
+// WithClock sets the clock used by the bot and its trades
+func WithClock(clk clock.Clock) Option {
+	return func(b *Bot) { b.clock = clk }
+}
+
+// WithMaxTrades sets the maximum number of simultaneous trades
+func WithMaxTrades(n int) Option {
+	return func(b *Bot) { b.maxTrades = n }
+}
+
+// WithMaxTarget sets the maximum target to sell
+func WithMaxTarget(n int) Option {
+	return func(b *Bot) { b.maxTarget = n }
+}
+
+// WithBalanceRatio sets the ratio of the balance to be used
+func WithBalanceRatio(ratio float64) Option {
+	return func(b *Bot) { b.balanceRatio = ratio }
+}
+
+// WithCurrency sets the quote currency
+func WithCurrency(currency string) Option {
+	return func(b *Bot) { b.currency = currency }
+}
+
+// WithTrailingStop enables the trailing stop on new trades
+func WithTrailingStop(ts *trade.TrailingStop) Option {
+	return func(b *Bot) { b.trailing = ts }
+}
+
+// WithAllocation sets the default ratio to be sold at each target
+func WithAllocation(allocation []decimal.Decimal) Option {
+	return func(b *Bot) { b.allocation = allocation }
+}
+
+// WithSamePair allows concurrent trades on the same pair
+func WithSamePair(samePair bool) Option {
+	return func(b *Bot) { b.samePair = samePair }
+}
+
+// WithEntryExpiry sets how long trades with an entry zone wait for the price
+// to be in the zone, signals can override it
+func WithEntryExpiry(d time.Duration) Option {
+	return func(b *Bot) { b.entryExpiry = d }
+}
+
+// WithDry marks the bot as running in dry mode
+func WithDry(dry bool) Option {
+	return func(b *Bot) { b.dry = dry }
+}
+
+// WithWait sets the wait between trade price checks
+func WithWait(wait time.Duration) Option {
+	return func(b *Bot) { b.wait = wait }
+}
+
+// WithHTTP enables the http control api on the address, requests must use
+// the token as bearer token
+func WithHTTP(addr, token string) Option {
+	return func(b *Bot) {
+		b.httpAddr = addr
+		b.httpToken = token
 	}
-	log := tgbot.Print
-	var ex exchange.Exchange
-	if dry {
-		ex = binance.NewDry(log, debug)
-	} else {
-		ex = binance.New(log, apiKey, apiSecret, proxy, debug)
+}
+
+// WithMetrics collects metrics from bot events, they are exposed on the
+// address at /metrics if it isn't empty
+func WithMetrics(m *metrics.Metrics, addr string) Option {
+	return func(b *Bot) {
+		b.metrics = m
+		b.metricsAddr = addr
 	}
+}
 
-	signalParser, err := parser.NewParser(parserName)
-	if err != nil {
-		return nil, fmt.Errorf("zeken: couldn't create parser %s: %w", parserName, err)
+// WithNotifier sends printed bot events to the notifier
+func WithNotifier(n notify.Notifier) Option {
+	return func(b *Bot) { b.notifier = n }
+}
+
+// WithSource adds a signal source, its settings override the bot settings
+// for its signals
+func WithSource(src signal.Source, settings SourceSettings) Option {
+	return func(b *Bot) {
+		b.sources = append(b.sources, &source{Source: src, SourceSettings: settings})
 	}
-	store, err := bolt.New(dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("zeken: couldn't create db: %w", err)
+}
+
+// WithSizing sets the default sizing strategy, the balance ratio is used if
+// it isn't set
+func WithSizing(s sizing.Strategy) Option {
+	return func(b *Bot) {
+		b.sizing = s
+	}
+}
+
+// WithMinNotional sets the minimum quote quantity of a trade
+func WithMinNotional(min decimal.Decimal) Option {
+	return func(b *Bot) {
+		b.minNotional = min
+	}
+}
+
+// WithGuard pauses new signals when the loss limits of the guard are reached,
+// running trades are also sold if forceSell is set
+func WithGuard(g *risk.Guard, forceSell bool) Option {
+	return func(b *Bot) {
+		b.guard = g
+		b.guardSell = forceSell
 	}
+}
+
+// WithLog sets the function used to log trade events, the messenger is used
+// by default
+func WithLog(log func(v ...interface{})) Option {
+	return func(b *Bot) { b.log = log }
+}
+
+// New creates a bot using the given dependencies
+func New(m Messenger, ex exchange.Exchange, store trade.Store, parser signal.Parser, opts ...Option) *Bot {
 	b := &Bot{
 		ctx:          context.TODO(),
-		run:          tgbot.Run,
-		log:          log,
+		run:          m.Run,
+		log:          m.Print,
+		bus:          event.NewBus(),
 		exchange:     ex,
-		parser:       signalParser,
-		maxTrades:    maxTrades,
-		maxTarget:    maxTarget,
-		balanceRatio: balanceRatio,
+		clock:        clock.New(),
+		parser:       parser,
+		maxTrades:    5,
+		maxTarget:    5,
+		balanceRatio: 0.99,
+		wait:         5 * time.Second,
 		trades:       make(map[string]*trade.Trader),
 		lock:         sync.Mutex{},
 		store:        store,
-		currency:     currency,
-		dry:          dry,
+		currency:     "USDT",
+		minNotional:  decimal.NewFromInt(10),
 	}
-	tgbot.HandleChat(int64(signalChatID), true, func(msg string) {
-		b.handle(msg)
-	})
-	tgbot.HandleCommand("status", func(_ string) {
-		if len(b.trades) == 0 {
-			b.log("no trades running")
-			return
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.bus.Subscribe(event.Printer(b.log))
+	if b.metrics != nil {
+		b.bus.Subscribe(b.metrics.Observe)
+	}
+	if b.notifier != nil {
+		b.bus.Subscribe(notify.Subscriber(b.log, b.notifier))
+	}
+	// Command replies always go to the messenger
+	reply := m.Print
+	m.HandleCommand("status", func(_ string) {
+		sb := &strings.Builder{}
+		if trip := b.tripped(); trip != nil {
+			fmt.Fprintf(sb, "🚨 signals paused by %s limit, use /resume to trade again\n", strings.ReplaceAll(string(trip.Reason), "_", " "))
 		}
-
-		// Sort trades by start time
-		var trades []*trade.Trader
-		for _, t := range b.trades {
-			trades = append(trades, t)
+		trades := b.openTrades()
+		if len(trades) == 0 {
+			sb.WriteString("no trades running")
+			reply(sb.String())
+			return
 		}
-		sort.Slice(trades, func(i, j int) bool {
-			return trades[i].StartTime.Before(trades[j].StartTime)
-		})
 
-		sb := &strings.Builder{}
 		totalProfit := decimal.Zero
-		for _, t := range trades {
-			profit, perc, elapsed := t.Status()
+		now := b.clock.Now()
+		for _, tr := range trades {
+			t := tr.Snapshot()
+			if t.Pending() && t.HasEntryZone() {
+				fmt.Fprintf(sb, "⏳ %s %s waiting to buy between %s and %s\n", t.ID, t.Base, t.EntryLow, t.EntryHigh)
+				continue
+			}
+			profit, perc, elapsed := t.Status(now)
 			totalProfit = totalProfit.Add(profit)
 			emoji := "📈"
 			if profit.LessThan(decimal.Zero) {
 				emoji = "📉"
 			}
-			fmt.Fprintf(sb, "%s %s %s%% %s %s %s\n", emoji, t.Base, perc.Mul(decimal.NewFromInt(100)).StringFixed(2), profit.StringFixed(2), t.Quote, elapsed.Round(time.Second))
+			fmt.Fprintf(sb, "%s %s %s %s%% %s %s %s\n", emoji, t.ID, t.Base, perc.Mul(decimal.NewFromInt(100)).StringFixed(2), profit.StringFixed(2), t.Quote, elapsed.Round(time.Second))
 		}
 		fmt.Fprintf(sb, "Total: %s %s", totalProfit.StringFixed(2), b.currency)
-		b.log(sb.String())
+		reply(sb.String())
 	})
-	tgbot.HandleCommand("sell", func(msg string) {
-		t, ok := b.trades[msg]
-		if !ok {
-			b.log(fmt.Sprintf("trade %s not found", msg))
+	m.HandleCommand("sell", func(msg string) {
+		t, err := b.sell(msg)
+		if err != nil {
+			reply(err.Error())
 			return
 		}
-		b.log(fmt.Sprintf("selling %s", msg))
-		t.Sell()
+		reply(fmt.Sprintf("selling %s %s", t.ID, t.Base))
 	})
-	tgbot.HandleCommand("history", func(msg string) {
+	m.HandleCommand("history", func(msg string) {
 		days := 365
 		if msg != "" {
 			var err error
 			days, err = strconv.Atoi(msg)
 			if err != nil {
-				b.log("couldn't parse %s: %v", msg, err)
+				reply("couldn't parse %s: %v", msg, err)
 				return
 			}
 		}
-		from := time.Now().Add(-1 * time.Duration(days) * 24 * time.Hour)
-		to := time.Now()
-		trades, err := b.store.List(from, to, true)
+		from := b.clock.Now().Add(-1 * time.Duration(days) * 24 * time.Hour)
+		to := b.clock.Now()
+		trades, err := b.history(from, to)
 		if err != nil {
-			b.log("couldn't list trades: %v", err)
+			reply(err)
 			return
 		}
 
-		// Sort trades by start time
-		sort.Slice(trades, func(i, j int) bool {
-			return trades[i].StartTime.Before(trades[j].StartTime)
-		})
-
 		totalProfit := decimal.Zero
 		sb := &strings.Builder{}
 		fmt.Fprintf(sb, "Last %d days:\n", days)
 		for _, t := range trades {
 			profit := t.EndQuoteQuantity.Sub(t.QuoteQuantity)
-			fmt.Fprintf(sb, "%s: %s %s\n", t.Base, profit.StringFixed(2), b.currency)
+			fmt.Fprintf(sb, "%s %s: %s %s\n", t.ID, t.Base, profit.StringFixed(2), b.currency)
 			totalProfit = totalProfit.Add(profit)
 		}
 		fmt.Fprintf(sb, "Total: %s %s\n", totalProfit.StringFixed(2), b.currency)
-		b.log(sb.String())
+		if profits := profitBySource(trades); len(profits) > 1 {
+			fmt.Fprintf(sb, "By source:\n")
+			for _, p := range profits {
+				fmt.Fprintf(sb, "%s (%d): %s %s\n", p.Source, p.Trades, p.Profit.StringFixed(2), b.currency)
+			}
+		}
+		reply(sb.String())
+	})
+	m.HandleCommand("orders", func(msg string) {
+		// Finished trades aren't running, so the ledger is requested by id
+		events, err := b.store.Orders(&trade.Trade{ID: msg})
+		if err != nil {
+			reply("couldn't get orders: %v", err)
+			return
+		}
+		if len(events) == 0 {
+			reply(fmt.Sprintf("orders of trade %s not found", msg))
+			return
+		}
+		sb := &strings.Builder{}
+		for _, e := range events {
+			fmt.Fprintf(sb, "%s %s %s %s@%s\n", e.Time.Format(time.RFC3339), e.Side, e.Type, e.Quantity, e.Price)
+		}
+		fmt.Fprintf(sb, "Exit price: %s", trade.ExitPrice(events))
+		reply(sb.String())
+	})
+	m.HandleCommand("resume", func(_ string) {
+		if err := b.resumeGuard(); err != nil {
+			reply(err.Error())
+		}
 	})
-	tgbot.HandleCommand("shutdown", func(_ string) {
-		b.log("shutting down")
+	m.HandleCommand("shutdown", func(_ string) {
+		reply("shutting down")
 		b.shutdown()
 	})
-	return b, nil
+	return b
+}
+
+// Config is the configuration of a bot created with NewBot.
+// Percentages go from 0 to 100 and ratios from 0 to 1.
+type Config struct {
+	// DBPath is the bolt db path, its name is also used for the dry mode and
+	// loss guard state files
+	DBPath string
+	// StoreURI is the trade store uri, see newStore
+	StoreURI string
+
+	APIKey    string
+	APISecret string
+	Proxy     string
+
+	Token         string
+	ControlChatID int
+	// SignalChatID is ignored if signal sources are provided
+	SignalChatID int
+	// SignalSources are parsed with ParseSourceConfig
+	SignalSources []string
+	Parser        string
+	WebhookAddr   string
+	WebhookSecret string
+
+	MaxTrades    int
+	MaxTarget    int
+	BalanceRatio float64
+	Sizing       string
+	MinNotional  float64
+	Currency     string
+	TrailingStop string
+	// TrailingStep is a percentage
+	TrailingStep float64
+	TakeProfit   string
+	EntryExpiry  time.Duration
+	SamePair     bool
+
+	// MaxDailyLoss and MaxWeeklyLoss are in quote currency and MaxDrawdown
+	// is a percentage, limits are disabled if they are zero
+	MaxDailyLoss  float64
+	MaxWeeklyLoss float64
+	MaxDrawdown   float64
+	LossSell      bool
+
+	Dry        bool
+	DryPrices  string
+	DryBalance float64
+	// DryMakerFee and DryTakerFee are percentages
+	DryMakerFee float64
+	DryTakerFee float64
+
+	// Notifiers are parsed with notify.Parse
+	Notifiers   []string
+	HTTPAddr    string
+	HTTPToken   string
+	MetricsAddr string
+	Debug       bool
+}
+
+// NewBot creates a bot using telegram, binance or the paper exchange in dry
+// mode and the store from the uri or the bolt db path.
+// Bot events are also sent to the notifiers.
+// Signals are read from the signal sources or from the signal chat using the
+// parser if none is provided.
+func NewBot(cfg Config) (*Bot, error) {
+	if cfg.HTTPAddr != "" && cfg.HTTPToken == "" {
+		return nil, errors.New("zeken: http token is required to enable the http api")
+	}
+	tgbot, err := telegram.New(cfg.Token, cfg.ControlChatID)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't create telegram bot: %w", err)
+	}
+	log := tgbot.Print
+	var notifier notify.Notifier
+	if len(cfg.Notifiers) > 0 {
+		var ns []notify.Notifier
+		for _, uri := range cfg.Notifiers {
+			n, err := notify.Parse(uri)
+			if err != nil {
+				return nil, fmt.Errorf("zeken: couldn't create notifier: %w", err)
+			}
+			ns = append(ns, n)
+		}
+		notifier = notify.Multi(ns...)
+	}
+	var ex exchange.Exchange
+	if cfg.Dry {
+		// Use binance live prices unless a prices file is provided
+		var prices paper.PriceSource
+		if cfg.DryPrices != "" {
+			prices = paper.NewFilePrices(cfg.DryPrices)
+		} else {
+			prices = binance.New(log, "", "", cfg.Proxy, cfg.Debug)
+		}
+		statePath := fmt.Sprintf("%s.json", strings.TrimSuffix(cfg.DBPath, ".db"))
+		balances := map[string]decimal.Decimal{cfg.Currency: decimal.NewFromFloat(cfg.DryBalance)}
+		ex, err = paper.New(log, prices, statePath, balances, decimal.NewFromFloat(cfg.DryMakerFee/100), decimal.NewFromFloat(cfg.DryTakerFee/100))
+		if err != nil {
+			return nil, fmt.Errorf("zeken: couldn't create paper exchange: %w", err)
+		}
+	} else {
+		ex = binance.New(log, cfg.APIKey, cfg.APISecret, cfg.Proxy, cfg.Debug)
+	}
+
+	var mt *metrics.Metrics
+	if cfg.MetricsAddr != "" {
+		mt = metrics.New()
+		ex = mt.Exchange(ex)
+	}
+
+	signalParser, err := parser.NewParser(cfg.Parser)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't create parser %s: %w", cfg.Parser, err)
+	}
+	trailing, err := trade.ParseTrailingStop(cfg.TrailingStop, cfg.TrailingStep)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't parse trailing stop: %w", err)
+	}
+	allocation, err := trade.ParseAllocation(cfg.TakeProfit)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't parse take profit: %w", err)
+	}
+	store, err := newStore(cfg.StoreURI, cfg.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't create db: %w", err)
+	}
+	var opts []Option
+	if len(cfg.SignalSources) == 0 {
+		opts = append(opts, WithSource(newTelegramSource(tgbot, int64(cfg.SignalChatID)), SourceSettings{Name: "telegram"}))
+	}
+	for _, value := range cfg.SignalSources {
+		src, err := ParseSourceConfig(value)
+		if err != nil {
+			return nil, err
+		}
+		if src.ParserName != "" {
+			src.Parser, err = parser.NewParser(src.ParserName)
+			if err != nil {
+				return nil, fmt.Errorf("zeken: couldn't create parser %s for source %s: %w", src.ParserName, src.Name, err)
+			}
+		}
+		opts = append(opts, WithSource(newTelegramSource(tgbot, src.ChatID), src.SourceSettings))
+	}
+	if cfg.WebhookAddr != "" {
+		wh, err := webhook.New(cfg.WebhookAddr, cfg.WebhookSecret)
+		if err != nil {
+			return nil, fmt.Errorf("zeken: couldn't create webhook: %w", err)
+		}
+		opts = append(opts, WithSource(wh, SourceSettings{Name: "webhook", Parser: tradingview.Parser{}}))
+	}
+	if cfg.Sizing != "" {
+		strategy, err := sizing.Parse(cfg.Sizing)
+		if err != nil {
+			return nil, fmt.Errorf("zeken: couldn't parse sizing: %w", err)
+		}
+		opts = append(opts, WithSizing(strategy))
+	}
+	limits := risk.Limits{
+		DailyLoss:  decimal.NewFromFloat(cfg.MaxDailyLoss),
+		WeeklyLoss: decimal.NewFromFloat(cfg.MaxWeeklyLoss),
+		Drawdown:   decimal.NewFromFloat(cfg.MaxDrawdown / 100),
+	}
+	if limits.Enabled() {
+		guardPath := fmt.Sprintf("%s-guard.json", strings.TrimSuffix(cfg.DBPath, ".db"))
+		guard, err := risk.New(limits, guardPath)
+		if err != nil {
+			return nil, fmt.Errorf("zeken: couldn't create loss guard: %w", err)
+		}
+		opts = append(opts, WithGuard(guard, cfg.LossSell))
+	}
+	opts = append(opts,
+		WithMaxTrades(cfg.MaxTrades),
+		WithMaxTarget(cfg.MaxTarget),
+		WithBalanceRatio(cfg.BalanceRatio),
+		WithMinNotional(decimal.NewFromFloat(cfg.MinNotional)),
+		WithCurrency(cfg.Currency),
+		WithTrailingStop(trailing),
+		WithAllocation(allocation),
+		WithSamePair(cfg.SamePair),
+		WithEntryExpiry(cfg.EntryExpiry),
+		WithDry(cfg.Dry),
+		WithLog(log),
+		WithNotifier(notifier),
+		WithHTTP(cfg.HTTPAddr, cfg.HTTPToken),
+		WithMetrics(mt, cfg.MetricsAddr),
+	)
+	return New(tgbot, ex, store, signalParser, opts...), nil
+}
+
+// newStore creates a trade store from an uri like `sqlite://path`.
+// A bolt store using the db path is returned if the uri is empty.
+func newStore(uri, dbPath string) (trade.Store, error) {
+	scheme, path := "bolt", dbPath
+	if uri != "" {
+		parts := strings.SplitN(uri, "://", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("zeken: invalid store %s", uri)
+		}
+		scheme, path = parts[0], parts[1]
+	}
+	switch scheme {
+	case "bolt":
+		s, err := bolt.New(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "sqlite":
+		s, err := sqlite.New(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("zeken: unsupported store %s", scheme)
+	}
+}
+
+// Subscribe adds a subscriber to bot and trade events and returns a function
+// to remove it
+func (b *Bot) Subscribe(fn func(e event.Event)) func() {
+	return b.bus.Subscribe(fn)
 }
 
 func (b *Bot) Run(ctx context.Context) error {
 	b.ctx, b.cancel = context.WithCancel(ctx)
-	b.log(fmt.Sprintf("🤖 zeken bot running\n- version: %s\n- dry mode: %t", version, b.dry))
-	defer b.log("🛑 zeken bot stopped")
+	b.bus.Publish(&event.BotStarted{Time: b.clock.Now().UTC(), Version: version, Dry: b.dry})
+	defer func() {
+		b.bus.Publish(&event.BotStopped{Time: b.clock.Now().UTC()})
+	}()
+	if b.httpAddr != "" {
+		if err := b.serve(b.ctx, b.httpAddr, b.Handler(b.httpToken)); err != nil {
+			return err
+		}
+	}
+	if b.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", b.metrics.Handler(b.updateMetrics))
+		if err := b.serve(b.ctx, b.metricsAddr, mux); err != nil {
+			return err
+		}
+	}
+	for _, s := range b.sources {
+		s := s
+		go func() {
+			if err := s.Run(b.ctx, func(msg *signal.Message) error {
+				return b.handle(s, msg)
+			}); err != nil {
+				b.error(fmt.Errorf("zeken: source %s failed: %w", s.Name, err))
+			}
+		}()
+	}
 	if err := b.resume(); err != nil {
-		b.log(err)
+		b.error(err)
+	}
+	if b.guard != nil {
+		go b.runGuard(b.ctx)
 	}
 	return b.run(b.ctx)
 }
 
-func (b *Bot) handle(text string) {
-	sig, err := b.parser.Parse(text)
+// handle parses and applies a message of the source, new signals start a
+// trade and follow-ups are applied to the trade they refer to
+func (b *Bot) handle(src *source, msg *signal.Message) error {
+	b.bus.Publish(&event.SignalReceived{Time: b.clock.Now().UTC(), Source: src.Name, Text: msg.Text})
+	parser := src.Parser
+	if parser == nil {
+		parser = b.parser
+	}
+	action, err := parseAction(parser, msg.Text)
+	reason := "parse"
+	if err == nil {
+		err = b.apply(src, msg, action)
+		reason = rejectReason(err)
+	}
 	if err != nil {
-		b.log(err)
-		return
+		b.bus.Publish(&event.SignalRejected{Time: b.clock.Now().UTC(), Source: src.Name, Text: msg.Text, Reason: reason, Err: err})
 	}
-	if err := b.signal(sig); err != nil {
-		b.log(err)
-		return
+	return err
+}
+
+var (
+	errMaxTrades     = errors.New("maximum number of trades running")
+	errSamePair      = errors.New("there is already a running trade")
+	errBalance       = errors.New("couldn't get balance")
+	errUnsupported   = errors.New("not supported")
+	errInvalidSignal = errors.New("invalid signal")
+	errNoTrade       = errors.New("no running trade")
+	errPaused        = errors.New("signals paused")
+	errSize          = errors.New("couldn't size trade")
+)
+
+// rejectReason returns a short identifier of the cause of a signal rejection
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, errMaxTrades):
+		return "max_trades"
+	case errors.Is(err, errSamePair):
+		return "same_pair"
+	case errors.Is(err, errBalance):
+		return "balance"
+	case errors.Is(err, errUnsupported):
+		return "unsupported"
+	case errors.Is(err, errInvalidSignal):
+		return "invalid"
+	case errors.Is(err, errNoTrade):
+		return "no_trade"
+	case errors.Is(err, errPaused):
+		return "paused"
+	case errors.Is(err, errSize):
+		return "size"
+	default:
+		return "other"
+	}
+}
+
+// openTrades returns the running trades sorted by start time
+func (b *Bot) openTrades() []*trade.Trader {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var trades []*trade.Trader
+	for _, t := range b.trades {
+		trades = append(trades, t)
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].StartTime.Before(trades[j].StartTime)
+	})
+	return trades
+}
+
+// history returns the trades finished between the dates sorted by start time
+func (b *Bot) history(from, to time.Time) ([]*trade.Trade, error) {
+	trades, err := b.store.List(from, to, true)
+	if err != nil {
+		return nil, fmt.Errorf("zeken: couldn't list trades: %w", err)
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].StartTime.Before(trades[j].StartTime)
+	})
+	return trades, nil
+}
+
+// sell forces the sale of a running trade
+func (b *Bot) sell(id string) (*trade.Trader, error) {
+	b.lock.Lock()
+	t, ok := b.trades[id]
+	b.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("trade %s not found", id)
 	}
+	t.Sell()
+	return t, nil
+}
+
+// updateMetrics refreshes the open trades and balance gauges
+func (b *Bot) updateMetrics(ctx context.Context) {
+	var trades []metrics.TradeProfit
+	now := b.clock.Now()
+	for _, tr := range b.openTrades() {
+		t := tr.Snapshot()
+		profit, _, _ := t.Status(now)
+		trades = append(trades, metrics.TradeProfit{Trade: t.ID, Base: t.Base, Quote: t.Quote, Profit: profit})
+	}
+	b.metrics.SetTrades(trades)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if balance, err := b.exchange.Balance(ctx, b.currency); err == nil {
+		b.metrics.SetBalance(b.currency, balance)
+	}
+}
+
+// error publishes a bot error
+func (b *Bot) error(err error) {
+	b.bus.Publish(&event.Error{Time: b.clock.Now().UTC(), Err: err})
 }
 
 func (b *Bot) resume() error {
-	to := time.Now().UTC().Add(24 * time.Hour)
-	from := time.Now().UTC().Add(-365 * 24 * time.Hour)
+	to := b.clock.Now().UTC().Add(24 * time.Hour)
+	from := b.clock.Now().UTC().Add(-365 * 24 * time.Hour)
 	trades, err := b.store.List(from, to, false)
 	if err != nil {
 		return fmt.Errorf("zeken: couldn't get trades from db: %w", err)
 	}
 	for _, tr := range trades {
 		tr := tr
-		trader := trade.NewTrader(b.log, b.exchange, tr, b.maxTarget, 5*time.Second, b.store.Update)
+		maxTarget := b.source(tr.Source).maxTarget(b.maxTarget)
+		trader := trade.NewTrader(b.bus.Publish, b.exchange, b.clock, tr, maxTarget, b.wait, b.store.Update, b.store.AddOrder)
 		b.lock.Lock()
-		b.trades[tr.Base] = trader
+		b.trades[tr.ID] = trader
 		b.lock.Unlock()
 		go func() {
 			b.trade(trader, false)
@@ -199,91 +727,188 @@ func (b *Bot) resume() error {
 	return nil
 }
 
-func (b *Bot) signal(sig *signal.Signal) error {
-	if sig.Quote != b.currency {
-		return fmt.Errorf("zeken: quote currency %s not supported", sig.Quote)
+// signal starts a trade of the signal, id is the id of the source message
+func (b *Bot) signal(sig *signal.Signal, src *source, id string) error {
+	trader, err := b.open(sig, src, id)
+	if err != nil {
+		return err
 	}
-	var found bool
-	for _, e := range sig.Exchanges {
-		if e == "BINANCE" {
-			found = true
-			break
-		}
+	go func() {
+		b.trade(trader, true)
+	}()
+	return nil
+}
+
+// open checks the signal against the bot limits and adds a trader sized for
+// it to the running trades without starting it
+func (b *Bot) open(sig *signal.Signal, src *source, id string) (*trade.Trader, error) {
+	if err := validate(sig, b.currency); err != nil {
+		return nil, err
 	}
-	if !found {
-		return fmt.Errorf("zeken: exchange %v not supported", sig.Exchanges)
+	b.checkGuard()
+	if trip := b.tripped(); trip != nil {
+		return nil, fmt.Errorf("%w by %s limit", errPaused, trip.Reason)
 	}
 	b.lock.Lock()
 	defer b.lock.Unlock()
 	if len(b.trades) >= b.maxTrades {
-		return fmt.Errorf("maximum number of trades running: %d", len(b.trades))
-	}
-	if _, ok := b.trades[sig.Base]; ok {
-		return fmt.Errorf("there is already a running trade for %s", sig.Base)
+		return nil, fmt.Errorf("%w: %d", errMaxTrades, len(b.trades))
 	}
-
-	// Calculate quote quantity based on open trades, available balance and balance ratio
-	quoteQty := decimal.NewFromFloat(10.0)
-	if !b.dry {
-		openTradesQty := decimal.Zero
+	if src.MaxTrades > 0 {
+		var n int
 		for _, t := range b.trades {
-			openTradesQty = openTradesQty.Add(t.QuoteQuantity)
+			if t.Source == src.Name {
+				n++
+			}
 		}
-
-		available, err := b.exchange.Balance(b.ctx, sig.Quote)
-		if err != nil {
-			return fmt.Errorf("couldn't get balance: %w", err)
+		if n >= src.MaxTrades {
+			return nil, fmt.Errorf("%w for source %s: %d", errMaxTrades, src.Name, n)
 		}
-		quoteQty = openTradesQty.Add(available).Mul(decimal.NewFromFloat(b.balanceRatio)).Div(decimal.NewFromInt(int64(b.maxTrades)))
-		if quoteQty.GreaterThanOrEqual(available) {
-			quoteQty = available.Mul(decimal.NewFromFloat(0.99))
+	}
+	if !b.samePair {
+		for _, t := range b.trades {
+			if t.Base == sig.Base && t.Quote == sig.Quote {
+				return nil, fmt.Errorf("%w for %s", errSamePair, sig.Base)
+			}
 		}
 	}
 
+	quoteQty, err := b.size(sig, src)
+	if err != nil {
+		return nil, err
+	}
+
 	tr := trade.New(sig.Base, sig.Quote, sig.Start, sig.Targets, sig.Stop, quoteQty)
-	trader := trade.NewTrader(b.log, b.exchange, tr, b.maxTarget, 5*time.Second, b.store.Update)
-	b.trades[tr.Base] = trader
+	tr.StartTime = b.clock.Now().UTC()
+	tr.Trailing = b.trailing.Copy()
+	tr.Allocation = allocation(sig, b.allocation)
+	setEntry(tr, sig, b.entryExpiry)
+	tr.Source = src.Name
+	tr.SignalID = id
+	trader := trade.NewTrader(b.bus.Publish, b.exchange, b.clock, tr, src.maxTarget(b.maxTarget), b.wait, b.store.Update, b.store.AddOrder)
+	b.trades[tr.ID] = trader
+	return trader, nil
+}
 
-	go func() {
-		b.trade(trader, true)
-	}()
+// validate checks that the signal can be traded
+func validate(sig *signal.Signal, currency string) error {
+	if sig.Quote != currency {
+		return fmt.Errorf("zeken: quote currency %s %w", sig.Quote, errUnsupported)
+	}
+	var found bool
+	for _, e := range sig.Exchanges {
+		if e == "BINANCE" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("zeken: exchange %v %w", sig.Exchanges, errUnsupported)
+	}
+	if err := trade.ValidateAllocation(sig.Allocation); err != nil {
+		return fmt.Errorf("zeken: %w allocation: %v", errInvalidSignal, err)
+	}
+	if sig.EntryLow.IsPositive() || sig.EntryHigh.IsPositive() {
+		if !sig.EntryLow.IsPositive() || sig.EntryLow.GreaterThan(sig.EntryHigh) {
+			return fmt.Errorf("zeken: %w entry zone: %s - %s", errInvalidSignal, sig.EntryLow, sig.EntryHigh)
+		}
+		if !sig.Stop.LessThan(sig.EntryLow) {
+			return fmt.Errorf("zeken: %w stop %s isn't below entry zone", errInvalidSignal, sig.Stop)
+		}
+	}
 	return nil
 }
 
+// setEntry sets the entry zone of the signal to the trade, the expiry is used
+// if the signal doesn't have one
+func setEntry(tr *trade.Trade, sig *signal.Signal, expiry time.Duration) {
+	if !sig.EntryHigh.IsPositive() {
+		return
+	}
+	tr.EntryLow = sig.EntryLow
+	tr.EntryHigh = sig.EntryHigh
+	if tr.StartPrice.IsZero() {
+		tr.StartPrice = sig.EntryHigh
+	}
+	if sig.Expiry > 0 {
+		expiry = sig.Expiry
+	}
+	if expiry > 0 {
+		tr.EntryExpiry = tr.StartTime.Add(expiry)
+	}
+}
+
+// allocation returns the signal allocation if set or the default one
+func allocation(sig *signal.Signal, defaultAllocation []decimal.Decimal) []decimal.Decimal {
+	if len(sig.Allocation) > 0 {
+		return sig.Allocation
+	}
+	return defaultAllocation
+}
+
 func (b *Bot) trade(t *trade.Trader, new bool) {
 	defer func() {
 		b.lock.Lock()
-		delete(b.trades, t.Base)
+		delete(b.trades, t.ID)
 		b.lock.Unlock()
 	}()
-	b.log(fmt.Sprintf("⚙️ running trade %s", t.Base))
-	if new {
-		if err := t.Create(b.ctx); err != nil {
-			b.log(err)
+	b.bus.Publish(&event.TradeStarted{
+		Time:    b.clock.Now().UTC(),
+		Trade:   t.ID,
+		Base:    t.Base,
+		Quote:   t.Quote,
+		Source:  t.Source,
+		Resumed: !new,
+	})
+	// Pending entries are resumed by creating the trade again
+	if new || t.Pending() {
+		err := t.Create(b.ctx)
+		// Nothing has been bought if the quantity is too small
+		if errors.Is(err, exchange.ErrDust) {
+			b.error(err)
+		}
+		if errors.Is(err, trade.ErrEntryExpired) || errors.Is(err, trade.ErrEntryCanceled) || errors.Is(err, exchange.ErrDust) {
+			if err := b.store.Delete(t.Trade); err != nil {
+				b.error(fmt.Errorf("zeken: couldn't delete trade: %w", err))
+			}
+			return
+		}
+		if err != nil {
+			b.error(err)
 			return
 		}
 	}
 	err := t.Run(b.ctx)
 	if err != nil {
-		b.log(err)
+		b.error(err)
 	}
-	if errors.Is(err, context.Canceled) {
+	// Unfinished trades are kept in the store to be resumed later
+	if err != nil && !errors.Is(err, exchange.ErrOrderCanceled) {
 		return
 	}
+	closed := &event.TradeClosed{
+		Time:   b.clock.Now().UTC(),
+		Trade:  t.ID,
+		Base:   t.Base,
+		Quote:  t.Quote,
+		Source: t.Source,
+	}
 	if errors.Is(err, exchange.ErrOrderCanceled) {
-		b.log(fmt.Sprintf("⚠️ %s finished with error because order was canceled, it will be removed from database", t.Base))
+		closed.Canceled = true
+		b.bus.Publish(closed)
 		if err := b.store.Delete(t.Trade); err != nil {
-			b.log(fmt.Errorf("zeken: couldn't delete trade: %w", err))
+			b.error(fmt.Errorf("zeken: couldn't delete trade: %w", err))
 		}
 		return
 	}
 
-	profit, perc, elapsed := t.Status()
-	emoji := "💰"
-	if profit.LessThan(decimal.Zero) {
-		emoji = "❌"
-	}
-	b.log(emoji, fmt.Sprintf("finished %s %s%% %s %s %s", t.Base, perc.Mul(decimal.NewFromInt(100)).StringFixed(2), profit.StringFixed(2), t.Quote, elapsed.Round(time.Second)))
+	_, _, closed.Elapsed = t.Status()
+	closed.QuoteQuantity = t.QuoteQuantity
+	closed.EndQuoteQuantity = t.EndQuoteQuantity
+	closed.Profit = t.EndQuoteQuantity.Sub(t.QuoteQuantity)
+	closed.Percentage = closed.Profit.Div(t.QuoteQuantity)
+	b.bus.Publish(closed)
+	b.checkGuard()
 }
 
 func (b *Bot) shutdown() {
@@ -0,0 +1,766 @@
+package zeken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/zeken/pkg/clock"
+	"github.com/igolaizola/zeken/pkg/event"
+	"github.com/igolaizola/zeken/pkg/exchange"
+	"github.com/igolaizola/zeken/pkg/exchange/backtest"
+	"github.com/igolaizola/zeken/pkg/risk"
+	"github.com/igolaizola/zeken/pkg/signal"
+	"github.com/igolaizola/zeken/pkg/signal/parser/json"
+	"github.com/igolaizola/zeken/pkg/signal/parser/tradingview"
+	"github.com/igolaizola/zeken/pkg/sizing"
+	"github.com/igolaizola/zeken/pkg/trade"
+	"github.com/igolaizola/zeken/pkg/trade/inmem"
+	"github.com/shopspring/decimal"
+)
+
+func TestNewStore(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		uri string
+		err bool
+	}{
+		{uri: ""},
+		{uri: "bolt://" + filepath.Join(dir, "trades.db")},
+		{uri: "sqlite://" + filepath.Join(dir, "trades.sqlite")},
+		{uri: "sqlite://", err: true},
+		{uri: "postgres://localhost", err: true},
+		{uri: "trades.db", err: true},
+	}
+	for _, tt := range tests {
+		s, err := newStore(tt.uri, filepath.Join(dir, "zeken.db"))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.uri, err)
+			continue
+		}
+		if _, err := s.List(time.Time{}, time.Now(), false); err != nil {
+			t.Errorf("%s: %v", tt.uri, err)
+		}
+	}
+}
+
+func TestBotTrade(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	klines := backtest.Series{"IGOUSDT": newCandles(start, 10.0, 0.1, 100)}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	store := inmem.New()
+	m := newMockMessenger()
+	src := newMockSource()
+	b := New(m, ex, store, json.Parser{}, WithClock(clk), WithSource(src, SourceSettings{Name: "vip"}))
+	closed := make(chan *event.TradeClosed, 1)
+	b.Subscribe(func(e event.Event) {
+		if e, ok := e.(*event.TradeClosed); ok {
+			closed <- e
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx)
+	}()
+	m.wait(t, "zeken bot running")
+
+	src.signal(t, `{"exchanges": ["BINANCE"], "base": "IGO", "quote": "USDT", "start": "10", "targets": ["11", "12", "13"], "stop": "9"}`)
+	m.wait(t, "finished")
+	select {
+	case e := <-closed:
+		if want := decimal.NewFromFloat(59.4); !e.Profit.Equal(want) {
+			t.Errorf("wrong closed event profit: want %s, got %s", want, e.Profit)
+		}
+		if e.Source != "vip" {
+			t.Errorf("wrong closed event source: want vip, got %s", e.Source)
+		}
+	default:
+		t.Error("trade closed event not published")
+	}
+
+	trades, err := store.List(start, start.Add(24*time.Hour), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 {
+		t.Fatalf("wrong number of finished trades: want 1, got %d", len(trades))
+	}
+	tr := trades[0]
+	// 99% of the balance divided by 5 max trades, bought at 10 and sold at
+	// the last target 13
+	if want := decimal.NewFromInt(198); !tr.QuoteQuantity.Equal(want) {
+		t.Errorf("wrong quote quantity: want %s, got %s", want, tr.QuoteQuantity)
+	}
+	if want := decimal.NewFromFloat(257.4); !tr.EndQuoteQuantity.Equal(want) {
+		t.Errorf("wrong end quote quantity: want %s, got %s", want, tr.EndQuoteQuantity)
+	}
+	if tr.Source != "vip" {
+		t.Errorf("wrong source: want vip, got %s", tr.Source)
+	}
+	if tr.CurrentTarget != 2 {
+		t.Errorf("wrong current target: want 2, got %d", tr.CurrentTarget)
+	}
+	events, err := store.Orders(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decimal.NewFromInt(13); !trade.ExitPrice(events).Equal(want) {
+		t.Errorf("wrong exit price: want %s, got %s", want, trade.ExitPrice(events))
+	}
+
+	m.command("history", "")
+	m.wait(t, fmt.Sprintf("%s IGO: 59.40 USDT", tr.ID))
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBotTradeError(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	klines := backtest.Series{"IGOUSDT": newCandles(start, 10.0, 0.1, 100)}
+	ex := &failingExchange{
+		Exchange: backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)}),
+		err:      errors.New("unavailable"),
+	}
+	store := inmem.New()
+	m := newMockMessenger()
+	src := newMockSource()
+	b := New(m, ex, store, json.Parser{}, WithClock(clk), WithSource(src, SourceSettings{}))
+	failed := make(chan struct{})
+	var closed int
+	b.Subscribe(func(e event.Event) {
+		switch e := e.(type) {
+		case *event.TradeClosed:
+			closed++
+		case *event.Error:
+			if e.Trade == "" && errors.Is(e.Err, ex.err) {
+				close(failed)
+			}
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Run(ctx)
+	}()
+	m.wait(t, "zeken bot running")
+
+	// Orders can't be created again after the first target is hit
+	if err := src.signal(t, `{"exchanges": ["BINANCE"], "base": "IGO", "quote": "USDT", "start": "10", "targets": ["11", "12"], "stop": "9"}`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("trade didn't fail")
+	}
+	if closed != 0 {
+		t.Errorf("trade closed event published for a failed trade")
+	}
+	// The trade is kept to be resumed later
+	trades, err := store.List(start, start.Add(24*time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 || !trades[0].EndTime.IsZero() {
+		t.Errorf("trade should be kept open: %+v", trades)
+	}
+}
+
+func TestBotSignalRejected(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	// Prices don't move, so trades keep running
+	klines := backtest.Series{
+		"IGOUSDT": newCandles(start, 10.0, 0, 10),
+		"ZKNUSDT": newCandles(start, 10.0, 0, 10),
+		"BTCUSDT": newCandles(start, 10.0, 0, 10),
+	}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	m := newMockMessenger()
+	b := New(m, ex, inmem.New(), json.Parser{}, WithClock(clk), WithMaxTrades(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Run(ctx)
+	}()
+	m.wait(t, "zeken bot running")
+
+	sig := func(base, quote string) *signal.Signal {
+		return &signal.Signal{
+			Exchanges: []string{"BINANCE"},
+			Base:      base,
+			Quote:     quote,
+			Start:     decimal.NewFromInt(10),
+			Targets:   []decimal.Decimal{decimal.NewFromInt(11)},
+			Stop:      decimal.NewFromInt(9),
+		}
+	}
+	tests := []struct {
+		sig *signal.Signal
+		err string
+	}{
+		{sig: sig("IGO", "USDT")},
+		{sig: sig("IGO", "BTC"), err: "quote currency BTC not supported"},
+		{sig: sig("IGO", "USDT"), err: "already a running trade for IGO"},
+		{sig: sig("ZKN", "USDT")},
+		{sig: sig("BTC", "USDT"), err: "maximum number of trades running: 2"},
+	}
+	for _, tt := range tests {
+		err := b.signal(tt.sig, b.source(""), "")
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.sig.Base, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: want error %q, got %v", tt.sig.Base, tt.err, err)
+		}
+	}
+
+	m.command("status", "")
+	m.wait(t, "ZKN")
+}
+
+func TestBotSources(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	klines := backtest.Series{
+		"IGOUSDT": newCandles(start, 10.0, 0, 10),
+		"ZKNUSDT": newCandles(start, 10.0, 0, 10),
+		"BTCUSDT": newCandles(start, 10.0, 0, 10),
+	}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	m := newMockMessenger()
+	chat := newMockSource()
+	alerts := newMockSource()
+	b := New(m, ex, inmem.New(), json.Parser{}, WithClock(clk),
+		WithSource(chat, SourceSettings{Name: "chat", MaxTrades: 1, BalanceRatio: 0.5}),
+		WithSource(alerts, SourceSettings{Name: "alerts", Parser: tradingview.Parser{}}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Run(ctx)
+	}()
+
+	if err := chat.signal(t, `{"exchanges": ["BINANCE"], "base": "IGO", "quote": "USDT", "start": "10", "targets": ["11"], "stop": "9"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := alerts.signal(t, `{"exchange": "BINANCE", "ticker": "ZKNUSDT", "price": 10, "targets": ["10%"], "stop": "10%"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := chat.signal(t, `{"exchange": "BINANCE", "ticker": "ZKNUSDT"}`); err == nil {
+		t.Error("alert should be rejected by the chat parser")
+	}
+	err := chat.signal(t, `{"exchanges": ["BINANCE"], "base": "BTC", "quote": "USDT", "start": "10", "targets": ["11"], "stop": "9"}`)
+	if !errors.Is(err, errMaxTrades) {
+		t.Errorf("source max trades should be reached, got %v", err)
+	}
+	trades := b.openTrades()
+	if n := len(trades); n != 2 {
+		t.Fatalf("wrong number of open trades: want 2, got %d", n)
+	}
+	sources := map[string]*trade.Trade{}
+	for _, tr := range trades {
+		sources[tr.Source] = tr.Snapshot().Trade
+	}
+	// Half of the balance divided by 5 max trades
+	if tr, ok := sources["chat"]; !ok || tr.Base != "IGO" || !tr.QuoteQuantity.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("wrong chat trade: %+v", tr)
+	}
+	if tr, ok := sources["alerts"]; !ok || tr.Base != "ZKN" {
+		t.Errorf("wrong alerts trade: %+v", tr)
+	}
+}
+
+func TestBotSizing(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	klines := backtest.Series{
+		"IGOUSDT": newCandles(start, 10.0, 0, 10),
+		"ZKNUSDT": newCandles(start, 10.0, 0, 10),
+		"BTCUSDT": newCandles(start, 10.0, 0, 10),
+	}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	m := newMockMessenger()
+	b := New(m, ex, inmem.New(), json.Parser{}, WithClock(clk),
+		WithSizing(&sizing.Percent{Ratio: decimal.NewFromFloat(0.1)}),
+		WithSource(newMockSource(), SourceSettings{Name: "fixed", Sizing: &sizing.Fixed{Amount: decimal.NewFromInt(50)}}),
+		WithSource(newMockSource(), SourceSettings{Name: "small", Sizing: &sizing.Fixed{Amount: decimal.NewFromInt(5)}}),
+		// Without history the balance ratio is used
+		WithSource(newMockSource(), SourceSettings{Name: "kelly", BalanceRatio: 0.5, Sizing: &sizing.Kelly{Fraction: decimal.NewFromInt(1), MinTrades: 5}}),
+	)
+	bought := make(chan *event.BuyFilled, 1)
+	b.Subscribe(func(e event.Event) {
+		if e, ok := e.(*event.BuyFilled); ok {
+			bought <- e
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Run(ctx)
+	}()
+	m.wait(t, "zeken bot running")
+
+	sig := func(base string) *signal.Signal {
+		return &signal.Signal{
+			Exchanges: []string{"BINANCE"},
+			Base:      base,
+			Quote:     "USDT",
+			Start:     decimal.NewFromInt(10),
+			Targets:   []decimal.Decimal{decimal.NewFromInt(11)},
+			Stop:      decimal.NewFromInt(9),
+		}
+	}
+	tests := []struct {
+		source string
+		base   string
+		want   decimal.Decimal
+	}{
+		{source: "fixed", base: "IGO", want: decimal.NewFromInt(50)},
+		{source: "", base: "ZKN", want: decimal.NewFromInt(100)},
+		{source: "kelly", base: "BTC", want: decimal.NewFromInt(100)},
+	}
+	for _, tt := range tests {
+		if err := b.signal(sig(tt.base), b.source(tt.source), ""); err != nil {
+			t.Fatalf("%s: %v", tt.source, err)
+		}
+		// Wait for the balance to be updated
+		select {
+		case <-bought:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: trade not bought", tt.source)
+		}
+		var found bool
+		for _, trader := range b.openTrades() {
+			tr := trader.Snapshot()
+			if tr.Base != tt.base {
+				continue
+			}
+			found = true
+			if !tr.QuoteQuantity.Equal(tt.want) {
+				t.Errorf("%s: want quote quantity %s, got %s", tt.source, tt.want, tr.QuoteQuantity)
+			}
+		}
+		if !found {
+			t.Errorf("%s: trade not found", tt.source)
+		}
+	}
+	err := b.signal(sig("ETH"), b.source("small"), "")
+	if !errors.Is(err, errSize) || rejectReason(err) != "size" {
+		t.Errorf("want error %v, got %v", errSize, err)
+	}
+
+	// Pending trades aren't counted twice in the equity
+	pending := trade.New("ETH", "USDT", decimal.NewFromInt(10), nil, decimal.NewFromInt(9), decimal.NewFromInt(100))
+	b.lock.Lock()
+	b.trades[pending.ID] = trade.NewTrader(b.bus.Publish, ex, clk, pending, 5, time.Second, func(*trade.Trade) error { return nil }, nil)
+	got, err := b.size(sig("ETH"), b.source(""))
+	b.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := decimal.NewFromInt(100); !got.Equal(want) {
+		t.Errorf("pending: want quote quantity %s, got %s", want, got)
+	}
+}
+
+func TestBotEntryZone(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	klines := backtest.Series{
+		"IGOUSDT": newCandles(start, 10.0, 0, 200),
+		"ZKNUSDT": newCandles(start, 10.0, 0, 200),
+	}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	store := inmem.New()
+	// Pending trade stored before a restart
+	pending := trade.New("IGO", "USDT", decimal.NewFromInt(11), []decimal.Decimal{decimal.NewFromInt(12)}, decimal.NewFromInt(8), decimal.NewFromInt(100))
+	pending.StartTime = start
+	pending.EntryLow = decimal.NewFromInt(9)
+	pending.EntryHigh = decimal.NewFromInt(11)
+	if err := store.Update(pending); err != nil {
+		t.Fatal(err)
+	}
+	m := newMockMessenger()
+	src := newMockSource()
+	b := New(m, ex, store, json.Parser{}, WithClock(clk), WithSource(src, SourceSettings{}), WithEntryExpiry(24*time.Hour))
+	bought := make(chan *event.BuyFilled, 1)
+	canceled := make(chan *event.EntryCanceled, 1)
+	b.Subscribe(func(e event.Event) {
+		switch e := e.(type) {
+		case *event.BuyFilled:
+			bought <- e
+		case *event.EntryCanceled:
+			canceled <- e
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Run(ctx)
+	}()
+
+	select {
+	case e := <-bought:
+		if e.Trade != pending.ID {
+			t.Errorf("wrong bought trade: want %s, got %s", pending.ID, e.Trade)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending trade not resumed")
+	}
+
+	// Price never enters the zone
+	if err := src.signal(t, `{"exchanges": ["BINANCE"], "base": "ZKN", "quote": "USDT", "entry": ["5", "6"], "expiry": "1h", "targets": ["11"], "stop": "4"}`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-canceled:
+		if !e.Expired || e.Base != "ZKN" {
+			t.Errorf("wrong canceled entry: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending entry not expired")
+	}
+	m.wait(t, "ZKN entry expired")
+
+	// Expired trades are removed from the store
+	timeout := time.After(5 * time.Second)
+	for {
+		trades, err := store.List(start.Add(-time.Hour), clk.Now(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trades) == 1 && trades[0].ID == pending.ID {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("expired trade not removed: %d trades", len(trades))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestBotFollowUp(t *testing.T) {
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	klines := backtest.Series{
+		"IGOUSDT": newCandles(start, 10.0, 0, 200),
+		"ZKNUSDT": newCandles(start, 10.0, 0, 200),
+	}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	m := newMockMessenger()
+	src := newMockSource()
+	b := New(m, ex, inmem.New(), json.Parser{}, WithClock(clk), WithSource(src, SourceSettings{Name: "chat"}))
+	updated := make(chan *event.TradeUpdated, 1)
+	closed := make(chan *event.TradeClosed, 1)
+	b.Subscribe(func(e event.Event) {
+		switch e := e.(type) {
+		case *event.TradeUpdated:
+			updated <- e
+		case *event.TradeClosed:
+			closed <- e
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Run(ctx)
+	}()
+
+	for i, base := range []string{"IGO", "ZKN"} {
+		msg := &signal.Message{
+			ID:   fmt.Sprint(i + 1),
+			Text: fmt.Sprintf(`{"exchanges": ["BINANCE"], "base": "%s", "quote": "USDT", "start": "10", "targets": ["11", "12"], "stop": "9"}`, base),
+		}
+		if err := src.send(t, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Replies are linked to the trade of the replied message
+	if err := src.send(t, &signal.Message{ReplyTo: "1", Text: `{"action": "move_stop", "stop": "9.5"}`}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-updated:
+		if e.Base != "IGO" || !e.StopPrice.Equal(decimal.NewFromFloat(9.5)) {
+			t.Errorf("wrong update: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("trade not updated")
+	}
+
+	// Messages that aren't replies use the pair
+	if err := src.signal(t, `{"action": "close_trade", "base": "ZKN", "quote": "USDT"}`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-closed:
+		if e.Base != "ZKN" {
+			t.Errorf("wrong closed trade: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("trade not closed")
+	}
+
+	for _, msg := range []*signal.Message{
+		{ReplyTo: "3", Text: `{"action": "close_trade"}`},
+		{Text: `{"action": "close_trade", "base": "BTC"}`},
+	} {
+		if err := src.send(t, msg); !errors.Is(err, errNoTrade) {
+			t.Errorf("want error %v, got %v", errNoTrade, err)
+		}
+	}
+	if err := src.send(t, &signal.Message{ReplyTo: "1", Text: `{"action": "cancel_pending"}`}); !errors.Is(err, errInvalidSignal) {
+		t.Errorf("want error %v, got %v", errInvalidSignal, err)
+	}
+}
+
+func TestBotGuard(t *testing.T) {
+	start := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+	klines := backtest.Series{"IGOUSDT": newCandles(start, 10.0, 0, 10)}
+	ex := backtest.New(clk, klines, map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)})
+	store := inmem.New()
+	// Stop loss of a long running trade hit earlier in the day
+	lost := trade.New("ZKN", "USDT", decimal.NewFromInt(10), []decimal.Decimal{decimal.NewFromInt(11)}, decimal.NewFromInt(8), decimal.NewFromInt(100))
+	lost.StartTime = start.AddDate(0, -3, 0)
+	lost.EndTime = start.Add(-time.Hour)
+	lost.EndQuoteQuantity = decimal.NewFromInt(80)
+	if err := store.Update(lost); err != nil {
+		t.Fatal(err)
+	}
+	guard, err := risk.New(risk.Limits{DailyLoss: decimal.NewFromInt(10)}, filepath.Join(t.TempDir(), "guard.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMockMessenger()
+	b := New(m, ex, store, json.Parser{}, WithClock(clk), WithGuard(guard, true))
+	sig := &signal.Signal{
+		Exchanges: []string{"BINANCE"},
+		Base:      "IGO",
+		Quote:     "USDT",
+		Start:     decimal.NewFromInt(10),
+		Targets:   []decimal.Decimal{decimal.NewFromInt(11)},
+		Stop:      decimal.NewFromInt(9),
+	}
+
+	if err := b.signal(sig, b.source(""), ""); !errors.Is(err, errPaused) {
+		t.Fatalf("want error %v, got %v", errPaused, err)
+	}
+	if rejectReason(errPaused) != "paused" {
+		t.Error("wrong reject reason")
+	}
+	m.wait(t, "daily loss limit reached (20.00 of 10.00)")
+	m.command("status", "")
+	m.wait(t, "signals paused by daily loss limit")
+
+	m.command("resume", "")
+	m.wait(t, "signals resumed")
+	if trip := b.tripped(); trip != nil {
+		t.Fatalf("guard should be resumed: %+v", trip)
+	}
+	// Previous losses don't trip the guard again
+	b.checkGuard()
+	if trip := b.tripped(); trip != nil {
+		t.Errorf("guard shouldn't be tripped: %+v", trip)
+	}
+}
+
+func TestParseSourceConfig(t *testing.T) {
+	tests := []struct {
+		value string
+		want  *SourceConfig
+		err   bool
+	}{
+		{
+			value: "name=vip,chat=-100123,parser=json,max-trades=2,balance-ratio=0.5,max-target=3",
+			want: &SourceConfig{
+				SourceSettings: SourceSettings{Name: "vip", MaxTrades: 2, BalanceRatio: 0.5, MaxTarget: 3},
+				ParserName:     "json",
+				ChatID:         -100123,
+			},
+		},
+		{
+			value: "chat=-100123",
+			want:  &SourceConfig{SourceSettings: SourceSettings{Name: "-100123"}, ChatID: -100123},
+		},
+		{value: "name=vip", err: true},
+		{value: "chat=abc", err: true},
+		{value: "chat=1,max-trades", err: true},
+		{value: "chat=1,balance-ratio=2", err: true},
+		{
+			value: "chat=1,sizing=risk:1",
+			want:  &SourceConfig{SourceSettings: SourceSettings{Name: "1", Sizing: &sizing.Risk{Ratio: decimal.NewFromFloat(0.01)}}, ChatID: 1},
+		},
+		{value: "chat=1,sizing=martingale:2", err: true},
+		{value: "chat=1,foo=bar", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSourceConfig(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %+v, got %+v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestProfitBySource(t *testing.T) {
+	newTrade := func(source string, profit int64) *trade.Trade {
+		return &trade.Trade{
+			Source:           source,
+			QuoteQuantity:    decimal.NewFromInt(100),
+			EndQuoteQuantity: decimal.NewFromInt(100 + profit),
+		}
+	}
+	got := profitBySource([]*trade.Trade{
+		newTrade("vip", 10), newTrade("", -5), newTrade("free", 3), newTrade("vip", -4),
+	})
+	want := []sourceProfit{
+		{Source: "free", Trades: 1, Profit: decimal.NewFromInt(3)},
+		{Source: "unknown", Trades: 1, Profit: decimal.NewFromInt(-5)},
+		{Source: "vip", Trades: 2, Profit: decimal.NewFromInt(6)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("wrong number of sources: want %d, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Source != want[i].Source || got[i].Trades != want[i].Trades || !got[i].Profit.Equal(want[i].Profit) {
+			t.Errorf("wrong source profit %d: want %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+type mockMessenger struct {
+	lock     sync.Mutex
+	logs     []string
+	commands map[string]func(string)
+}
+
+func newMockMessenger() *mockMessenger {
+	return &mockMessenger{commands: make(map[string]func(string))}
+}
+
+func (m *mockMessenger) Print(v ...interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.logs = append(m.logs, fmt.Sprintln(v...))
+}
+
+func (m *mockMessenger) HandleCommand(command string, handler func(string)) {
+	m.commands[command] = handler
+}
+
+func (m *mockMessenger) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (m *mockMessenger) command(command, args string) {
+	m.commands[command](args)
+}
+
+// wait waits until a log containing the text is printed
+func (m *mockMessenger) wait(t *testing.T, text string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		m.lock.Lock()
+		logs := append([]string{}, m.logs...)
+		m.lock.Unlock()
+		for _, l := range logs {
+			if strings.Contains(l, text) {
+				return
+			}
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("log %q not found: %v", text, logs)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// failingExchange fails to create stop limit orders after the first one
+type failingExchange struct {
+	exchange.Exchange
+	err   error
+	calls int
+}
+
+func (e *failingExchange) CreateStopLimit(ctx context.Context, symbol string, quantity, target, stop decimal.Decimal) (string, []string, error) {
+	e.calls++
+	if e.calls > 1 {
+		return "", nil, e.err
+	}
+	return e.Exchange.CreateStopLimit(ctx, symbol, quantity, target, stop)
+}
+
+type mockSource struct {
+	handler chan func(*signal.Message) error
+}
+
+func newMockSource() *mockSource {
+	return &mockSource{handler: make(chan func(*signal.Message) error, 1)}
+}
+
+func (s *mockSource) Run(ctx context.Context, handler func(*signal.Message) error) error {
+	s.handler <- handler
+	<-ctx.Done()
+	return nil
+}
+
+// signal sends a message once the source is running
+func (s *mockSource) signal(t *testing.T, text string) error {
+	t.Helper()
+	return s.send(t, &signal.Message{Text: text})
+}
+
+// send sends a message once the source is running
+func (s *mockSource) send(t *testing.T, msg *signal.Message) error {
+	t.Helper()
+	select {
+	case handler := <-s.handler:
+		s.handler <- handler
+		return handler(msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("source not running")
+		return nil
+	}
+}